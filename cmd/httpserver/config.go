@@ -0,0 +1,576 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is everything httpserver can be configured with. Settings come from
+// the defaults, a YAML, JSON or TOML file, HTTPFROMTCP_* environment variables and
+// flags, each overriding the ones before.
+type Config struct {
+	// "server" for the demo server, "forward" to run a forward proxy
+	Mode string `json:"mode" yaml:"mode" toml:"mode"`
+	// addresses to listen on, "unix:/path" for a Unix socket. Ignored when
+	// sockets are passed in by systemd.
+	Listen []string  `json:"listen" yaml:"listen" toml:"listen"`
+	TLS    TLSConfig `json:"tls" yaml:"tls" toml:"tls"`
+
+	ReadTimeout     Duration `json:"read_timeout" yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout    Duration `json:"write_timeout" yaml:"write_timeout" toml:"write_timeout"`
+	ShutdownTimeout Duration `json:"shutdown_timeout" yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	MaxHeaderBytes  int      `json:"max_header_bytes" yaml:"max_header_bytes" toml:"max_header_bytes"`
+	MaxBodyBytes    int64    `json:"max_body_bytes" yaml:"max_body_bytes" toml:"max_body_bytes"`
+	// caps on connections and running handlers, see server.Config. 0 means
+	// no limit.
+	MaxConns              int      `json:"max_conns" yaml:"max_conns" toml:"max_conns"`
+	MaxConnsPerIP         int      `json:"max_conns_per_ip" yaml:"max_conns_per_ip" toml:"max_conns_per_ip"`
+	MaxConcurrentRequests int      `json:"max_concurrent_requests" yaml:"max_concurrent_requests" toml:"max_concurrent_requests"`
+	MaxQueuedRequests     int      `json:"max_queued_requests" yaml:"max_queued_requests" toml:"max_queued_requests"`
+	MaxQueueWait          Duration `json:"max_queue_wait" yaml:"max_queue_wait" toml:"max_queue_wait"`
+	// directory with error page templates, see response.LoadTemplates
+	ErrorTemplates string `json:"error_templates" yaml:"error_templates" toml:"error_templates"`
+	// access log format on stdout: "common", "combined", "json" or "off"
+	AccessLog string `json:"access_log" yaml:"access_log" toml:"access_log"`
+	// path to serve Prometheus metrics on, "off" turns metrics off
+	MetricsPath string `json:"metrics_path" yaml:"metrics_path" toml:"metrics_path"`
+	// where spans go: "stdout" for JSON lines or "off"
+	Tracing string `json:"tracing" yaml:"tracing" toml:"tracing"`
+
+	RateLimit RateLimitConfig `json:"rate_limit" yaml:"rate_limit" toml:"rate_limit"`
+
+	// routes of the demo server
+	Static  []StaticRoute `json:"static" yaml:"static" toml:"static"`
+	Proxies []ProxyRoute  `json:"proxies" yaml:"proxies" toml:"proxies"`
+
+	Forward ForwardConfig `json:"forward" yaml:"forward" toml:"forward"`
+}
+
+type TLSConfig struct {
+	Cert string `json:"cert" yaml:"cert" toml:"cert"`
+	Key  string `json:"key" yaml:"key" toml:"key"`
+	// redirect plain HTTP on this port to HTTPS, 0 turns it off
+	RedirectPort int `json:"redirect_port" yaml:"redirect_port" toml:"redirect_port"`
+}
+
+// StaticRoute serves the files in Dir under Prefix.
+type StaticRoute struct {
+	Prefix  string `json:"prefix" yaml:"prefix" toml:"prefix"`
+	Dir     string `json:"dir" yaml:"dir" toml:"dir"`
+	Listing bool   `json:"listing" yaml:"listing" toml:"listing"`
+}
+
+// ProxyRoute forwards requests under Prefix to Upstreams, without the prefix.
+type ProxyRoute struct {
+	Prefix    string   `json:"prefix" yaml:"prefix" toml:"prefix"`
+	Upstreams []string `json:"upstreams" yaml:"upstreams" toml:"upstreams"`
+	// "round_robin", "least_connections" or "consistent_hash"
+	Balancer string   `json:"balancer" yaml:"balancer" toml:"balancer"`
+	Timeout  Duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+	Retries  int      `json:"retries" yaml:"retries" toml:"retries"`
+	// header consistent_hash hashes, empty hashes the path
+	HashHeader string `json:"hash_header" yaml:"hash_header" toml:"hash_header"`
+	// failures in a row that take an upstream out of rotation, 0 never does
+	MaxFails int `json:"max_fails" yaml:"max_fails" toml:"max_fails"`
+	// how long an upstream stays out, 0 means proxy.DefaultEjectDuration
+	EjectDuration Duration `json:"eject_duration" yaml:"eject_duration" toml:"eject_duration"`
+	// path to actively health check, empty turns health checks off
+	HealthCheck string `json:"health_check" yaml:"health_check" toml:"health_check"`
+	// time between health checks, 0 means 30s
+	HealthInterval Duration `json:"health_interval" yaml:"health_interval" toml:"health_interval"`
+	// how long a health check may take, 0 means the interval
+	HealthTimeout Duration `json:"health_timeout" yaml:"health_timeout" toml:"health_timeout"`
+	// bytes of responses to cache, 0 turns caching off
+	CacheSize int64 `json:"cache_size" yaml:"cache_size" toml:"cache_size"`
+	// directory to cache responses in, empty caches them in memory
+	CacheDir string `json:"cache_dir" yaml:"cache_dir" toml:"cache_dir"`
+}
+
+// RateLimitConfig limits how many requests are served per key, everything
+// but the metrics path counts.
+type RateLimitConfig struct {
+	// "token_bucket" or "sliding_window"
+	Algorithm string `json:"algorithm" yaml:"algorithm" toml:"algorithm"`
+	// requests allowed per window, 0 turns rate limiting off
+	Limit  int      `json:"limit" yaml:"limit" toml:"limit"`
+	Window Duration `json:"window" yaml:"window" toml:"window"`
+	// requests a token bucket allows at once, 0 means limit
+	Burst int `json:"burst" yaml:"burst" toml:"burst"`
+	// what requests are counted by: "ip", "route" or "header:<name>". A
+	// header only limits clients if something checks it first, since
+	// clients can send a new value with every request.
+	Key string `json:"key" yaml:"key" toml:"key"`
+	// most keys tracked at once, 0 means ratelimit.DefaultMaxKeys
+	MaxKeys int `json:"max_keys" yaml:"max_keys" toml:"max_keys"`
+}
+
+type ForwardConfig struct {
+	// hosts the forward proxy may reach, "*.example.com" for subdomains and
+	// "*" for any host. Forward mode needs at least one.
+	AllowHosts []string `json:"allow_hosts" yaml:"allow_hosts" toml:"allow_hosts"`
+	AllowPorts []int    `json:"allow_ports" yaml:"allow_ports" toml:"allow_ports"`
+	// how long a forwarded request may take, 0 means no limit. Tunnels
+	// aren't limited.
+	Timeout Duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+}
+
+// Duration reads "10s"-style durations from config files.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+const envPrefix = "HTTPFROMTCP_"
+
+func defaultConfig() *Config {
+	return &Config{
+		Mode:            "server",
+		Listen:          []string{":" + strconv.Itoa(port)},
+		ReadTimeout:     Duration(30 * time.Second),
+		ShutdownTimeout: Duration(10 * time.Second),
+		MaxHeaderBytes:  1 << 20,
+		MaxBodyBytes:    10 << 20,
+		AccessLog:       "combined",
+		MetricsPath:     "/metrics",
+		Tracing:         "off",
+		Static:          []StaticRoute{{Prefix: "/assets", Dir: "assets", Listing: true}},
+		Proxies: []ProxyRoute{{
+			Prefix:         "/httpbin",
+			Upstreams:      []string{"https://httpbin.org"},
+			Balancer:       "round_robin",
+			Timeout:        Duration(30 * time.Second),
+			Retries:        1,
+			MaxFails:       3,
+			EjectDuration:  Duration(30 * time.Second),
+			HealthCheck:    "/status/200",
+			HealthInterval: Duration(30 * time.Second),
+			HealthTimeout:  Duration(5 * time.Second),
+			CacheSize:      64 << 20,
+		}},
+		RateLimit: RateLimitConfig{
+			Algorithm: "token_bucket",
+			Window:    Duration(time.Minute),
+			Key:       "ip",
+		},
+		Forward: ForwardConfig{AllowPorts: []int{80, 443}, Timeout: Duration(30 * time.Second)},
+	}
+}
+
+// setting is a config value that can be set from a flag and an environment
+// variable.
+type setting struct {
+	name  string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{"mode", `"server" for the demo server, "forward" to run a forward proxy`, func(c *Config, v string) error {
+		c.Mode = v
+		return nil
+	}},
+	{"listen", `comma-separated addresses to listen on, "unix:/path" for a Unix socket`, func(c *Config, v string) error {
+		c.Listen = splitList(v)
+		return nil
+	}},
+	{"cert", "PEM certificate file, serves HTTPS when set", func(c *Config, v string) error {
+		c.TLS.Cert = v
+		return nil
+	}},
+	{"key", "PEM key file for -cert", func(c *Config, v string) error {
+		c.TLS.Key = v
+		return nil
+	}},
+	{"redirect-port", "with -cert, redirect plain HTTP on this port to HTTPS", intSetter(func(c *Config) *int { return &c.TLS.RedirectPort })},
+	{"read-timeout", "how long reading a request may take, e.g. 30s", durationSetter(func(c *Config) *Duration { return &c.ReadTimeout })},
+	{"write-timeout", "how long writing a response may take", durationSetter(func(c *Config) *Duration { return &c.WriteTimeout })},
+	{"shutdown-timeout", "how long in-flight requests get to finish on shutdown", durationSetter(func(c *Config) *Duration { return &c.ShutdownTimeout })},
+	{"max-header-bytes", "largest request line and headers accepted", intSetter(func(c *Config) *int { return &c.MaxHeaderBytes })},
+	{"max-body-bytes", "largest request body accepted", func(c *Config, v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q isn't a number", v)
+		}
+		c.MaxBodyBytes = n
+		return nil
+	}},
+	{"max-conns", "most connections served at once, 0 means no limit", intSetter(func(c *Config) *int { return &c.MaxConns })},
+	{"max-conns-per-ip", "most connections served at once per client IP", intSetter(func(c *Config) *int { return &c.MaxConnsPerIP })},
+	{"max-concurrent-requests", "most handlers running at once, others wait in a queue", intSetter(func(c *Config) *int { return &c.MaxConcurrentRequests })},
+	{"max-queued-requests", "most requests waiting for a handler", intSetter(func(c *Config) *int { return &c.MaxQueuedRequests })},
+	{"max-queue-wait", "how long a request may wait for a handler, e.g. 5s", durationSetter(func(c *Config) *Duration { return &c.MaxQueueWait })},
+	{"error-templates", "directory with error page templates", func(c *Config, v string) error {
+		c.ErrorTemplates = v
+		return nil
+	}},
+	{"access-log", `access log format on stdout: "common", "combined", "json" or "off"`, func(c *Config, v string) error {
+		c.AccessLog = v
+		return nil
+	}},
+	{"metrics-path", `path to serve Prometheus metrics on, "off" turns metrics off`, func(c *Config, v string) error {
+		c.MetricsPath = v
+		return nil
+	}},
+	{"tracing", `where spans go: "stdout" for JSON lines or "off"`, func(c *Config, v string) error {
+		c.Tracing = v
+		return nil
+	}},
+	{"allow-hosts", "comma-separated hosts the forward proxy may reach, e.g. example.com,*.example.org", func(c *Config, v string) error {
+		c.Forward.AllowHosts = splitList(v)
+		return nil
+	}},
+	{"allow-ports", "comma-separated ports the forward proxy may reach", func(c *Config, v string) error {
+		var ports []int
+		for _, field := range splitList(v) {
+			port, err := strconv.Atoi(field)
+			if err != nil {
+				return fmt.Errorf("%q isn't a port", field)
+			}
+			ports = append(ports, port)
+		}
+		c.Forward.AllowPorts = ports
+		return nil
+	}},
+	{"forward-timeout", "how long a request through the forward proxy may take, e.g. 30s", durationSetter(func(c *Config) *Duration { return &c.Forward.Timeout })},
+}
+
+func intSetter(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%q isn't a number", v)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func durationSetter(field func(*Config) *Duration) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%q isn't a duration", v)
+		}
+		*field(c) = Duration(d)
+		return nil
+	}
+}
+
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// envName is the environment variable for a setting, e.g.
+// HTTPFROMTCP_READ_TIMEOUT for read-timeout.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// loadConfig builds the config from args (without the program name) and the
+// environment, then validates it.
+func loadConfig(args []string, getenv func(string) string) (*Config, error) {
+	fs := flag.NewFlagSet("httpserver", flag.ContinueOnError)
+	configFile := fs.String("config", getenv(envName("config")), "YAML, JSON or TOML config file")
+	flagValues := map[string]string{}
+	for _, s := range settings {
+		fs.Func(s.name, s.usage+" ($"+envName(s.name)+")", func(v string) error {
+			flagValues[s.name] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := defaultConfig()
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range settings {
+		if v := getenv(envName(s.name)); v != "" {
+			if err := s.set(cfg, v); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", envName(s.name), err)
+			}
+		}
+	}
+	for _, s := range settings {
+		if v, ok := flagValues[s.name]; ok {
+			if err := s.set(cfg, v); err != nil {
+				return nil, fmt.Errorf("invalid -%s: %v", s.name, err)
+			}
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config:\n%v", err)
+	}
+	return cfg, nil
+}
+
+// loadFile reads a config file over c, picking the format by extension.
+// Fields the file leaves out keep their values, unknown fields are errors.
+func (c *Config) loadFile(name string) error {
+	ext := strings.ToLower(filepath.Ext(name))
+	switch ext {
+	case ".yaml", ".yml", ".json", ".toml":
+	default:
+		return fmt.Errorf("config file %s: unknown format %q, use .yaml, .yml, .json or .toml", name, ext)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	// route lists in the file replace the default ones whole. Decoding over
+	// them would fill in whatever a route leaves out from the default route
+	// at the same index.
+	static, proxies := c.Static, c.Proxies
+	c.Static, c.Proxies = nil, nil
+	defer func() {
+		if c.Static == nil {
+			c.Static = static
+		}
+		if c.Proxies == nil {
+			c.Proxies = proxies
+		}
+	}()
+	switch ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("invalid config file %s: %v", name, err)
+		}
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(c); err != nil {
+			return fmt.Errorf("invalid config file %s: %v", name, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), c)
+		if err != nil {
+			return fmt.Errorf("invalid config file %s: %v", name, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("invalid config file %s: unknown field %s", name, undecoded[0])
+		}
+	}
+	return nil
+}
+
+var balancers = map[string]bool{"": true, "round_robin": true, "least_connections": true, "consistent_hash": true}
+
+// Validate reports every invalid field, named the way the config file
+// names it.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+	validPort := func(port int) bool { return port >= 1 && port <= 65535 }
+
+	if c.Mode != "server" && c.Mode != "forward" {
+		fail("mode", "%q isn't server or forward", c.Mode)
+	}
+	if len(c.Listen) == 0 {
+		fail("listen", "no addresses to listen on")
+	}
+	for i, addr := range c.Listen {
+		if addr == "" {
+			fail(fmt.Sprintf("listen[%d]", i), "empty address")
+		}
+	}
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		fail("tls", "cert and key have to be set together")
+	}
+	if c.TLS.RedirectPort != 0 {
+		if c.TLS.Cert == "" {
+			fail("tls.redirect_port", "redirecting to HTTPS needs tls.cert")
+		}
+		if !validPort(c.TLS.RedirectPort) {
+			fail("tls.redirect_port", "%d isn't a valid port", c.TLS.RedirectPort)
+		}
+	}
+	if c.ReadTimeout < 0 {
+		fail("read_timeout", "must not be negative")
+	}
+	if c.WriteTimeout < 0 {
+		fail("write_timeout", "must not be negative")
+	}
+	if c.ShutdownTimeout < 0 {
+		fail("shutdown_timeout", "must not be negative")
+	}
+	switch c.AccessLog {
+	case "common", "combined", "json", "off":
+	default:
+		fail("access_log", "%q isn't common, combined, json or off", c.AccessLog)
+	}
+	if c.Tracing != "stdout" && c.Tracing != "off" {
+		fail("tracing", "%q isn't stdout or off", c.Tracing)
+	}
+	if c.RateLimit.Limit < 0 {
+		fail("rate_limit.limit", "must not be negative")
+	}
+	if c.RateLimit.Limit > 0 {
+		if c.RateLimit.Algorithm != "token_bucket" && c.RateLimit.Algorithm != "sliding_window" {
+			fail("rate_limit.algorithm", "%q isn't token_bucket or sliding_window", c.RateLimit.Algorithm)
+		}
+		if c.RateLimit.Window <= 0 {
+			fail("rate_limit.window", "must be positive")
+		}
+		if c.RateLimit.Burst < 0 {
+			fail("rate_limit.burst", "must not be negative")
+		}
+		if c.RateLimit.MaxKeys < 0 {
+			fail("rate_limit.max_keys", "must not be negative")
+		}
+		if name, ok := strings.CutPrefix(c.RateLimit.Key, "header:"); ok && name == "" {
+			fail("rate_limit.key", "header: needs a header name")
+		} else if !ok && c.RateLimit.Key != "ip" && c.RateLimit.Key != "route" {
+			fail("rate_limit.key", "%q isn't ip, route or header:<name>", c.RateLimit.Key)
+		}
+	}
+	if c.MaxHeaderBytes < 0 {
+		fail("max_header_bytes", "must not be negative")
+	}
+	if c.MaxBodyBytes < 0 {
+		fail("max_body_bytes", "must not be negative")
+	}
+	for _, f := range []struct {
+		name  string
+		value int
+	}{
+		{"max_conns", c.MaxConns},
+		{"max_conns_per_ip", c.MaxConnsPerIP},
+		{"max_concurrent_requests", c.MaxConcurrentRequests},
+		{"max_queued_requests", c.MaxQueuedRequests},
+	} {
+		if f.value < 0 {
+			fail(f.name, "must not be negative")
+		}
+	}
+	if c.MaxQueueWait < 0 {
+		fail("max_queue_wait", "must not be negative")
+	}
+
+	prefixes := map[string]string{}
+	checkPrefix := func(field, prefix string) {
+		if !strings.HasPrefix(prefix, "/") || (len(prefix) > 1 && strings.HasSuffix(prefix, "/")) {
+			fail(field, "%q has to start with / and not end with one", prefix)
+			return
+		}
+		if other, ok := prefixes[prefix]; ok {
+			fail(field, "%q is already used by %s", prefix, other)
+		}
+		prefixes[prefix] = field
+	}
+	if c.MetricsPath != "off" {
+		checkPrefix("metrics_path", c.MetricsPath)
+	}
+	for i, r := range c.Static {
+		field := fmt.Sprintf("static[%d]", i)
+		checkPrefix(field+".prefix", r.Prefix)
+		if r.Dir == "" {
+			fail(field+".dir", "missing")
+		}
+	}
+	cacheDirs := map[string]string{}
+	for i, r := range c.Proxies {
+		field := fmt.Sprintf("proxies[%d]", i)
+		checkPrefix(field+".prefix", r.Prefix)
+		if len(r.Upstreams) == 0 {
+			fail(field+".upstreams", "at least one upstream is needed")
+		}
+		for j, upstream := range r.Upstreams {
+			if !strings.HasPrefix(upstream, "http://") && !strings.HasPrefix(upstream, "https://") {
+				fail(fmt.Sprintf("%s.upstreams[%d]", field, j), "%q isn't an http or https URL", upstream)
+			}
+		}
+		if !balancers[r.Balancer] {
+			fail(field+".balancer", "%q isn't round_robin, least_connections or consistent_hash", r.Balancer)
+		}
+		if r.Timeout < 0 {
+			fail(field+".timeout", "must not be negative")
+		}
+		if r.Retries < 0 {
+			fail(field+".retries", "must not be negative")
+		}
+		if r.HashHeader != "" && r.Balancer != "consistent_hash" {
+			fail(field+".hash_header", "only consistent_hash hashes a header")
+		}
+		if r.MaxFails < 0 {
+			fail(field+".max_fails", "must not be negative")
+		}
+		if r.EjectDuration < 0 {
+			fail(field+".eject_duration", "must not be negative")
+		}
+		if r.HealthCheck != "" && !strings.HasPrefix(r.HealthCheck, "/") {
+			fail(field+".health_check", "%q has to be a path", r.HealthCheck)
+		}
+		if r.HealthInterval < 0 {
+			fail(field+".health_interval", "must not be negative")
+		}
+		if r.HealthTimeout < 0 {
+			fail(field+".health_timeout", "must not be negative")
+		}
+		if r.CacheSize < 0 {
+			fail(field+".cache_size", "must not be negative")
+		}
+		if r.CacheDir != "" {
+			if r.CacheSize == 0 {
+				fail(field+".cache_dir", "needs a cache_size")
+			}
+			dir := filepath.Clean(r.CacheDir)
+			if other, ok := cacheDirs[dir]; ok {
+				fail(field+".cache_dir", "%q is already used by %s", r.CacheDir, other)
+			}
+			cacheDirs[dir] = field + ".cache_dir"
+		}
+	}
+	for i, port := range c.Forward.AllowPorts {
+		if !validPort(port) {
+			fail(fmt.Sprintf("forward.allow_ports[%d]", i), "%d isn't a valid port", port)
+		}
+	}
+	if c.Mode == "forward" && len(c.Forward.AllowHosts) == 0 {
+		fail("forward.allow_hosts", "forward mode needs the hosts it may reach, \"*\" allows any")
+	}
+	if c.Forward.Timeout < 0 {
+		fail("forward.timeout", "must not be negative")
+	}
+	for i, host := range c.Forward.AllowHosts {
+		if host == "" || strings.ContainsAny(host, "/: ") {
+			fail(fmt.Sprintf("forward.allow_hosts[%d]", i), "%q isn't a host name", host)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func envFrom(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := loadConfig(nil, envFrom(nil))
+	require.NoError(t, err)
+
+	// Test: without any settings the demo server runs as before
+	assert.Equal(t, "server", cfg.Mode)
+	assert.Equal(t, []string{":42069"}, cfg.Listen)
+	assert.Equal(t, Duration(10*time.Second), cfg.ShutdownTimeout)
+	require.Len(t, cfg.Proxies, 1)
+	assert.Equal(t, "/httpbin", cfg.Proxies[0].Prefix)
+	assert.Equal(t, []string{"https://httpbin.org"}, cfg.Proxies[0].Upstreams)
+
+	// Test: slow or oversized requests are cut off unless configured otherwise
+	assert.Equal(t, Duration(30*time.Second), cfg.ReadTimeout)
+	assert.Equal(t, 1<<20, cfg.MaxHeaderBytes)
+	assert.Equal(t, int64(10<<20), cfg.MaxBodyBytes)
+	require.Len(t, cfg.Static, 1)
+	assert.Equal(t, "assets", cfg.Static[0].Dir)
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	file := writeConfigFile(t, "config.yaml", `
+listen: ["127.0.0.1:8080", "unix:/tmp/app.sock"]
+read_timeout: 5s
+write_timeout: 10s
+max_body_bytes: 1024
+static:
+  - prefix: /public
+    dir: ./public
+proxies:
+  - prefix: /api
+    upstreams: [http://10.0.0.1:9000, http://10.0.0.2:9000]
+    balancer: least_connections
+    timeout: 2s
+    max_fails: 5
+    eject_duration: 1m
+    health_check: /healthz
+    health_interval: 10s
+    health_timeout: 1s
+`)
+
+	// Test: the file overrides the defaults, leaving out what it doesn't set
+	cfg, err := loadConfig([]string{"-config", file}, envFrom(nil))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.1:8080", "unix:/tmp/app.sock"}, cfg.Listen)
+	assert.Equal(t, Duration(5*time.Second), cfg.ReadTimeout)
+	assert.Equal(t, int64(1024), cfg.MaxBodyBytes)
+	assert.Equal(t, []StaticRoute{{Prefix: "/public", Dir: "./public"}}, cfg.Static)
+	assert.Equal(t, []ProxyRoute{{
+		Prefix:         "/api",
+		Upstreams:      []string{"http://10.0.0.1:9000", "http://10.0.0.2:9000"},
+		Balancer:       "least_connections",
+		Timeout:        Duration(2 * time.Second),
+		MaxFails:       5,
+		EjectDuration:  Duration(time.Minute),
+		HealthCheck:    "/healthz",
+		HealthInterval: Duration(10 * time.Second),
+		HealthTimeout:  Duration(time.Second),
+	}}, cfg.Proxies)
+	assert.Equal(t, Duration(10*time.Second), cfg.ShutdownTimeout)
+
+	// Test: environment variables override the file, flags override both
+	env := envFrom(map[string]string{
+		"HTTPFROMTCP_CONFIG":       file,
+		"HTTPFROMTCP_READ_TIMEOUT": "7s",
+		"HTTPFROMTCP_LISTEN":       ":9000",
+	})
+	cfg, err = loadConfig([]string{"-listen", ":9001, :9002"}, env)
+	require.NoError(t, err)
+	assert.Equal(t, Duration(7*time.Second), cfg.ReadTimeout)
+	assert.Equal(t, []string{":9001", ":9002"}, cfg.Listen)
+	assert.Equal(t, int64(1024), cfg.MaxBodyBytes)
+
+	// Test: JSON files work the same
+	file = writeConfigFile(t, "config.json", `{
+		"mode": "forward",
+		"forward": {"allow_hosts": ["*.example.com"], "allow_ports": [443], "timeout": "5s"},
+		"static": [{"prefix": "/public", "dir": "./public"}],
+		"proxies": [{"prefix": "/api", "upstreams": ["http://10.0.0.1:9000"], "cache_size": 1024, "cache_dir": "cache"}]
+	}`)
+	cfg, err = loadConfig([]string{"-config", file}, envFrom(nil))
+	require.NoError(t, err)
+	assert.Equal(t, "forward", cfg.Mode)
+	assert.Equal(t, []string{"*.example.com"}, cfg.Forward.AllowHosts)
+	assert.Equal(t, []int{443}, cfg.Forward.AllowPorts)
+	assert.Equal(t, Duration(5*time.Second), cfg.Forward.Timeout)
+	assert.Equal(t, []StaticRoute{{Prefix: "/public", Dir: "./public"}}, cfg.Static)
+	assert.Equal(t, []ProxyRoute{{Prefix: "/api", Upstreams: []string{"http://10.0.0.1:9000"}, CacheSize: 1024, CacheDir: "cache"}}, cfg.Proxies)
+
+	// Test: a file without routes keeps the default ones
+	file = writeConfigFile(t, "noroutes.json", `{"read_timeout": "5s"}`)
+	cfg, err = loadConfig([]string{"-config", file}, envFrom(nil))
+	require.NoError(t, err)
+	assert.Equal(t, defaultConfig().Static, cfg.Static)
+	assert.Equal(t, defaultConfig().Proxies, cfg.Proxies)
+
+	// Test: and so do TOML files
+	file = writeConfigFile(t, "config.toml", `
+listen = ["127.0.0.1:8080"]
+read_timeout = "5s"
+max_body_bytes = 1024
+
+[[static]]
+prefix = "/public"
+dir = "./public"
+
+[[proxies]]
+prefix = "/api"
+upstreams = ["http://10.0.0.1:9000"]
+timeout = "2s"
+
+[rate_limit]
+limit = 10
+key = "route"
+`)
+	cfg, err = loadConfig([]string{"-config", file}, envFrom(nil))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.1:8080"}, cfg.Listen)
+	assert.Equal(t, Duration(5*time.Second), cfg.ReadTimeout)
+	assert.Equal(t, int64(1024), cfg.MaxBodyBytes)
+	assert.Equal(t, []StaticRoute{{Prefix: "/public", Dir: "./public"}}, cfg.Static)
+	assert.Equal(t, []ProxyRoute{{Prefix: "/api", Upstreams: []string{"http://10.0.0.1:9000"}, Timeout: Duration(2 * time.Second)}}, cfg.Proxies)
+	assert.Equal(t, 10, cfg.RateLimit.Limit)
+	assert.Equal(t, "route", cfg.RateLimit.Key)
+	assert.Equal(t, "token_bucket", cfg.RateLimit.Algorithm)
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		file string
+		args []string
+		env  map[string]string
+		want []string
+	}{
+		"unknown yaml field": {
+			file: "bad.yaml", args: []string{"-config"},
+			want: []string{"read_timout"},
+		},
+		"unknown json field": {
+			file: "bad.json", args: []string{"-config"},
+			want: []string{"read_timout"},
+		},
+		"unknown toml field": {
+			file: "bad.toml", args: []string{"-config"},
+			want: []string{"unknown field rate_limit.algoritm"},
+		},
+		"malformed toml": {
+			file: "malformed.toml", args: []string{"-config"},
+			want: []string{"invalid config file", "read_timeout"},
+		},
+		"invalid toml values": {
+			file: "invalid.toml", args: []string{"-config"},
+			want: []string{
+				"mode: \"proxy\" isn't server or forward",
+				"proxies[0].balancer",
+			},
+		},
+		"unknown format": {
+			file: "config.ini", args: []string{"-config"},
+			want: []string{"unknown format \".ini\""},
+		},
+		"forward without hosts": {
+			args: []string{"-mode", "forward"},
+			want: []string{"forward.allow_hosts: forward mode needs the hosts it may reach"},
+		},
+		"bad env var": {
+			env:  map[string]string{"HTTPFROMTCP_READ_TIMEOUT": "soon"},
+			want: []string{"HTTPFROMTCP_READ_TIMEOUT"},
+		},
+		"bad flag": {
+			args: []string{"-max-header-bytes", "lots"},
+			want: []string{"-max-header-bytes"},
+		},
+		"invalid values": {
+			file: "invalid.yaml", args: []string{"-config"},
+			want: []string{
+				"mode: \"proxy\" isn't server or forward",
+				"proxies[0].upstreams[1]: \"ftp://files\" isn't an http or https URL",
+				"proxies[0].balancer",
+				"proxies[0].hash_header: only consistent_hash hashes a header",
+				"proxies[0].max_fails: must not be negative",
+				"proxies[0].health_interval: must not be negative",
+				"proxies[0].cache_dir: needs a cache_size",
+				"proxies[1].cache_dir: \"./cache\" is already used by proxies[0].cache_dir",
+				"proxies[0].prefix: \"/api\" is already used by static[0].prefix",
+				"static[0].dir: missing",
+				"tls: cert and key have to be set together",
+				"forward.allow_ports[0]: 0 isn't a valid port",
+				"rate_limit.max_keys: must not be negative",
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			args := tc.args
+			if tc.file != "" {
+				content := map[string]string{
+					"bad.yaml":       "read_timout: 5s\n",
+					"bad.json":       `{"read_timout": "5s"}`,
+					"bad.toml":       "[rate_limit]\nalgoritm = \"token_bucket\"\n",
+					"malformed.toml": "read_timeout = \"soon\"\n",
+					"invalid.toml":   "mode = \"proxy\"\n\n[[proxies]]\nprefix = \"/api\"\nupstreams = [\"http://ok\"]\nbalancer = \"random\"\n",
+					"config.ini":     "mode = server\n",
+					"invalid.yaml": `
+mode: proxy
+tls: {cert: cert.pem}
+proxies:
+  - prefix: /api
+    upstreams: [http://ok, ftp://files]
+    balancer: random
+    hash_header: X-Session-Id
+    max_fails: -1
+    health_interval: -1s
+    cache_dir: cache
+  - prefix: /other
+    upstreams: [http://ok]
+    cache_size: 1024
+    cache_dir: ./cache
+static:
+  - prefix: /api
+forward:
+  allow_ports: [0]
+tracing: jaeger
+rate_limit: {limit: 10, algorithm: leaky_bucket, key: user, max_keys: -1}
+max_conns_per_ip: -1
+`,
+				}[tc.file]
+				args = append(args, writeConfigFile(t, tc.file, content))
+			}
+			_, err := loadConfig(args, envFrom(tc.env))
+			require.Error(t, err)
+			// Test: the error points at what's wrong
+			for _, want := range tc.want {
+				assert.Contains(t, err.Error(), want)
+			}
+		})
+	}
+}
+
+func TestMatchPrefix(t *testing.T) {
+	// Test: a prefix matches itself and paths below it only
+	assert.True(t, matchPrefix("/api", "/api"))
+	assert.True(t, matchPrefix("/api/users", "/api"))
+	assert.True(t, matchPrefix("/api?x=1", "/api"))
+	assert.False(t, matchPrefix("/apiary", "/api"))
+	assert.False(t, matchPrefix("/", "/api"))
+}
+
+func TestRouteName(t *testing.T) {
+	cfg, err := loadConfig(nil, envFrom(nil))
+	require.NoError(t, err)
+	routes = []route{{prefix: "/httpbin"}}
+	t.Cleanup(func() { routes = nil })
+	name := func(target string) string {
+		return routeName(cfg, &request.Request{RequestLine: request.RequestLine{RequestTarget: target}})
+	}
+
+	// Test: requests are named after the route serving them
+	assert.Equal(t, "/httpbin", name("/httpbin/get?x=1"))
+	assert.Equal(t, "/video", name("/video"))
+	assert.Equal(t, "/metrics", name("/metrics"))
+
+	// Test: other paths share one name
+	assert.Equal(t, "other", name("/"))
+	assert.Equal(t, "other", name("/some/random/path"))
+
+	// Test: metrics_path has to be a free path or off
+	_, err = loadConfig([]string{"-metrics-path", "/httpbin"}, envFrom(nil))
+	assert.ErrorContains(t, err, `proxies[0].prefix: "/httpbin" is already used by metrics_path`)
+	cfg, err = loadConfig([]string{"-metrics-path", "off"}, envFrom(nil))
+	require.NoError(t, err)
+	assert.Equal(t, "other", name("/metrics"))
+}
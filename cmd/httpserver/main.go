@@ -1,46 +1,319 @@
 package main
 
 import (
-	"crypto/sha256"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/joeljosephwebdev/httpfromtcp/internal/cache"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/fileserver"
 	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/metrics"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/proxy"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/ratelimit"
 	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
 	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
 	"github.com/joeljosephwebdev/httpfromtcp/internal/server"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/tracing"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/websocket"
 )
 
+// the default port to listen on
 const port = 42069
 
+// routes from the config, checked in order before the built-in demo routes
+var routes []route
+
+type route struct {
+	prefix  string
+	handler server.Handler
+}
+
 func main() {
-	server, err := server.Serve(port, handler)
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var tracer *tracing.Tracer
+	if cfg.Tracing == "stdout" {
+		tracer = tracing.NewTracer(tracing.NewJSONExporter(os.Stdout))
+	}
+
+	var handle server.Handler
+	switch cfg.Mode {
+	case "server":
+		var closers []func()
+		handle, closers, err = demoHandler(cfg, tracer)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, close := range closers {
+			defer close()
+		}
+	case "forward":
+		handle = proxy.NewForward(proxy.ForwardConfig{
+			AllowedPorts: cfg.Forward.AllowPorts,
+			AllowedHosts: cfg.Forward.AllowHosts,
+			Timeout:      time.Duration(cfg.Forward.Timeout),
+		}).Handle
+	}
+
+	if limit := cfg.rateLimit(); limit != nil {
+		handle = server.Chain(handle, limit)
+	}
+
+	var serverMetrics *server.Metrics
+	if cfg.MetricsPath != "off" {
+		reg := metrics.NewRegistry()
+		serverMetrics = server.NewMetrics(reg)
+		serverMetrics.Route = func(req *request.Request) string { return routeName(cfg, req) }
+		handle = withMetrics(cfg.MetricsPath, reg.Handler(), handle)
+	}
+
+	if logger := cfg.accessLogger(); logger != nil {
+		handle = server.Chain(handle, server.RequestID(), server.AccessLog(logger))
+	}
+
+	serverConfig, err := cfg.serverConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	serverConfig.Metrics = serverMetrics
+	serverConfig.Tracer = tracer
+	srv, err := server.ServeConfig(serverConfig, handle)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
-	log.Println("Server started on port", port)
+	for _, addr := range srv.Addrs() {
+		log.Printf("Server listening on %s %s in %s mode", addr.Network(), addr, cfg.Mode)
+	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
 	log.Println("Server gracefully stopped")
 }
 
+// serverConfig turns cfg into the server's settings, using sockets passed in
+// by systemd instead of cfg.Listen if there are any.
+func (c *Config) serverConfig() (server.Config, error) {
+	sc := server.Config{
+		ReadTimeout:    time.Duration(c.ReadTimeout),
+		WriteTimeout:   time.Duration(c.WriteTimeout),
+		MaxHeaderBytes: c.MaxHeaderBytes,
+		MaxBodyBytes:   c.MaxBodyBytes,
+
+		MaxConns:              c.MaxConns,
+		MaxConnsPerIP:         c.MaxConnsPerIP,
+		MaxConcurrentRequests: c.MaxConcurrentRequests,
+		MaxQueuedRequests:     c.MaxQueuedRequests,
+		MaxQueueWait:          time.Duration(c.MaxQueueWait),
+	}
+	listeners, err := server.InheritedListeners()
+	if err != nil {
+		return sc, fmt.Errorf("error using inherited sockets: %v", err)
+	}
+	if len(listeners) > 0 {
+		sc.Listeners = listeners
+	} else {
+		sc.Addrs = c.Listen
+	}
+	if c.TLS.Cert != "" {
+		sc.TLS = &server.TLSConfig{
+			Certificates: []server.CertFiles{{CertFile: c.TLS.Cert, KeyFile: c.TLS.Key}},
+			RedirectPort: c.TLS.RedirectPort,
+		}
+	}
+	if c.ErrorTemplates != "" {
+		sc.ErrorTemplates, err = response.LoadTemplates(os.DirFS(c.ErrorTemplates))
+		if err != nil {
+			return sc, fmt.Errorf("error loading error_templates: %v", err)
+		}
+	}
+	return sc, nil
+}
+
+// accessLogger logs requests to stdout in the configured format, nil if
+// access logging is off.
+func (c *Config) accessLogger() *slog.Logger {
+	switch c.AccessLog {
+	case "common":
+		return slog.New(server.NewCommonLogHandler(os.Stdout))
+	case "combined":
+		return slog.New(server.NewCombinedLogHandler(os.Stdout))
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	}
+	return nil
+}
+
+// rateLimit is the configured rate limiting middleware, nil if it's off.
+func (c *Config) rateLimit() server.Middleware {
+	rl := c.RateLimit
+	if rl.Limit == 0 {
+		return nil
+	}
+	var limiter ratelimit.Limiter
+	if rl.Algorithm == "sliding_window" {
+		sw := ratelimit.NewSlidingWindow(rl.Limit, time.Duration(rl.Window))
+		sw.SetMaxKeys(rl.MaxKeys)
+		limiter = sw
+	} else {
+		tb := ratelimit.NewTokenBucket(rl.Limit, time.Duration(rl.Window), rl.Burst)
+		tb.SetMaxKeys(rl.MaxKeys)
+		limiter = tb
+	}
+	var key ratelimit.KeyFunc
+	switch {
+	case rl.Key == "route":
+		key = ratelimit.ByRoute(func(req *request.Request) string { return routeName(c, req) })
+	case strings.HasPrefix(rl.Key, "header:"):
+		key = ratelimit.ByHeader(strings.TrimPrefix(rl.Key, "header:"))
+	default:
+		key = ratelimit.ByIP()
+	}
+	return ratelimit.Middleware(limiter, key)
+}
+
+// demoHandler sets up the configured routes and returns the demo server's
+// handler, along with what to close on shutdown. Proxied calls are traced
+// with tracer if it isn't nil.
+func demoHandler(cfg *Config, tracer *tracing.Tracer) (server.Handler, []func(), error) {
+	var closers []func()
+	for _, r := range cfg.Static {
+		routes = append(routes, route{r.Prefix, fileserver.Dir(r.Dir, fileserver.Options{Prefix: r.Prefix, Listing: r.Listing})})
+	}
+	for i, r := range cfg.Proxies {
+		pc := proxy.Config{
+			Upstreams:     r.Upstreams,
+			StripPrefix:   r.Prefix,
+			Timeout:       time.Duration(r.Timeout),
+			Retries:       r.Retries,
+			MaxFails:      r.MaxFails,
+			EjectDuration: time.Duration(r.EjectDuration),
+			Tracer:        tracer,
+		}
+		switch r.Balancer {
+		case "least_connections":
+			pc.Balancer = proxy.LeastConnections{}
+		case "consistent_hash":
+			pc.Balancer = proxy.ConsistentHash{Header: r.HashHeader}
+		default:
+			pc.Balancer = &proxy.RoundRobin{}
+		}
+		if r.HealthCheck != "" {
+			pc.HealthCheck = proxy.HealthCheck{
+				Path:     r.HealthCheck,
+				Interval: time.Duration(r.HealthInterval),
+				Timeout:  time.Duration(r.HealthTimeout),
+			}
+			if pc.HealthCheck.Interval == 0 {
+				pc.HealthCheck.Interval = 30 * time.Second
+			}
+		}
+		p, err := proxy.New(pc)
+		if err != nil {
+			return nil, closers, fmt.Errorf("proxies[%d]: %v", i, err)
+		}
+		closers = append(closers, func() { p.Close() })
+		h := p.Handle
+		if r.CacheSize > 0 {
+			var store cache.Store = cache.NewMemoryStore(r.CacheSize)
+			if r.CacheDir != "" {
+				if store, err = cache.NewDiskStore(r.CacheDir, r.CacheSize); err != nil {
+					return nil, closers, fmt.Errorf("proxies[%d]: %v", i, err)
+				}
+			}
+			h = cache.New(store, cache.Options{}).Middleware(h)
+		}
+		routes = append(routes, route{r.Prefix, h})
+	}
+	return server.Chain(handler, server.Compress(response.DefaultCompressionConfig)), closers, nil
+}
+
+// withMetrics serves the metrics on path and everything else with next.
+func withMetrics(path string, metricsHandler, next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		if matchPrefix(req.RequestLine.RequestTarget, path) {
+			metricsHandler(w, req)
+			return
+		}
+		next(w, req)
+	}
+}
+
+// the demo server's built-in routes, as named in metrics
+var builtinRoutes = []string{"/video", "/ws", "/events", "/yourproblem", "/myproblem"}
+
+// routeName names the route serving req in metrics, the prefix of a
+// configured or built-in route. Anything else is "other" so arbitrary paths
+// can't add series.
+func routeName(cfg *Config, req *request.Request) string {
+	target := req.RequestLine.RequestTarget
+	if cfg.MetricsPath != "off" && matchPrefix(target, cfg.MetricsPath) {
+		return cfg.MetricsPath
+	}
+	if cfg.Mode == "forward" {
+		return "forward"
+	}
+	for _, r := range routes {
+		if matchPrefix(target, r.prefix) {
+			return r.prefix
+		}
+	}
+	for _, prefix := range builtinRoutes {
+		if matchPrefix(target, prefix) {
+			return prefix
+		}
+	}
+	return "other"
+}
+
+// matchPrefix reports whether target is prefix or a path below it.
+func matchPrefix(target, prefix string) bool {
+	rest, ok := strings.CutPrefix(target, prefix)
+	return ok && (rest == "" || rest[0] == '/' || rest[0] == '?')
+}
+
 func handler(w *response.Writer, req *request.Request) {
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin") {
-		proxyHandler(w, req)
-		return
+	for _, r := range routes {
+		if matchPrefix(req.RequestLine.RequestTarget, r.prefix) {
+			r.handler(w, req)
+			return
+		}
 	}
 
 	if strings.HasPrefix(req.RequestLine.RequestTarget, "/video") {
-		videoHandler(w, req)
+		fileserver.ServeFile(w, req, "assets/vim.mp4")
+		return
+	}
+
+	if req.RequestLine.RequestTarget == "/ws" {
+		echoHandler(w, req)
+		return
+	}
+
+	if req.RequestLine.RequestTarget == "/events" {
+		eventsHandler(w, req)
 		return
 	}
 
@@ -62,105 +335,59 @@ func handler(w *response.Writer, req *request.Request) {
 	w.WriteBody(body)
 }
 
-func videoHandler(w *response.Writer, r *request.Request) {
-	respHeaders := response.GetDefaultHeaders(0)
-	respHeaders.Set("Content-Type", "video/mp4")
-
-	videoFile, err := os.Open("assets/vim.mp4")
+func eventsHandler(w *response.Writer, req *request.Request) {
+	stream, err := response.NewEventStream(w, req)
 	if err != nil {
-		writeServerError(w, r)
-		log.Printf("Error opening video file: %v\n", err)
+		log.Printf("Error starting event stream: %v\n", err)
 		return
 	}
-	defer videoFile.Close()
+	defer stream.Close()
+	stream.Heartbeat(15 * time.Second)
 
-	videoData, err := io.ReadAll(videoFile)
-	if err != nil {
-		writeServerError(w, r)
-		log.Printf("Error reading video file: %v\n", err)
-		return
+	// pick the count up where a reconnecting client left off
+	id, _ := strconv.Atoi(stream.LastEventID())
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stream.Done():
+			return
+		case t := <-ticker.C:
+			id++
+			err := stream.Send(response.Event{
+				ID:    strconv.Itoa(id),
+				Event: "tick",
+				Data:  t.Format(time.RFC3339),
+			})
+			if err != nil {
+				return
+			}
+		}
 	}
-	respHeaders.Set("Content-Length", fmt.Sprintf("%d", len(videoData)))
-	w.WriteStatusLine(response.StatusCodeSuccess)
-	w.WriteHeaders(respHeaders)
-	w.WriteBody(videoData)
 }
 
-func proxyHandler(w *response.Writer, req *request.Request) {
-	target := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
-	req_url := fmt.Sprintf("http://httpbin.org/%s", target)
-	respHeaders := response.GetDefaultHeaders(0)
-
-	resp, err := http.Get(req_url)
+func echoHandler(w *response.Writer, req *request.Request) {
+	conn, err := websocket.Upgrade(w, req)
 	if err != nil {
-		writeServerError(w, req)
-		log.Printf("Error making request to httpbin: %v\n", err)
+		log.Printf("Error upgrading to websocket: %v\n", err)
 		return
 	}
-	defer resp.Body.Close()
-
-	w.WriteStatusLine(response.StatusCode(resp.StatusCode))
-
-	// copy headers from resp to headers
-	for k, v := range resp.Header {
-		for _, vv := range v {
-			respHeaders.Add(k, vv)
-		}
-	}
-
-	respHeaders.Delete("Content-Length")
-	respHeaders.Set("Transfer-Encoding", "chunked")
-	respHeaders.Add("Trailer", "X-Content-SHA256, X-Content-Length")
-	w.WriteHeaders(respHeaders)
-
-	var fullBody []byte
-	// create new buffer size 1024
-	const maxChunkSize = 1024
-	buf := make([]byte, maxChunkSize)
-
+	defer conn.Close(websocket.CloseGoingAway, "")
 	for {
-		n, err := resp.Body.Read(buf)
-		fullBody = append(fullBody, buf[:n]...)
+		messageType, data, err := conn.ReadMessage()
 		if err != nil {
-			if err == io.EOF {
-				_, err = w.WriteChunkedBodyDone()
-				if err != nil {
-					fmt.Println("Error writing chunked body done:", err)
-				}
-				break
-			}
-			fmt.Printf("Error reading httpbin response: %v\n", err)
 			return
 		}
-		n, err = w.WriteChunkedBody(buf[:n])
-		if err != nil {
-			fmt.Printf("Error writing chunked body: %v\n", err)
+		if err := conn.WriteMessage(messageType, data); err != nil {
 			return
 		}
-		fmt.Printf("Wrote %d bytes\n", n)
 	}
-
-	sha256sum := fmt.Sprintf("%x", sha256.Sum256(fullBody))
-	trailers := headers.NewHeaders()
-	trailers.Add("X-Content-SHA256", sha256sum)
-	trailers.Add("X-Content-Length", fmt.Sprintf("%d", len(fullBody)))
-	w.WriteTrailers(trailers)
 }
 
-func writeServerError(w *response.Writer, _ *request.Request) {
-	headers := headers.NewHeaders()
-	w.WriteStatusLine(response.StatusCodeInternalServerError)
-	headers.Set("Content-Type", "text/html")
-	w.WriteHeaders(headers)
-	body := response.BuildResponseBody(response.StatusCodeInternalServerError, "Okay, you know what? This one is on me.")
-	w.WriteBody(body)
+func writeServerError(w *response.Writer, req *request.Request) {
+	response.WriteError(w, req, response.StatusCodeInternalServerError, "Okay, you know what? This one is on me.")
 }
 
-func writeBadRequest(w *response.Writer, _ *request.Request) {
-	headers := headers.NewHeaders()
-	w.WriteStatusLine(response.StatusCodeBadRequest)
-	headers.Set("Content-Type", "text/html")
-	w.WriteHeaders(headers)
-	body := response.BuildResponseBody(response.StatusCodeBadRequest, "Your request honestly kinda sucked.")
-	w.WriteBody(body)
+func writeBadRequest(w *response.Writer, req *request.Request) {
+	response.WriteError(w, req, response.StatusCodeBadRequest, "Your request honestly kinda sucked.")
 }
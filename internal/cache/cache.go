@@ -0,0 +1,507 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/server"
+)
+
+const (
+	DefaultName         = "httpfromtcp"
+	DefaultMaxEntrySize = 1 << 20
+)
+
+type Options struct {
+	// identifies the cache in Cache-Status, "" means DefaultName
+	Name string
+	// bodies larger than this aren't stored, 0 means DefaultMaxEntrySize
+	MaxEntrySize int
+}
+
+// Cache is a shared HTTP cache (RFC 9111) in front of a handler. GET and HEAD
+// responses pass through it, buffered only as far as MaxEntrySize; other
+// requests go straight through and invalidate what's stored for their
+// target.
+type Cache struct {
+	store Store
+	opts  Options
+
+	// keys with a stale-while-revalidate refresh in flight
+	revalidating sync.Map
+}
+
+// headers that describe the connection rather than the stored response
+var unstoredHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Connection", "TE", "Trailer",
+	"Transfer-Encoding", "Upgrade", "Content-Length", "Cache-Status",
+}
+
+// conditional headers removed from requests the cache forwards on a miss,
+// they're evaluated against the full response instead
+var conditionalHeaders = []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "If-Range"}
+
+// overridable in tests
+var now = time.Now
+
+func New(store Store, opts Options) *Cache {
+	if opts.Name == "" {
+		opts.Name = DefaultName
+	}
+	if opts.MaxEntrySize == 0 {
+		opts.MaxEntrySize = DefaultMaxEntrySize
+	}
+	return &Cache{store: store, opts: opts}
+}
+
+// Middleware puts the cache in front of next. It has the shape of a
+// server.Middleware.
+func (c *Cache) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.Method {
+		case "GET", "HEAD":
+		case "OPTIONS", "TRACE":
+			next(w, req)
+			return
+		default:
+			// RFC 9111 4.4, an unsafe request may change the resource
+			c.store.Delete(primaryKey(req))
+			next(w, req)
+			return
+		}
+		if _, ok := req.Headers.Get("Upgrade"); ok {
+			next(w, req)
+			return
+		}
+		if _, ok := req.Headers.Get("Range"); ok {
+			next(w, req)
+			return
+		}
+		c.serve(w, req, next)
+	}
+}
+
+func (c *Cache) serve(w *response.Writer, req *request.Request, next server.Handler) {
+	key := primaryKey(req)
+	reqCC := req.Headers.CacheControl()
+	entry, missReason := c.lookup(key, req)
+	if entry == nil {
+		if _, ok := reqCC["only-if-cached"]; ok {
+			c.writeError(w, req, response.StatusCodeGatewayTimeout, "The response isn't cached.", "fwd="+missReason)
+			return
+		}
+		if req.RequestLine.Method == "HEAD" {
+			// there's no body to store, so there's nothing to gain from buffering
+			next(w, req)
+			return
+		}
+		c.fetch(w, req, next, key, missReason)
+		return
+	}
+
+	age := entry.currentAge(now())
+	lifetime := entry.freshnessLifetime()
+	respCC := entry.Headers.CacheControl()
+	_, reqNoCache := reqCC["no-cache"]
+	if pragma, ok := req.Headers.Get("Pragma"); ok && len(reqCC) == 0 && strings.Contains(strings.ToLower(pragma), "no-cache") {
+		reqNoCache = true
+	}
+	_, respNoCache := respCC["no-cache"]
+	_, mustRevalidate := respCC["must-revalidate"]
+	if _, ok := respCC["proxy-revalidate"]; ok {
+		mustRevalidate = true
+	}
+	if _, ok := respCC["s-maxage"]; ok {
+		// s-maxage implies proxy-revalidate for shared caches
+		mustRevalidate = true
+	}
+
+	switch {
+	case reqNoCache:
+		c.revalidate(w, req, next, key, entry, "fwd=request")
+	case respNoCache:
+		c.revalidate(w, req, next, key, entry, "fwd=stale")
+	case acceptable(reqCC, age, lifetime, mustRevalidate):
+		c.serveEntry(w, req, entry, nil, fmt.Sprintf("hit; ttl=%d", ttl(lifetime-age)))
+	case !mustRevalidate && withinWindow(respCC, "stale-while-revalidate", age-lifetime):
+		c.serveEntry(w, req, entry, nil, fmt.Sprintf("hit; ttl=%d; detail=stale-while-revalidate", ttl(lifetime-age)))
+		c.revalidateInBackground(req, next, key, entry)
+	default:
+		c.revalidate(w, req, next, key, entry, "fwd=stale")
+	}
+}
+
+// acceptable reports whether a stored response can be used as is, given
+// the request's max-age, min-fresh and max-stale (RFC 9111 5.2.1).
+func acceptable(reqCC map[string]string, age, lifetime time.Duration, mustRevalidate bool) bool {
+	if maxAge, ok := seconds(reqCC, "max-age"); ok && age > maxAge {
+		return false
+	}
+	if minFresh, ok := seconds(reqCC, "min-fresh"); ok {
+		lifetime -= minFresh
+	}
+	if age < lifetime {
+		return true
+	}
+	if mustRevalidate {
+		return false
+	}
+	maxStaleArg, ok := reqCC["max-stale"]
+	if !ok {
+		return false
+	}
+	if maxStaleArg == "" {
+		// any staleness is fine
+		return true
+	}
+	maxStale, _ := seconds(reqCC, "max-stale")
+	return age-lifetime <= maxStale
+}
+
+// withinWindow reports whether staleness falls within a directive's window,
+// like stale-while-revalidate=60.
+func withinWindow(respCC map[string]string, directive string, staleness time.Duration) bool {
+	window, ok := seconds(respCC, directive)
+	return ok && staleness <= window
+}
+
+func (c *Cache) lookup(key string, req *request.Request) (*Entry, string) {
+	entry, ok := c.store.Get(key)
+	if !ok {
+		return nil, "uri-miss"
+	}
+	if len(entry.VaryFields) > 0 {
+		entry, ok = c.store.Get(variantKey(key, entry.VaryFields, req))
+		if !ok {
+			return nil, "vary-miss"
+		}
+	}
+	return entry, ""
+}
+
+// fetch forwards a request the cache can't answer and stores the response
+// if allowed.
+func (c *Cache) fetch(w *response.Writer, req *request.Request, next server.Handler, key, missReason string) {
+	fwdReq := copyRequest(req)
+	for _, name := range conditionalHeaders {
+		fwdReq.Headers.Delete(name)
+	}
+	u, err := call(next, fwdReq, w)
+	if err != nil {
+		if !errors.Is(err, errHijacked) {
+			log.Printf("cache: unreadable response for %s: %v", key, err)
+			c.writeError(w, req, response.StatusCodeBadGateway, "The response couldn't be read.", "fwd="+missReason)
+		}
+		return
+	}
+	defer u.finish()
+	c.respond(w, req, key, u, fmt.Sprintf("fwd=%s; fwd-status=%d", missReason, u.resp.StatusCode))
+}
+
+// revalidate asks next whether entry is still good with a conditional
+// request, and serves either the refreshed entry or the new response.
+func (c *Cache) revalidate(w *response.Writer, req *request.Request, next server.Handler, key string, entry *Entry, fwd string) {
+	u, err := c.conditionalFetch(req, next, entry, w)
+	if errors.Is(err, errHijacked) {
+		return
+	}
+	if err == nil {
+		defer u.finish()
+	}
+	if err != nil || u.resp.StatusCode >= 500 {
+		// RFC 5861 stale-if-error
+		staleness := entry.currentAge(now()) - entry.freshnessLifetime()
+		if withinWindow(entry.Headers.CacheControl(), "stale-if-error", staleness) {
+			c.serveEntry(w, req, entry, nil, fwd+"; detail=stale-if-error")
+			return
+		}
+		if err != nil {
+			log.Printf("cache: unreadable response for %s: %v", key, err)
+			c.writeError(w, req, response.StatusCodeBadGateway, "The response couldn't be read.", fwd)
+			return
+		}
+	}
+	status := fmt.Sprintf("%s; fwd-status=%d", fwd, u.resp.StatusCode)
+	if u.resp.StatusCode == 304 {
+		entry = refreshed(entry, u.entry(nil))
+		if c.put(key, req, entry) {
+			status += "; stored"
+		}
+		c.serveEntry(w, req, entry, nil, status)
+		return
+	}
+	c.respond(w, req, key, u, status)
+}
+
+func (c *Cache) revalidateInBackground(req *request.Request, next server.Handler, key string, entry *Entry) {
+	if _, running := c.revalidating.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+	req = copyRequest(req)
+	go func() {
+		defer c.revalidating.Delete(key)
+		u, err := c.conditionalFetch(req, next, entry, nil)
+		if err != nil {
+			log.Printf("cache: background revalidation of %s failed: %v", key, err)
+			return
+		}
+		defer u.finish()
+		if u.resp.StatusCode == 304 {
+			c.put(key, req, refreshed(entry, u.entry(nil)))
+			return
+		}
+		if len(u.resp.SetCookies) > 0 {
+			return
+		}
+		kept := &cappedBuffer{max: c.opts.MaxEntrySize}
+		ok, err := u.keep(kept, u.resp.Body)
+		if err != nil {
+			log.Printf("cache: background revalidation of %s failed: %v", key, err)
+			return
+		}
+		if ok {
+			c.put(key, req, u.entry(kept.Bytes()))
+		}
+	}()
+}
+
+// conditionalFetch revalidates entry. It always sends a GET, so a changed
+// response can be stored even when a HEAD request triggered the check.
+func (c *Cache) conditionalFetch(req *request.Request, next server.Handler, entry *Entry, w *response.Writer) (*upstream, error) {
+	condReq := copyRequest(req)
+	condReq.RequestLine.Method = "GET"
+	for _, name := range conditionalHeaders {
+		condReq.Headers.Delete(name)
+	}
+	if etag, ok := entry.Headers.Get("ETag"); ok {
+		condReq.Headers.Set("If-None-Match", etag)
+	}
+	if lastModified, ok := entry.Headers.Get("Last-Modified"); ok {
+		condReq.Headers.Set("If-Modified-Since", lastModified)
+	}
+	return call(next, condReq, w)
+}
+
+// respond answers with a response next is writing. One whose length is
+// known to fit is read whole and served like a stored one. Anything else is
+// streamed to the client, and stored afterwards only if it turned out to
+// fit and next didn't flush it as it went.
+func (c *Cache) respond(w *response.Writer, req *request.Request, key string, u *upstream, status string) {
+	cookies := u.resp.SetCookies
+	if n := u.resp.ContentLength; n >= 0 && n <= int64(c.opts.MaxEntrySize) {
+		body, err := io.ReadAll(u.resp.Body)
+		if err != nil {
+			log.Printf("cache: unreadable response for %s: %v", key, err)
+			c.writeError(w, req, response.StatusCodeBadGateway, "The response couldn't be read.", status)
+			return
+		}
+		entry := u.entry(body)
+		if len(cookies) == 0 && !u.streamed() && c.put(key, req, entry) {
+			status += "; stored"
+		}
+		c.serveEntry(w, req, entry, cookies, status)
+		return
+	}
+
+	entry := u.entry(nil)
+	h := c.responseHeaders(entry, status)
+	kept := &cappedBuffer{max: c.opts.MaxEntrySize}
+	body := io.TeeReader(u.resp.Body, kept)
+	if entry.StatusCode == 200 && response.CheckPreconditions(w, req, h) {
+		// the client has it already, but it can still be stored
+	} else if err := relay(w, req, entry.StatusCode, h, cookies, u.resp.ContentLength, body); err != nil {
+		return
+	}
+	if len(cookies) > 0 {
+		return
+	}
+	if ok, err := u.keep(kept, u.resp.Body); err == nil && ok {
+		entry.Body = kept.Bytes()
+		c.put(key, req, entry)
+	}
+}
+
+// put stores entry under key if the response may be stored, returning
+// whether it was.
+func (c *Cache) put(key string, req *request.Request, entry *Entry) bool {
+	if len(entry.Body) > c.opts.MaxEntrySize || !storable(req, entry.StatusCode, entry.Headers) {
+		return false
+	}
+	varyFields := entry.Headers.Values("Vary")
+	if len(varyFields) == 0 {
+		c.store.Set(key, entry)
+		return true
+	}
+	c.store.Set(key, &Entry{VaryFields: varyFields})
+	c.store.Set(variantKey(key, varyFields, req), entry)
+	return true
+}
+
+// serveEntry answers with a stored or just fetched response, honoring the
+// request's own conditional headers. cookies only come with fresh responses,
+// stored ones never have any.
+func (c *Cache) serveEntry(w *response.Writer, req *request.Request, entry *Entry, cookies []string, status string) {
+	h := c.responseHeaders(entry, status)
+	if entry.StatusCode == 200 && response.CheckPreconditions(w, req, h) {
+		return
+	}
+	c.write(w, req, entry, h, cookies)
+}
+
+func (c *Cache) responseHeaders(entry *Entry, status string) headers.Headers {
+	h := headers.NewHeaders()
+	for k, v := range entry.Headers {
+		h[k] = v
+	}
+	h.Set("Connection", "close")
+	h.Set("Age", strconv.FormatInt(int64(entry.currentAge(now())/time.Second), 10))
+	h.Set("Cache-Status", c.opts.Name+"; "+status)
+	return h
+}
+
+func (c *Cache) write(w *response.Writer, req *request.Request, entry *Entry, h headers.Headers, cookies []string) {
+	for _, cookie := range cookies {
+		w.AddSetCookie(cookie)
+	}
+	if err := w.WriteStatusLine(response.StatusCode(entry.StatusCode)); err != nil {
+		return
+	}
+	if entry.StatusCode != 204 && entry.StatusCode != 304 {
+		// stored bodies come from GETs, so this is right for HEAD too
+		h.Set("Content-Length", strconv.Itoa(len(entry.Body)))
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return
+	}
+	if req.RequestLine.Method != "HEAD" && entry.StatusCode != 204 && entry.StatusCode != 304 {
+		w.WriteBody(entry.Body)
+	}
+}
+
+// relay streams a response of length n, -1 if unknown, to the client.
+// Bodies of unknown length are flushed as they come, so streams keep
+// flowing.
+func relay(w *response.Writer, req *request.Request, code int, h headers.Headers, cookies []string, n int64, body io.Reader) error {
+	for _, cookie := range cookies {
+		w.AddSetCookie(cookie)
+	}
+	if err := w.WriteStatusLine(response.StatusCode(code)); err != nil {
+		return err
+	}
+	if code == 204 || code == 304 {
+		return w.WriteHeaders(h)
+	}
+	if n >= 0 {
+		h.Set("Content-Length", strconv.FormatInt(n, 10))
+	} else {
+		h.Set("Transfer-Encoding", "chunked")
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	if req.RequestLine.Method == "HEAD" {
+		return nil
+	}
+	if n >= 0 {
+		_, err := w.WriteBodyFrom(body)
+		return err
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := w.WriteChunkedBody(buf[:n]); werr != nil {
+				return werr
+			}
+			if ferr := w.Flush(); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := w.WriteChunkedBodyDone()
+	return err
+}
+
+func (c *Cache) writeError(w *response.Writer, req *request.Request, code response.StatusCode, message, status string) {
+	h := headers.NewHeaders()
+	h.Set("Cache-Status", c.opts.Name+"; "+status)
+	response.WriteErrorWithHeaders(w, req, code, message, h)
+}
+
+// refreshed applies the headers of a 304 to a stored entry (RFC 9111 4.3.4).
+func refreshed(entry *Entry, notModified *Entry) *Entry {
+	h := headers.NewHeaders()
+	for k, v := range entry.Headers {
+		h[k] = v
+	}
+	// the old Age and Date describe the old response, and would make the
+	// refreshed one look older than it is
+	h.Delete("Age")
+	h.SetTime("Date", notModified.ResponseTime)
+	for k, v := range notModified.Headers {
+		if k == "content-length" {
+			continue
+		}
+		h[k] = v
+	}
+	return &Entry{
+		StatusCode:   entry.StatusCode,
+		Headers:      h,
+		Body:         entry.Body,
+		RequestTime:  notModified.RequestTime,
+		ResponseTime: notModified.ResponseTime,
+	}
+}
+
+func primaryKey(req *request.Request) string {
+	host, _ := req.Headers.Get("Host")
+	return strings.ToLower(host) + req.RequestLine.RequestTarget
+}
+
+// variantKey extends the primary key with the request's values of the
+// headers the response varies on.
+func variantKey(key string, fields []string, req *request.Request) string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = strings.ToLower(f)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString(key)
+	for _, name := range names {
+		v, _ := req.Headers.Get(name)
+		b.WriteString("\x00" + name + "=" + strings.Join(headers.ParseList(v), ","))
+	}
+	return b.String()
+}
+
+func copyRequest(req *request.Request) *request.Request {
+	r := *req
+	r.Headers = headers.NewHeaders()
+	for k, v := range req.Headers {
+		r.Headers[k] = v
+	}
+	return &r
+}
+
+// ttl renders a remaining freshness lifetime in whole seconds for Cache-Status.
+func ttl(d time.Duration) int64 {
+	return int64(math.Floor(d.Seconds()))
+}
@@ -0,0 +1,391 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/client"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// origin is a handler whose responses the tests control.
+type origin struct {
+	mu      sync.Mutex
+	calls   atomic.Int32
+	headers map[string]string
+	body    string
+	lastReq *request.Request
+}
+
+func (o *origin) set(body string, h map[string]string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.body, o.headers = body, h
+}
+
+func (o *origin) handle(w *response.Writer, req *request.Request) {
+	o.calls.Add(1)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.lastReq = req
+	h := headers.NewHeaders()
+	for k, v := range o.headers {
+		h.Set(k, v)
+	}
+	if etag, ok := h.Get("ETag"); ok {
+		if inm, ok := req.Headers.Get("If-None-Match"); ok && inm == etag {
+			w.WriteStatusLine(response.StatusCodeNotModified)
+			w.WriteHeaders(h)
+			return
+		}
+	}
+	if lang, ok := req.Headers.Get("Accept-Language"); ok {
+		h.Set("Content-Language", lang)
+	}
+	h.Set("Content-Length", strconv.Itoa(len(o.body)))
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	w.WriteHeaders(h)
+	w.WriteBody([]byte(o.body))
+}
+
+func (o *origin) lastRequest() *request.Request {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.lastReq
+}
+
+// fakeClock replaces now for the duration of a test.
+func fakeClock(t *testing.T) *time.Time {
+	t.Helper()
+	clock := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return clock
+	}
+	t.Cleanup(func() { now = time.Now })
+	return &clock
+}
+
+func do(t *testing.T, handler func(*response.Writer, *request.Request), raw string) (*client.Response, string) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	handler(response.NewWriter(&buf), req)
+	resp, err := client.ReadResponse(bufio.NewReader(&buf), req.RequestLine.Method)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func get(target string, extra ...string) string {
+	return "GET " + target + " HTTP/1.1\r\nHost: example.com\r\n" + strings.Join(extra, "") + "\r\n"
+}
+
+func header(resp *client.Response, name string) string {
+	v, _ := resp.Headers.Get(name)
+	return v
+}
+
+func TestHitAndMiss(t *testing.T) {
+	clock := fakeClock(t)
+	o := &origin{}
+	o.set("hello", map[string]string{"Cache-Control": "max-age=60"})
+	handler := New(NewMemoryStore(1<<20), Options{}).Middleware(o.handle)
+
+	// Test: the first request goes to the origin and is stored
+	resp, body := do(t, handler, get("/a"))
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, "httpfromtcp; fwd=uri-miss; fwd-status=200; stored", header(resp, "Cache-Status"))
+	assert.Equal(t, "0", header(resp, "Age"))
+
+	// Test: the second is answered from the cache with its age
+	*clock = clock.Add(10 * time.Second)
+	resp, body = do(t, handler, get("/a"))
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, "httpfromtcp; hit; ttl=50", header(resp, "Cache-Status"))
+	assert.Equal(t, "10", header(resp, "Age"))
+	assert.Equal(t, int32(1), o.calls.Load())
+
+	// Test: HEAD is answered from the stored GET
+	resp, body = do(t, handler, "HEAD /a HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Empty(t, body)
+	assert.Equal(t, "5", header(resp, "Content-Length"))
+	assert.Equal(t, int32(1), o.calls.Load())
+
+	// Test: a different host is a different resource
+	do(t, handler, "GET /a HTTP/1.1\r\nHost: other.com\r\n\r\n")
+	assert.Equal(t, int32(2), o.calls.Load())
+
+	// Test: request max-age can demand a younger response
+	resp, _ = do(t, handler, get("/a", "Cache-Control: max-age=5\r\n"))
+	assert.True(t, strings.HasPrefix(header(resp, "Cache-Status"), "httpfromtcp; fwd=stale"))
+	assert.Equal(t, int32(3), o.calls.Load())
+
+	// Test: the client's own conditional request is answered by the cache
+	o.set("hello", map[string]string{"Cache-Control": "max-age=60", "ETag": `"v1"`})
+	do(t, handler, get("/etag"))
+	calls := o.calls.Load()
+	resp, body = do(t, handler, get("/etag", "If-None-Match: \"v1\"\r\n"))
+	assert.Equal(t, 304, resp.StatusCode)
+	assert.Empty(t, body)
+	assert.Equal(t, calls, o.calls.Load())
+}
+
+func TestRevalidation(t *testing.T) {
+	clock := fakeClock(t)
+	o := &origin{}
+	o.set("v1 body", map[string]string{"Cache-Control": "max-age=10", "ETag": `"v1"`})
+	handler := New(NewMemoryStore(1<<20), Options{}).Middleware(o.handle)
+	do(t, handler, get("/r"))
+
+	// Test: a stale entry is revalidated with the stored validator
+	*clock = clock.Add(20 * time.Second)
+	resp, body := do(t, handler, get("/r"))
+	assert.Equal(t, "v1 body", body)
+	assert.Equal(t, "httpfromtcp; fwd=stale; fwd-status=304; stored", header(resp, "Cache-Status"))
+	inm, _ := o.lastRequest().Headers.Get("If-None-Match")
+	assert.Equal(t, `"v1"`, inm)
+
+	// Test: the 304 made it fresh again
+	*clock = clock.Add(5 * time.Second)
+	resp, _ = do(t, handler, get("/r"))
+	assert.Equal(t, "httpfromtcp; hit; ttl=5", header(resp, "Cache-Status"))
+	assert.Equal(t, int32(2), o.calls.Load())
+
+	// Test: a changed resource replaces the entry
+	o.set("v2 body", map[string]string{"Cache-Control": "max-age=10", "ETag": `"v2"`})
+	*clock = clock.Add(20 * time.Second)
+	_, body = do(t, handler, get("/r"))
+	assert.Equal(t, "v2 body", body)
+	_, body = do(t, handler, get("/r"))
+	assert.Equal(t, "v2 body", body)
+	assert.Equal(t, int32(3), o.calls.Load())
+
+	// Test: request no-cache forces a trip to the origin
+	resp, _ = do(t, handler, get("/r", "Cache-Control: no-cache\r\n"))
+	assert.True(t, strings.HasPrefix(header(resp, "Cache-Status"), "httpfromtcp; fwd=request; fwd-status=304"))
+	assert.Equal(t, int32(4), o.calls.Load())
+
+	// Test: response no-cache always revalidates
+	o.set("nc", map[string]string{"Cache-Control": "no-cache", "ETag": `"nc"`})
+	do(t, handler, get("/nc"))
+	resp, _ = do(t, handler, get("/nc"))
+	assert.Equal(t, "httpfromtcp; fwd=stale; fwd-status=304; stored", header(resp, "Cache-Status"))
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	clock := fakeClock(t)
+	o := &origin{}
+	o.set("old", map[string]string{"Cache-Control": "max-age=10, stale-while-revalidate=30"})
+	c := New(NewMemoryStore(1<<20), Options{})
+	handler := c.Middleware(o.handle)
+	do(t, handler, get("/swr"))
+
+	// Test: within the window the stale response is served right away
+	o.set("new", map[string]string{"Cache-Control": "max-age=10, stale-while-revalidate=30"})
+	*clock = clock.Add(15 * time.Second)
+	resp, body := do(t, handler, get("/swr"))
+	assert.Equal(t, "old", body)
+	assert.Equal(t, "httpfromtcp; hit; ttl=-5; detail=stale-while-revalidate", header(resp, "Cache-Status"))
+
+	// Test: and refreshed in the background
+	require.Eventually(t, func() bool {
+		_, running := c.revalidating.Load("example.com/swr")
+		return o.calls.Load() == 2 && !running
+	}, time.Second, time.Millisecond)
+	_, body = do(t, handler, get("/swr"))
+	assert.Equal(t, "new", body)
+
+	// Test: past the window it's revalidated synchronously
+	*clock = clock.Add(time.Minute)
+	o.set("newest", map[string]string{"Cache-Control": "max-age=10, stale-while-revalidate=30"})
+	_, body = do(t, handler, get("/swr"))
+	assert.Equal(t, "newest", body)
+}
+
+func TestNotStored(t *testing.T) {
+	fakeClock(t)
+	o := &origin{}
+	handler := New(NewMemoryStore(1<<20), Options{}).Middleware(o.handle)
+
+	cases := []map[string]string{
+		{"Cache-Control": "no-store"},
+		{"Cache-Control": "private, max-age=60"},
+		{"Cache-Control": "max-age=60", "Vary": "*"},
+		{},
+	}
+	for _, h := range cases {
+		o.set("x", h)
+		before := o.calls.Load()
+		do(t, handler, get("/ns"))
+		resp, _ := do(t, handler, get("/ns"))
+		assert.Equal(t, before+2, o.calls.Load(), h)
+		assert.NotContains(t, header(resp, "Cache-Status"), "stored")
+	}
+
+	// Test: requests with Authorization aren't shared unless the response says so
+	o.set("secret", map[string]string{"Cache-Control": "max-age=60"})
+	before := o.calls.Load()
+	do(t, handler, get("/auth", "Authorization: Bearer x\r\n"))
+	do(t, handler, get("/auth", "Authorization: Bearer x\r\n"))
+	assert.Equal(t, before+2, o.calls.Load())
+
+	// Test: only-if-cached answers 504 on a miss
+	resp, _ := do(t, handler, get("/never", "Cache-Control: only-if-cached\r\n"))
+	assert.Equal(t, 504, resp.StatusCode)
+}
+
+func TestVary(t *testing.T) {
+	fakeClock(t)
+	o := &origin{}
+	o.set("hi", map[string]string{"Cache-Control": "max-age=60", "Vary": "Accept-Language"})
+	handler := New(NewMemoryStore(1<<20), Options{}).Middleware(o.handle)
+
+	// Test: each variant is stored separately
+	resp, _ := do(t, handler, get("/v", "Accept-Language: en\r\n"))
+	assert.Equal(t, "en", header(resp, "Content-Language"))
+	resp, _ = do(t, handler, get("/v", "Accept-Language: fr\r\n"))
+	assert.Equal(t, "fr", header(resp, "Content-Language"))
+	assert.Equal(t, "httpfromtcp; fwd=vary-miss; fwd-status=200; stored", header(resp, "Cache-Status"))
+
+	resp, _ = do(t, handler, get("/v", "Accept-Language: en\r\n"))
+	assert.Equal(t, "en", header(resp, "Content-Language"))
+	assert.True(t, strings.HasPrefix(header(resp, "Cache-Status"), "httpfromtcp; hit"))
+	resp, _ = do(t, handler, get("/v", "Accept-Language: fr\r\n"))
+	assert.Equal(t, "fr", header(resp, "Content-Language"))
+	assert.Equal(t, int32(2), o.calls.Load())
+}
+
+func TestInvalidation(t *testing.T) {
+	fakeClock(t)
+	o := &origin{}
+	o.set("x", map[string]string{"Cache-Control": "max-age=60"})
+	handler := New(NewMemoryStore(1<<20), Options{}).Middleware(o.handle)
+	do(t, handler, get("/item"))
+	do(t, handler, get("/item"))
+	assert.Equal(t, int32(1), o.calls.Load())
+
+	// Test: an unsafe request evicts the stored response
+	do(t, handler, "DELETE /item HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	resp, _ := do(t, handler, get("/item"))
+	assert.Equal(t, "httpfromtcp; fwd=uri-miss; fwd-status=200; stored", header(resp, "Cache-Status"))
+	assert.Equal(t, int32(3), o.calls.Load())
+}
+
+func TestFreshnessLifetime(t *testing.T) {
+	date := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	entry := func(h map[string]string) *Entry {
+		e := &Entry{StatusCode: 200, Headers: headers.NewHeaders(), ResponseTime: date, RequestTime: date}
+		for k, v := range h {
+			e.Headers.Set(k, v)
+		}
+		return e
+	}
+	httpDate := headers.FormatHTTPDate
+
+	// Test: s-maxage wins over max-age, which wins over Expires
+	assert.Equal(t, 30*time.Second, entry(map[string]string{"Cache-Control": "max-age=10, s-maxage=30"}).freshnessLifetime())
+	assert.Equal(t, 10*time.Second, entry(map[string]string{"Cache-Control": "max-age=10", "Expires": httpDate(date.Add(time.Hour))}).freshnessLifetime())
+	assert.Equal(t, time.Hour, entry(map[string]string{"Date": httpDate(date), "Expires": httpDate(date.Add(time.Hour))}).freshnessLifetime())
+
+	// Test: an invalid Expires means already expired
+	assert.Equal(t, time.Duration(0), entry(map[string]string{"Expires": "0"}).freshnessLifetime())
+
+	// Test: heuristic freshness from Last-Modified
+	assert.Equal(t, time.Hour, entry(map[string]string{"Last-Modified": httpDate(date.Add(-10 * time.Hour))}).freshnessLifetime())
+	assert.Equal(t, 24*time.Hour, entry(map[string]string{"Last-Modified": httpDate(date.Add(-1000 * time.Hour))}).freshnessLifetime())
+
+	// Test: age accounts for the upstream's Age and the time since storing
+	e := entry(map[string]string{"Age": "100", "Date": httpDate(date)})
+	assert.Equal(t, 160*time.Second, e.currentAge(date.Add(time.Minute)))
+}
+
+func TestStreaming(t *testing.T) {
+	const chunk = 32 << 10
+	const chunks = 1024
+	c := New(NewMemoryStore(1<<20), Options{MaxEntrySize: 64 << 10})
+	resume := make(chan struct{})
+	var handlerDone atomic.Bool
+	handler := c.Middleware(func(w *response.Writer, req *request.Request) {
+		defer handlerDone.Store(true)
+		h := headers.NewHeaders()
+		h.Set("Cache-Control", "max-age=60")
+		h.Set("Transfer-Encoding", "chunked")
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(h)
+		data := bytes.Repeat([]byte("x"), chunk)
+		w.WriteChunkedBody(data)
+		<-resume
+		for i := 1; i < chunks; i++ {
+			if _, err := w.WriteChunkedBody(data); err != nil {
+				return
+			}
+		}
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(nil)
+	})
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	req, err := request.RequestFromReader(strings.NewReader(get("/big")))
+	require.NoError(t, err)
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	go func() {
+		defer serverConn.Close()
+		handler(response.NewWriter(serverConn), req)
+	}()
+
+	// Test: a body larger than MaxEntrySize reaches the client while the handler is still writing it
+	resp, err := client.ReadResponse(bufio.NewReader(clientConn), "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "httpfromtcp; fwd=uri-miss; fwd-status=200", header(resp, "Cache-Status"))
+	_, err = io.ReadFull(resp.Body, make([]byte, chunk))
+	require.NoError(t, err)
+	assert.False(t, handlerDone.Load())
+	close(resume)
+
+	// Test: it isn't buffered whole on the way, nor stored
+	n, err := io.Copy(io.Discard, resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, int64((chunks-1)*chunk), n)
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(chunks*chunk/4))
+	_, ok := c.store.Get(primaryKey(req))
+	assert.False(t, ok)
+
+	// Test: a response the handler flushes isn't stored either, however small
+	handler = c.Middleware(func(w *response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Set("Cache-Control", "max-age=60")
+		h.Set("Transfer-Encoding", "chunked")
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("data: hello\n\n"))
+		w.Flush()
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(nil)
+	})
+	_, body := do(t, handler, get("/events"))
+	assert.Equal(t, "data: hello\n\n", body)
+	_, ok = c.store.Get("example.com/events")
+	assert.False(t, ok)
+}
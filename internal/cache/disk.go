@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiskStore keeps entries as files in a directory, so they survive restarts.
+// Like MemoryStore it evicts the least recently used entries once their
+// files take up more than the cap. Recency is kept in the files' modification
+// times, so it survives restarts too.
+type DiskStore struct {
+	dir      string
+	maxBytes int64
+
+	mu    sync.Mutex
+	size  int64
+	order *list.List // front is the most recently used
+	files map[string]*list.Element
+}
+
+type diskItem struct {
+	name string
+	size int64
+}
+
+// NewDiskStore stores entries in dir, picking up the ones a previous store
+// left there.
+func NewDiskStore(dir string, maxBytes int64) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+	type file struct {
+		diskItem
+		modTime time.Time
+	}
+	var found []file
+	for _, de := range dirEntries {
+		if !de.Type().IsRegular() {
+			continue
+		}
+		if strings.HasPrefix(de.Name(), "tmp-") {
+			// left behind by a store that stopped while writing
+			os.Remove(filepath.Join(dir, de.Name()))
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		found = append(found, file{diskItem{de.Name(), info.Size()}, info.ModTime()})
+	}
+	slices.SortFunc(found, func(a, b file) int { return a.modTime.Compare(b.modTime) })
+
+	s := &DiskStore{dir: dir, maxBytes: maxBytes, order: list.New(), files: map[string]*list.Element{}}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range found {
+		s.files[f.name] = s.order.PushFront(&diskItem{f.name, f.size})
+		s.size += f.size
+	}
+	s.evict()
+	return s, nil
+}
+
+func (s *DiskStore) Get(key string) (*Entry, bool) {
+	name := fileName(key)
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	var stored struct {
+		Key   string
+		Entry Entry
+	}
+	if err := gob.NewDecoder(f).Decode(&stored); err != nil || stored.Key != key {
+		// unreadable or a hash collision, either way it isn't ours
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.files[name]; ok {
+		s.order.MoveToFront(elem)
+		t := now()
+		os.Chtimes(f.Name(), t, t)
+	}
+	return &stored.Entry, true
+}
+
+func (s *DiskStore) Set(key string, e *Entry) {
+	// write to a temporary file first so readers never see a partial entry
+	f, err := os.CreateTemp(s.dir, "tmp-*")
+	if err != nil {
+		log.Printf("cache: failed to store %s: %v", key, err)
+		return
+	}
+	stored := struct {
+		Key   string
+		Entry *Entry
+	}{key, e}
+	err = gob.NewEncoder(f).Encode(stored)
+	var size int64
+	if err == nil {
+		size, err = f.Seek(0, io.SeekCurrent)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		log.Printf("cache: failed to store %s: %v", key, err)
+		return
+	}
+	if size > s.maxBytes {
+		os.Remove(f.Name())
+		return
+	}
+
+	name := fileName(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Rename(f.Name(), filepath.Join(s.dir, name)); err != nil {
+		os.Remove(f.Name())
+		log.Printf("cache: failed to store %s: %v", key, err)
+		return
+	}
+	s.forget(name)
+	s.files[name] = s.order.PushFront(&diskItem{name, size})
+	s.size += size
+	s.evict()
+}
+
+func (s *DiskStore) Delete(key string) {
+	name := fileName(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	os.Remove(filepath.Join(s.dir, name))
+	s.forget(name)
+}
+
+// Size returns the total size of the stored entries' files in bytes.
+func (s *DiskStore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// evict removes the least recently used files until the rest fit the cap.
+func (s *DiskStore) evict() {
+	for s.size > s.maxBytes {
+		name := s.order.Back().Value.(*diskItem).name
+		os.Remove(filepath.Join(s.dir, name))
+		s.forget(name)
+	}
+}
+
+func (s *DiskStore) forget(name string) {
+	elem, ok := s.files[name]
+	if !ok {
+		return
+	}
+	s.order.Remove(elem)
+	delete(s.files, name)
+	s.size -= elem.Value.(*diskItem).size
+}
+
+func fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+)
+
+// status codes that can be cached without explicit freshness (RFC 9110 15.1)
+var heuristicallyCacheable = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// heuristic freshness is a tenth of the time since Last-Modified, capped
+const (
+	heuristicFraction = 10
+	maxHeuristicAge   = 24 * time.Hour
+)
+
+// storable reports whether a shared cache may store the response to a GET
+// for req (RFC 9111 3 and 3.5).
+func storable(req *request.Request, statusCode int, h headers.Headers) bool {
+	if statusCode < 200 || statusCode == 206 || statusCode == 304 {
+		return false
+	}
+	reqCC := req.Headers.CacheControl()
+	respCC := h.CacheControl()
+	if _, ok := reqCC["no-store"]; ok {
+		return false
+	}
+	for _, d := range []string{"no-store", "private"} {
+		if _, ok := respCC[d]; ok {
+			return false
+		}
+	}
+	for _, v := range h.Values("Vary") {
+		if v == "*" {
+			return false
+		}
+	}
+	_, public := respCC["public"]
+	_, sMaxAge := respCC["s-maxage"]
+	_, mustRevalidate := respCC["must-revalidate"]
+	if _, ok := req.Headers.Get("Authorization"); ok && !public && !sMaxAge && !mustRevalidate {
+		return false
+	}
+	if _, ok := respCC["max-age"]; ok || sMaxAge || public {
+		return true
+	}
+	if _, ok := h.Get("Expires"); ok {
+		return true
+	}
+	if _, ok := respCC["no-cache"]; ok {
+		// stored, but used only after revalidating
+		return true
+	}
+	_, hasLastModified := h.Get("Last-Modified")
+	return heuristicallyCacheable[statusCode] && hasLastModified
+}
+
+// freshnessLifetime follows RFC 9111 4.2.1 for a shared cache.
+func (e *Entry) freshnessLifetime() time.Duration {
+	cc := e.Headers.CacheControl()
+	if d, ok := seconds(cc, "s-maxage"); ok {
+		return d
+	}
+	if d, ok := seconds(cc, "max-age"); ok {
+		return d
+	}
+	if expires, ok, err := e.Headers.GetTime("Expires"); ok {
+		if err != nil {
+			// an invalid Expires means already expired
+			return 0
+		}
+		return expires.Sub(e.date())
+	}
+	if lastModified, ok, err := e.Headers.GetTime("Last-Modified"); ok && err == nil && heuristicallyCacheable[e.StatusCode] {
+		lifetime := e.date().Sub(lastModified) / heuristicFraction
+		return min(max(lifetime, 0), maxHeuristicAge)
+	}
+	return 0
+}
+
+// currentAge follows RFC 9111 4.2.3.
+func (e *Entry) currentAge(now time.Time) time.Duration {
+	apparentAge := max(e.ResponseTime.Sub(e.date()), 0)
+	ageValue := time.Duration(0)
+	if v, ok := e.Headers.Get("Age"); ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			ageValue = time.Duration(n) * time.Second
+		}
+	}
+	responseDelay := e.ResponseTime.Sub(e.RequestTime)
+	correctedInitialAge := max(apparentAge, ageValue+responseDelay)
+	return correctedInitialAge + now.Sub(e.ResponseTime)
+}
+
+func (e *Entry) date() time.Time {
+	if date, ok, err := e.Headers.GetTime("Date"); ok && err == nil {
+		return date
+	}
+	return e.ResponseTime
+}
+
+// seconds reads a delta-seconds directive argument.
+func seconds(directives map[string]string, name string) (time.Duration, bool) {
+	v, ok := directives[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		// RFC 9111 1.2.2: treat an invalid value as stale
+		return 0, true
+	}
+	return time.Duration(n) * time.Second, true
+}
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+)
+
+// Entry is a stored response. An entry with VaryFields set is a marker
+// saying the response varies on those request headers, and the actual
+// responses are stored under their variant keys.
+type Entry struct {
+	StatusCode int
+	Headers    headers.Headers
+	Body       []byte
+	// when the request that produced the response was sent, and when the
+	// response arrived
+	RequestTime  time.Time
+	ResponseTime time.Time
+
+	VaryFields []string
+}
+
+func (e *Entry) size() int64 {
+	n := int64(len(e.Body)) + 64
+	for k, v := range e.Headers {
+		n += int64(len(k) + len(v))
+	}
+	for _, f := range e.VaryFields {
+		n += int64(len(f))
+	}
+	return n
+}
+
+// Store keeps entries by key. Implementations must be safe for concurrent
+// use, and entries they return are treated as read-only.
+type Store interface {
+	Get(key string) (*Entry, bool)
+	Set(key string, e *Entry)
+	Delete(key string)
+}
+
+// MemoryStore keeps entries in memory and evicts the least recently used
+// ones once their total size goes over the cap.
+type MemoryStore struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List // front is the most recently used
+	entries map[string]*list.Element
+}
+
+type memoryItem struct {
+	key   string
+	entry *Entry
+	size  int64
+}
+
+func NewMemoryStore(maxBytes int64) *MemoryStore {
+	return &MemoryStore{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+func (s *MemoryStore) Get(key string) (*Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	s.order.MoveToFront(elem)
+	return elem.Value.(*memoryItem).entry, true
+}
+
+func (s *MemoryStore) Set(key string, e *Entry) {
+	size := e.size()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+	if size > s.maxBytes {
+		return
+	}
+	s.entries[key] = s.order.PushFront(&memoryItem{key: key, entry: e, size: size})
+	s.size += size
+	for s.size > s.maxBytes {
+		s.remove(s.order.Back().Value.(*memoryItem).key)
+	}
+}
+
+func (s *MemoryStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+}
+
+// Size returns the total size of the stored entries in bytes.
+func (s *MemoryStore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+func (s *MemoryStore) remove(key string) {
+	elem, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	s.order.Remove(elem)
+	delete(s.entries, key)
+	s.size -= elem.Value.(*memoryItem).size
+}
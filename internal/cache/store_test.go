@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEntry(body string) *Entry {
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/plain")
+	return &Entry{StatusCode: 200, Headers: h, Body: []byte(body), ResponseTime: time.Unix(1700000000, 0).UTC()}
+}
+
+func TestMemoryStore(t *testing.T) {
+	size := testEntry("aaaa").size()
+	s := NewMemoryStore(3 * size)
+
+	// Test: entries round-trip
+	s.Set("a", testEntry("aaaa"))
+	s.Set("b", testEntry("bbbb"))
+	s.Set("c", testEntry("cccc"))
+	e, ok := s.Get("a")
+	require.True(t, ok)
+	assert.Equal(t, "aaaa", string(e.Body))
+	assert.Equal(t, 3*size, s.Size())
+
+	// Test: the least recently used entry is evicted first
+	s.Set("d", testEntry("dddd"))
+	_, ok = s.Get("b")
+	assert.False(t, ok)
+	_, ok = s.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 3*size, s.Size())
+
+	// Test: replacing and deleting keep the size right
+	s.Set("a", testEntry("aaaa"))
+	assert.Equal(t, 3*size, s.Size())
+	s.Delete("a")
+	assert.Equal(t, 2*size, s.Size())
+
+	// Test: entries bigger than the cap aren't stored
+	s.Set("huge", testEntry(string(make([]byte, 4*size))))
+	_, ok = s.Get("huge")
+	assert.False(t, ok)
+}
+
+func TestDiskStore(t *testing.T) {
+	s, err := NewDiskStore(t.TempDir(), 1<<20)
+	require.NoError(t, err)
+
+	// Test: entries round-trip through the files
+	s.Set("example.com/a", testEntry("hello"))
+	e, ok := s.Get("example.com/a")
+	require.True(t, ok)
+	assert.Equal(t, "hello", string(e.Body))
+	assert.Equal(t, 200, e.StatusCode)
+	ct, _ := e.Headers.Get("Content-Type")
+	assert.Equal(t, "text/plain", ct)
+	assert.True(t, e.ResponseTime.Equal(time.Unix(1700000000, 0)))
+
+	// Test: vary markers round-trip
+	s.Set("example.com/v", &Entry{VaryFields: []string{"Accept-Language"}})
+	e, ok = s.Get("example.com/v")
+	require.True(t, ok)
+	assert.Equal(t, []string{"Accept-Language"}, e.VaryFields)
+
+	// Test: deleted and unknown keys miss
+	s.Delete("example.com/a")
+	_, ok = s.Get("example.com/a")
+	assert.False(t, ok)
+	_, ok = s.Get("example.com/unknown")
+	assert.False(t, ok)
+}
+
+func TestDiskStoreEviction(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDiskStore(dir, 1<<20)
+	require.NoError(t, err)
+	s.Set("a", testEntry("aaaa"))
+	size := s.Size()
+	require.Positive(t, size)
+
+	s, err = NewDiskStore(dir, 3*size)
+	require.NoError(t, err)
+
+	// Test: files left by an earlier store are picked up and counted
+	_, ok := s.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, size, s.Size())
+
+	// Test: the least recently used entry is evicted first, file and all
+	s.Set("b", testEntry("bbbb"))
+	s.Set("c", testEntry("cccc"))
+	s.Get("a")
+	s.Set("d", testEntry("dddd"))
+	_, ok = s.Get("b")
+	assert.False(t, ok)
+	_, ok = s.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 3*size, s.Size())
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 3)
+
+	// Test: replacing and deleting keep the size right
+	s.Set("a", testEntry("aaaa"))
+	assert.Equal(t, 3*size, s.Size())
+	s.Delete("a")
+	assert.Equal(t, 2*size, s.Size())
+
+	// Test: entries bigger than the cap aren't stored
+	s.Set("huge", testEntry(string(make([]byte, 4*size))))
+	_, ok = s.Get("huge")
+	assert.False(t, ok)
+	assert.Equal(t, 2*size, s.Size())
+
+	// Test: a smaller cap evicts on start, leftover temporary files go too
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tmp-123"), []byte("partial"), 0o644))
+	s, err = NewDiskStore(dir, size)
+	require.NoError(t, err)
+	assert.Equal(t, size, s.Size())
+	files, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
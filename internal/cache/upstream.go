@@ -0,0 +1,170 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/client"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/server"
+)
+
+// errHijacked ends the read of a response whose handler took over the
+// client's connection instead.
+var errHijacked = errors.New("handler hijacked the connection")
+
+// upstream is a response next is still writing. Its body is read from a
+// pipe as next writes it, so nothing piles up between next and the client.
+type upstream struct {
+	resp         *client.Response
+	requestTime  time.Time
+	responseTime time.Time
+
+	pr *io.PipeReader
+	pw *pipeWriter
+	// closed once the cache stopped writing to the client
+	relayed chan struct{}
+	// closed once next returned
+	done chan struct{}
+}
+
+// pipeWriter is the connection next writes its response to.
+type pipeWriter struct {
+	*io.PipeWriter
+	// the client's writer, nil for background revalidations
+	client *response.Writer
+	// next flushed, so it's streaming and the response isn't stored
+	flushed atomic.Bool
+}
+
+func (p *pipeWriter) Flush() error {
+	p.flushed.Store(true)
+	return nil
+}
+
+// CloseNotify is the client's, or closed already when there's no client.
+func (p *pipeWriter) CloseNotify() <-chan struct{} {
+	if p.client == nil {
+		closed := make(chan struct{})
+		close(closed)
+		return closed
+	}
+	return p.client.CloseNotify()
+}
+
+// call starts next on req and returns once it has written the response
+// head. w is the client's writer, which next may hijack, or nil. The
+// upstream has to be finished.
+func call(next server.Handler, req *request.Request, w *response.Writer) (*upstream, error) {
+	pr, pw := io.Pipe()
+	u := &upstream{
+		requestTime: now(),
+		pr:          pr,
+		pw:          &pipeWriter{PipeWriter: pw, client: w},
+		relayed:     make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	rw := response.NewWriter(u.pw)
+	rw.SetHijacker(u.hijack)
+	go func() {
+		defer close(u.done)
+		next(rw, req)
+		pw.CloseWithError(rw.Close())
+	}()
+	resp, err := client.ReadResponse(bufio.NewReader(pr), req.RequestLine.Method)
+	if err != nil {
+		u.finish()
+		return nil, err
+	}
+	u.resp, u.responseTime = resp, now()
+	return u, nil
+}
+
+// hijack hands next the client's connection once the cache has stopped
+// writing to it.
+func (u *upstream) hijack() (net.Conn, []byte, error) {
+	if u.pw.client == nil {
+		return nil, nil, errors.New("no client connection to hijack")
+	}
+	u.pw.CloseWithError(errHijacked)
+	<-u.relayed
+	return u.pw.client.Hijack()
+}
+
+// finish stops reading the response, failing whatever next still writes,
+// and waits for next to return.
+func (u *upstream) finish() {
+	u.pr.Close()
+	close(u.relayed)
+	<-u.done
+}
+
+// streamed reports whether next flushed the response as it went.
+func (u *upstream) streamed() bool {
+	return u.pw.flushed.Load()
+}
+
+// keep reads the rest of body into kept. It stops early, reporting false,
+// once kept overflows or next flushes the response.
+func (u *upstream) keep(kept *cappedBuffer, body io.Reader) (bool, error) {
+	buf := make([]byte, 32*1024)
+	for !kept.overflowed && !u.streamed() {
+		n, err := body.Read(buf)
+		kept.Write(buf[:n])
+		if err == io.EOF {
+			return !kept.overflowed && !u.streamed(), nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// entry is what would be stored for the response with body.
+func (u *upstream) entry(body []byte) *Entry {
+	h := headers.NewHeaders()
+	for k, v := range u.resp.Headers {
+		h[k] = v
+	}
+	for _, name := range unstoredHeaders {
+		h.Delete(name)
+	}
+	for _, name := range u.resp.Headers.Values("Connection") {
+		h.Delete(name)
+	}
+	return &Entry{
+		StatusCode:   u.resp.StatusCode,
+		Headers:      h,
+		Body:         body,
+		RequestTime:  u.requestTime,
+		ResponseTime: u.responseTime,
+	}
+}
+
+// cappedBuffer keeps what's written to it until that would take it past
+// max, then drops it all and only remembers that it overflowed.
+type cappedBuffer struct {
+	bytes.Buffer
+	max        int
+	overflowed bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.overflowed {
+		return len(p), nil
+	}
+	if b.Len()+len(p) > b.max {
+		b.overflowed = true
+		b.Buffer = bytes.Buffer{}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,325 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+)
+
+const (
+	DefaultDialTimeout         = 10 * time.Second
+	DefaultIdleTimeout         = 90 * time.Second
+	DefaultMaxIdleConnsPerHost = 2
+)
+
+// Client sends requests over raw TCP (or TLS for https) connections and keeps
+// finished connections around for reuse. The zero value is ready to use.
+type Client struct {
+	// how long establishing a connection may take, 0 means DefaultDialTimeout
+	DialTimeout time.Duration
+	// how long a pooled connection may sit unused, 0 means DefaultIdleTimeout
+	IdleTimeout time.Duration
+	// 0 means DefaultMaxIdleConnsPerHost, negative disables keep-alive
+	MaxIdleConnsPerHost int
+	// used for https upstreams, nil uses the system roots
+	TLSConfig *tls.Config
+	// if set, called with the resolved address of each connection before
+	// it's made, an error refuses it. See net.Dialer.Control.
+	Control func(network, address string, c syscall.RawConn) error
+
+	mu   sync.Mutex
+	idle map[string][]*persistConn
+}
+
+type persistConn struct {
+	conn      net.Conn
+	br        *bufio.Reader
+	key       string
+	idleSince time.Time
+}
+
+// a time in the past that makes blocked reads and writes fail immediately
+var aLongTimeAgo = time.Unix(1, 0)
+
+// Do sends req to the scheme and host of upstream and returns the response
+// once its headers have arrived. The request target is taken from req as is,
+// and a Host header filled in if req doesn't have one. The caller has to
+// read the body to the end or close it, which returns the connection to the
+// pool when the exchange allows it. ctx bounds the whole exchange, including
+// reading the body.
+func (c *Client) Do(ctx context.Context, upstream *url.URL, req *request.Request) (*Response, error) {
+	addr, err := hostPort(upstream)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := req.Headers.Get("Host"); !ok {
+		req.Headers.Set("Host", upstream.Host)
+	}
+	key := upstream.Scheme + "://" + addr
+	for attempt := 0; ; attempt++ {
+		pc, reused, err := c.getConn(ctx, key, upstream.Scheme, addr)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.roundTrip(ctx, pc, req)
+		if err == nil {
+			return resp, nil
+		}
+		pc.conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// the conn's deadline can fire a moment before ctx notices its own
+		if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+			return nil, context.DeadlineExceeded
+		}
+		// a pooled connection may have been closed by the server while idle,
+		// that's worth one more try on a fresh connection
+		if !reused || attempt > 0 || !request.IsIdempotent(req.RequestLine.Method) {
+			return nil, err
+		}
+	}
+}
+
+// CloseIdleConnections closes every pooled connection.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, conns := range c.idle {
+		for _, pc := range conns {
+			pc.conn.Close()
+		}
+		delete(c.idle, key)
+	}
+}
+
+func (c *Client) roundTrip(ctx context.Context, pc *persistConn, req *request.Request) (*Response, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		pc.conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		pc.conn.SetDeadline(aLongTimeAgo)
+	})
+	bw := bufio.NewWriter(pc.conn)
+	err := WriteRequest(bw, req)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		stop()
+		return nil, fmt.Errorf("failed to write request: %w", err)
+	}
+	resp, err := ReadResponse(pc.br, req.RequestLine.Method)
+	if err != nil {
+		stop()
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	b := &body{
+		r:        resp.Body,
+		client:   c,
+		pc:       pc,
+		reusable: !resp.Close() && !wantsClose(req),
+		stop:     stop,
+	}
+	resp.Body = b
+	if resp.noBody || resp.ContentLength == 0 {
+		b.release(b.reusable)
+	}
+	return resp, nil
+}
+
+func (c *Client) getConn(ctx context.Context, key, scheme, addr string) (*persistConn, bool, error) {
+	if pc := c.getIdle(key); pc != nil {
+		return pc, true, nil
+	}
+	dialTimeout := c.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = DefaultDialTimeout
+	}
+	dialer := &net.Dialer{Timeout: dialTimeout, Control: c.Control}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, false, err
+	}
+	if scheme == "https" {
+		cfg := &tls.Config{}
+		if c.TLSConfig != nil {
+			cfg = c.TLSConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		cfg.NextProtos = []string{"http/1.1"}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, false, err
+		}
+		conn = tlsConn
+	}
+	return &persistConn{conn: conn, br: bufio.NewReader(conn), key: key}, false, nil
+}
+
+func (c *Client) getIdle(key string) *persistConn {
+	idleTimeout := c.IdleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = DefaultIdleTimeout
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conns := c.idle[key]
+	for len(conns) > 0 {
+		// the most recently used connection is the least likely to have been closed
+		pc := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		if time.Since(pc.idleSince) < idleTimeout {
+			c.idle[key] = conns
+			return pc
+		}
+		pc.conn.Close()
+	}
+	delete(c.idle, key)
+	return nil
+}
+
+func (c *Client) putIdle(pc *persistConn) bool {
+	maxIdle := c.MaxIdleConnsPerHost
+	if maxIdle == 0 {
+		maxIdle = DefaultMaxIdleConnsPerHost
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.idle[pc.key]) >= maxIdle {
+		return false
+	}
+	if c.idle == nil {
+		c.idle = map[string][]*persistConn{}
+	}
+	pc.conn.SetDeadline(time.Time{})
+	pc.idleSince = time.Now()
+	c.idle[pc.key] = append(c.idle[pc.key], pc)
+	return true
+}
+
+// body hands the connection back to the pool once the response has been
+// read to the end, and closes it if the caller gives up earlier.
+type body struct {
+	r        io.Reader
+	client   *Client
+	pc       *persistConn
+	reusable bool
+	stop     func() bool
+	done     bool
+	err      error
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.done {
+		if b.err != nil {
+			return 0, b.err
+		}
+		return 0, io.EOF
+	}
+	n, err := b.r.Read(p)
+	if err == io.EOF {
+		b.release(b.reusable)
+	} else if err != nil {
+		b.err = err
+		b.release(false)
+	}
+	return n, err
+}
+
+func (b *body) Close() error {
+	if !b.done {
+		b.release(false)
+	}
+	return nil
+}
+
+func (b *body) release(reuse bool) {
+	b.done = true
+	// if the context already fired, the connection's deadline is spoiled
+	if !b.stop() {
+		reuse = false
+	}
+	if reuse && b.client.putIdle(b.pc) {
+		return
+	}
+	b.pc.conn.Close()
+}
+
+// WriteRequest writes req in HTTP/1.1 wire format. Content-Length is set
+// from the body rather than taken from the request's headers.
+func WriteRequest(w io.Writer, req *request.Request) error {
+	method, target := req.RequestLine.Method, req.RequestLine.RequestTarget
+	if method == "" || target == "" || strings.ContainsAny(method+target, " \r\n") {
+		return fmt.Errorf("invalid request line: %q %q", method, target)
+	}
+	if _, err := fmt.Fprintf(w, "%s %s HTTP/1.1\r\n", method, target); err != nil {
+		return err
+	}
+	for k, v := range req.Headers {
+		if k == "content-length" {
+			continue
+		}
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("invalid value for header %s", k)
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s\r\n", k, v); err != nil {
+			return err
+		}
+	}
+	if len(req.Body) > 0 || method == "POST" || method == "PUT" || method == "PATCH" {
+		if _, err := fmt.Fprintf(w, "content-length: %d\r\n", len(req.Body)); err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(w, "\r\n"); err != nil {
+		return err
+	}
+	_, err := w.Write(req.Body)
+	return err
+}
+
+func hostPort(u *url.URL) (string, error) {
+	var port string
+	switch u.Scheme {
+	case "http":
+		port = "80"
+	case "https":
+		port = "443"
+	default:
+		return "", fmt.Errorf("unsupported scheme: %s", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("missing host in %s", u)
+	}
+	if p := u.Port(); p != "" {
+		if _, err := strconv.Atoi(p); err != nil {
+			return "", fmt.Errorf("invalid port in %s", u)
+		}
+		port = p
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
+
+func wantsClose(req *request.Request) bool {
+	for _, token := range req.Headers.Values("Connection") {
+		if strings.EqualFold(token, "close") {
+			return true
+		}
+	}
+	return false
+}
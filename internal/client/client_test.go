@@ -0,0 +1,247 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readTestResponse(t *testing.T, raw, method string) (*Response, string) {
+	t.Helper()
+	resp, err := ReadResponse(bufio.NewReader(strings.NewReader(raw)), method)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestReadResponse(t *testing.T) {
+	// Test: Content-Length body
+	resp, body := readTestResponse(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Type: text/plain\r\n\r\nhello", "GET")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "OK", resp.Status)
+	assert.Equal(t, int64(5), resp.ContentLength)
+	assert.Equal(t, "hello", body)
+	assert.False(t, resp.Close())
+
+	// Test: chunked body with trailers
+	resp, body = readTestResponse(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n"+
+		"5\r\nhello\r\n7;ext=1\r\n, world\r\n0\r\nX-Sum: abc\r\n\r\n", "GET")
+	assert.Equal(t, int64(-1), resp.ContentLength)
+	assert.Equal(t, "hello, world", body)
+	sum, _ := resp.Trailers.Get("X-Sum")
+	assert.Equal(t, "abc", sum)
+	assert.False(t, resp.Close())
+
+	// Test: body delimited by the connection closing
+	resp, body = readTestResponse(t, "HTTP/1.1 200 OK\r\n\r\nuntil the end", "GET")
+	assert.Equal(t, "until the end", body)
+	assert.True(t, resp.Close())
+
+	// Test: interim responses are skipped
+	resp, body = readTestResponse(t, "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 103 Early Hints\r\nLink: </a.css>\r\n\r\n"+
+		"HTTP/1.1 204 No Content\r\n\r\n", "POST")
+	assert.Equal(t, 204, resp.StatusCode)
+	assert.Empty(t, body)
+	_, ok := resp.Headers.Get("Link")
+	assert.False(t, ok)
+
+	// Test: HEAD responses have no body despite Content-Length
+	resp, body = readTestResponse(t, "HTTP/1.1 200 OK\r\nContent-Length: 42\r\n\r\n", "HEAD")
+	assert.Equal(t, int64(42), resp.ContentLength)
+	assert.Empty(t, body)
+
+	// Test: empty reason phrase, separate Set-Cookie lines, empty header values
+	resp, _ = readTestResponse(t, "HTTP/1.1 599\r\nSet-Cookie: a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\nSet-Cookie: b=2\r\nX-Empty:\r\nContent-Length: 0\r\n\r\n", "GET")
+	assert.Equal(t, 599, resp.StatusCode)
+	assert.Equal(t, "", resp.Status)
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT", "b=2"}, resp.SetCookies)
+
+	// Test: HTTP/1.0 responses close the connection
+	resp, _ = readTestResponse(t, "HTTP/1.0 200 OK\r\nContent-Length: 0\r\n\r\n", "GET")
+	assert.True(t, resp.Close())
+
+	// Test: malformed responses
+	for _, raw := range []string{
+		"HTTP/2 200 OK\r\n\r\n",
+		"HTTP/1.1 20 OK\r\n\r\n",
+		"HTTP/1.1 abc OK\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nno colon\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: -1\r\n\r\n",
+	} {
+		_, err := ReadResponse(bufio.NewReader(strings.NewReader(raw)), "GET")
+		assert.Error(t, err, raw)
+	}
+
+	// Test: truncated bodies are errors
+	for _, raw := range []string{
+		"HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort",
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel",
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n",
+	} {
+		resp, err := ReadResponse(bufio.NewReader(strings.NewReader(raw)), "GET")
+		require.NoError(t, err)
+		_, err = io.ReadAll(resp.Body)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestWriteRequest(t *testing.T) {
+	h := headers.NewHeaders()
+	h.Set("Host", "example.com")
+	h.Set("Content-Length", "999")
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "POST", RequestTarget: "/submit", HttpVersion: "1.1"},
+		Headers:     h,
+		Body:        []byte("data"),
+	}
+
+	// Test: the request round-trips through the request parser
+	var buf bytes.Buffer
+	require.NoError(t, WriteRequest(&buf, req))
+	parsed, err := request.RequestFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, "POST", parsed.RequestLine.Method)
+	assert.Equal(t, "/submit", parsed.RequestLine.RequestTarget)
+	assert.Equal(t, "data", string(parsed.Body))
+	length, _ := parsed.Headers.Get("Content-Length")
+	assert.Equal(t, "4", length)
+
+	// Test: header injection is refused
+	h.Set("X-Bad", "a\r\nInjected: yes")
+	assert.Error(t, WriteRequest(&buf, req))
+}
+
+// startRawServer accepts connections and answers every request on them with
+// respond until it returns false.
+func startRawServer(t *testing.T, respond func(conn net.Conn, req *request.Request) bool) (*url.URL, *atomic.Int32) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	accepted := &atomic.Int32{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					req, err := request.RequestFromReader(r)
+					if err != nil || !respond(conn, req) {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	u, err := url.Parse("http://" + listener.Addr().String())
+	require.NoError(t, err)
+	return u, accepted
+}
+
+func newGet(target string) *request.Request {
+	return &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: target, HttpVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	u, accepted := startRawServer(t, func(conn net.Conn, req *request.Request) bool {
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: "+string(rune('0'+len(req.RequestLine.RequestTarget)))+"\r\n\r\n"+req.RequestLine.RequestTarget)
+		return true
+	})
+	c := &Client{}
+	defer c.CloseIdleConnections()
+
+	// Test: sequential requests share one connection
+	for _, target := range []string{"/a", "/bb", "/ccc"} {
+		resp, err := c.Do(context.Background(), u, newGet(target))
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, target, string(body))
+	}
+	assert.Equal(t, int32(1), accepted.Load())
+
+	// Test: a body closed early doesn't return the connection to the pool
+	resp, err := c.Do(context.Background(), u, newGet("/dddd"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	resp, err = c.Do(context.Background(), u, newGet("/e"))
+	require.NoError(t, err)
+	io.ReadAll(resp.Body)
+	assert.Equal(t, int32(2), accepted.Load())
+}
+
+func TestStaleConnectionRetry(t *testing.T) {
+	// answers one request per connection without announcing the close
+	u, accepted := startRawServer(t, func(conn net.Conn, req *request.Request) bool {
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+		return false
+	})
+	c := &Client{}
+	defer c.CloseIdleConnections()
+
+	for i := 0; i < 2; i++ {
+		resp, err := c.Do(context.Background(), u, newGet("/"))
+		require.NoError(t, err)
+		io.ReadAll(resp.Body)
+	}
+	// Test: the second request was retried on a fresh connection
+	assert.Equal(t, int32(2), accepted.Load())
+}
+
+func TestConnectionClose(t *testing.T) {
+	u, accepted := startRawServer(t, func(conn net.Conn, req *request.Request) bool {
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: 2\r\n\r\nok")
+		return false
+	})
+	c := &Client{}
+	for i := 0; i < 2; i++ {
+		resp, err := c.Do(context.Background(), u, newGet("/"))
+		require.NoError(t, err)
+		io.ReadAll(resp.Body)
+	}
+	// Test: Connection: close responses aren't pooled
+	assert.Equal(t, int32(2), accepted.Load())
+}
+
+func TestTimeout(t *testing.T) {
+	u, _ := startRawServer(t, func(conn net.Conn, req *request.Request) bool {
+		time.Sleep(200 * time.Millisecond)
+		return false
+	})
+	c := &Client{}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// Test: the context bounds waiting for the response
+	start := time.Now()
+	_, err := c.Do(ctx, u, newGet("/"))
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, time.Since(start), 150*time.Millisecond)
+
+	// Test: unsupported schemes are rejected
+	_, err = c.Do(context.Background(), &url.URL{Scheme: "ftp", Host: "example.com"}, newGet("/"))
+	assert.Error(t, err)
+}
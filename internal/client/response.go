@@ -0,0 +1,285 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+)
+
+type Response struct {
+	HttpVersion string
+	StatusCode  int
+	// the reason phrase, may be empty
+	Status  string
+	Headers headers.Headers
+	// Set-Cookie values, kept apart since they can't be comma-joined
+	SetCookies []string
+	// -1 if the length isn't known up front
+	ContentLength int64
+	// filled in once a chunked body has been read to the end
+	Trailers headers.Headers
+	Body     io.ReadCloser
+	// the body ends when the connection does
+	closeDelimited bool
+	// no body follows regardless of the headers, e.g. for HEAD
+	noBody bool
+}
+
+// Close reports whether the connection can't be used after this response.
+func (r *Response) Close() bool {
+	if r.closeDelimited {
+		return true
+	}
+	for _, token := range r.Headers.Values("Connection") {
+		if strings.EqualFold(token, "close") {
+			return true
+		}
+	}
+	return r.HttpVersion != "1.1"
+}
+
+// ReadResponse reads a response to a request with the given method, skipping
+// interim 1xx responses other than 101 Switching Protocols. The body is read
+// from r as the caller consumes it.
+func ReadResponse(r *bufio.Reader, method string) (*Response, error) {
+	for {
+		resp, err := readResponseHead(r)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 100 && resp.StatusCode < 200 && resp.StatusCode != 101 {
+			continue
+		}
+		if err := resp.setBody(r, method); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+func readResponseHead(r *bufio.Reader) (*Response, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	resp := &Response{
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
+	}
+	if err := resp.parseStatusLine(line); err != nil {
+		return nil, err
+	}
+	if err := readHeaders(r, resp.Headers, &resp.SetCookies); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *Response) parseStatusLine(line string) error {
+	version, rest, ok := strings.Cut(line, " ")
+	if !ok {
+		return fmt.Errorf("malformed status line: %s", line)
+	}
+	switch version {
+	case "HTTP/1.1", "HTTP/1.0":
+		r.HttpVersion = strings.TrimPrefix(version, "HTTP/")
+	default:
+		return fmt.Errorf("unrecognized HTTP-version: %s", version)
+	}
+	code, reason, _ := strings.Cut(rest, " ")
+	if len(code) != 3 {
+		return fmt.Errorf("malformed status code: %s", code)
+	}
+	statusCode, err := strconv.Atoi(code)
+	if err != nil || statusCode < 100 {
+		return fmt.Errorf("malformed status code: %s", code)
+	}
+	r.StatusCode = statusCode
+	r.Status = reason
+	return nil
+}
+
+// readHeaders reads header lines up to the empty line ending the block.
+// Set-Cookie values go to cookies if it isn't nil.
+func readHeaders(r *bufio.Reader, h headers.Headers, cookies *[]string) error {
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			return nil
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return fmt.Errorf("malformed header line: %s", line)
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			// the parser rejects empty values, but servers do send them
+			continue
+		}
+		if cookies != nil && strings.EqualFold(name, "Set-Cookie") {
+			*cookies = append(*cookies, value)
+			continue
+		}
+		if _, _, err := h.Parse([]byte(name + ": " + value + "\r\n")); err != nil {
+			return err
+		}
+	}
+}
+
+// readLine reads a CRLF (or bare LF) terminated line without the terminator.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return "", fmt.Errorf("line too long")
+		}
+		if errors.Is(err, io.EOF) && len(line) > 0 {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return string(bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))), nil
+}
+
+// setBody picks the body framing following RFC 9112 6.3.
+func (r *Response) setBody(br *bufio.Reader, method string) error {
+	r.ContentLength = -1
+	if method == "HEAD" || r.StatusCode == 204 || r.StatusCode == 304 {
+		if length, ok, err := r.Headers.ContentLength(); ok && err == nil {
+			r.ContentLength = length
+		}
+		r.noBody = true
+		r.Body = io.NopCloser(io.LimitReader(br, 0))
+		return nil
+	}
+	if r.StatusCode == 101 {
+		// whatever follows belongs to the new protocol
+		r.closeDelimited = true
+		r.Body = io.NopCloser(br)
+		return nil
+	}
+	if te, ok := r.Headers.Get("Transfer-Encoding"); ok {
+		codings := headers.ParseList(te)
+		if len(codings) == 0 || !strings.EqualFold(codings[len(codings)-1], "chunked") {
+			// without chunked last the body runs until the connection closes
+			r.closeDelimited = true
+			r.Body = io.NopCloser(br)
+			return nil
+		}
+		r.Headers.Delete("Content-Length")
+		r.Body = io.NopCloser(&chunkedReader{r: br, trailers: r.Trailers})
+		return nil
+	}
+	length, ok, err := r.Headers.ContentLength()
+	if err != nil {
+		return fmt.Errorf("malformed content-length: %w", err)
+	}
+	if ok {
+		r.ContentLength = length
+		r.Body = io.NopCloser(&lengthReader{r: br, remaining: length})
+		return nil
+	}
+	r.closeDelimited = true
+	r.Body = io.NopCloser(br)
+	return nil
+}
+
+// lengthReader reads a Content-Length body, which unlike io.LimitReader
+// treats the connection closing early as an error.
+type lengthReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *lengthReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if err == io.EOF && l.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+type chunkedReader struct {
+	r        *bufio.Reader
+	trailers headers.Headers
+	// bytes left in the current chunk
+	remaining int64
+	done      bool
+	err       error
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.done {
+		return 0, io.EOF
+	}
+	if c.remaining == 0 {
+		size, err := c.readChunkSize()
+		if err != nil {
+			c.err = err
+			return 0, err
+		}
+		if size == 0 {
+			if err := readHeaders(c.r, c.trailers, nil); err != nil {
+				c.err = err
+				return 0, err
+			}
+			c.done = true
+			return 0, io.EOF
+		}
+		c.remaining = size
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if c.remaining == 0 && err == nil {
+		// every chunk's data is followed by a CRLF
+		if line, lerr := readLine(c.r); lerr != nil || line != "" {
+			err = fmt.Errorf("malformed chunk terminator")
+		}
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+func (c *chunkedReader) readChunkSize() (int64, error) {
+	line, err := readLine(c.r)
+	if err != nil {
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	// chunk extensions aren't used by anything we talk to
+	sizeText, _, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeText), 16, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("malformed chunk size: %s", line)
+	}
+	return size, nil
+}
@@ -0,0 +1,197 @@
+package fileserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/server"
+)
+
+const indexFile = "index.html"
+
+type Options struct {
+	// Prefix is stripped from the request path before looking up files,
+	// e.g. "/static" serves /static/app.js from app.js in the root
+	Prefix string
+	// Listing renders an HTML index for directories without an index.html
+	Listing bool
+}
+
+type fileServer struct {
+	fsys fs.FS
+	opts Options
+}
+
+// New returns a handler serving files from fsys.
+func New(fsys fs.FS, opts Options) server.Handler {
+	fsrv := &fileServer{
+		fsys: fsys,
+		opts: opts,
+	}
+	fsrv.opts.Prefix = strings.TrimSuffix(opts.Prefix, "/")
+	return fsrv.serve
+}
+
+// Dir returns a handler serving files from a directory on disk.
+func Dir(root string, opts Options) server.Handler {
+	return New(os.DirFS(root), opts)
+}
+
+// ServeFile responds with a single file from disk, regardless of the request path.
+func ServeFile(w *response.Writer, req *request.Request, name string) {
+	dir, file := path.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+	fsrv := &fileServer{fsys: os.DirFS(dir)}
+	if !allowedMethod(w, req) {
+		return
+	}
+	fsrv.serveFile(w, req, file)
+}
+
+func (fsrv *fileServer) serve(w *response.Writer, req *request.Request) {
+	if !allowedMethod(w, req) {
+		return
+	}
+	target, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	urlPath, err := url.PathUnescape(target)
+	if err != nil || strings.ContainsAny(urlPath, "\x00\\") {
+		response.WriteError(w, req, response.StatusCodeBadRequest, "The requested path is malformed.")
+		return
+	}
+	if fsrv.opts.Prefix != "" {
+		rest, ok := strings.CutPrefix(urlPath, fsrv.opts.Prefix)
+		if !ok || (rest != "" && rest[0] != '/') {
+			response.WriteError(w, req, response.StatusCodeNotFound, "The requested file doesn't exist.")
+			return
+		}
+		urlPath = rest
+	}
+
+	// cleaning a rooted path resolves every ".." without climbing above the root
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) {
+		response.WriteError(w, req, response.StatusCodeBadRequest, "The requested path is malformed.")
+		return
+	}
+
+	info, err := fs.Stat(fsrv.fsys, name)
+	if err != nil {
+		fsrv.writeFSError(w, req, err)
+		return
+	}
+	if !info.IsDir() {
+		fsrv.serveFile(w, req, name)
+		return
+	}
+
+	// directories are only served with a trailing slash so relative links resolve
+	if !strings.HasSuffix(urlPath, "/") {
+		// built from the cleaned name, so "//host" can't become a
+		// protocol-relative Location
+		location := (&url.URL{Path: path.Join("/", fsrv.opts.Prefix, name) + "/"}).EscapedPath()
+		if _, query, ok := strings.Cut(req.RequestLine.RequestTarget, "?"); ok {
+			location += "?" + query
+		}
+		redirect(w, req, location)
+		return
+	}
+	index := path.Join(name, indexFile)
+	if info, err := fs.Stat(fsrv.fsys, index); err == nil && !info.IsDir() {
+		fsrv.serveFile(w, req, index)
+		return
+	}
+	if !fsrv.opts.Listing {
+		response.WriteError(w, req, response.StatusCodeForbidden, "Directory listing is disabled.")
+		return
+	}
+	fsrv.serveListing(w, req, name, urlPath)
+}
+
+func (fsrv *fileServer) serveFile(w *response.Writer, req *request.Request, name string) {
+	f, err := fsrv.fsys.Open(name)
+	if err != nil {
+		fsrv.writeFSError(w, req, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		fsrv.writeFSError(w, req, err)
+		return
+	}
+	if info.IsDir() {
+		response.WriteError(w, req, response.StatusCodeNotFound, "The requested file doesn't exist.")
+		return
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		// not every fs.FS can seek, fall back to buffering the file
+		data, err := io.ReadAll(f)
+		if err != nil {
+			fsrv.writeFSError(w, req, err)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+
+	contentType, err := detectContentType(name, content)
+	if err != nil {
+		fsrv.writeFSError(w, req, err)
+		return
+	}
+	h := response.GetDefaultHeaders(0)
+	h.Set("Content-Type", contentType)
+	h.Set("ETag", response.WeakETag(info.ModTime(), info.Size()))
+	if !info.ModTime().IsZero() {
+		h.SetTime("Last-Modified", info.ModTime())
+	}
+	if err := response.ServeContent(w, req, h, content); err != nil {
+		log.Printf("error serving %s: %v", name, err)
+	}
+}
+
+func (fsrv *fileServer) writeFSError(w *response.Writer, req *request.Request, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		response.WriteError(w, req, response.StatusCodeNotFound, "The requested file doesn't exist.")
+	case errors.Is(err, fs.ErrPermission):
+		response.WriteError(w, req, response.StatusCodeForbidden, "You don't have access to the requested file.")
+	default:
+		log.Printf("error reading file: %v", err)
+		response.WriteError(w, req, response.StatusCodeInternalServerError, "The requested file couldn't be read.")
+	}
+}
+
+func allowedMethod(w *response.Writer, req *request.Request) bool {
+	method := req.RequestLine.Method
+	if method == "GET" || method == "HEAD" {
+		return true
+	}
+	allow := headers.NewHeaders()
+	allow.Set("Allow", "GET, HEAD")
+	response.WriteErrorWithHeaders(w, req, response.StatusCodeMethodNotAllowed, fmt.Sprintf("%s is not supported for files.", method), allow)
+	return false
+}
+
+func redirect(w *response.Writer, req *request.Request, location string) {
+	h := headers.NewHeaders()
+	h.Set("Location", location)
+	response.WriteErrorWithHeaders(w, req, response.StatusCodeMovedPermanently, "The resource has moved to "+location, h)
+}
@@ -0,0 +1,136 @@
+package fileserver
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testFS = fstest.MapFS{
+	"hello.txt":         {Data: []byte("hello, world\n"), ModTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+	"docs/index.html":   {Data: []byte("<html>docs</html>")},
+	"files/a.css":       {Data: []byte("body {}")},
+	"files/noext":       {Data: []byte("<!DOCTYPE html><p>hi</p>")},
+	"files/sub/x.bin":   {Data: []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0, 0}},
+	"files/<script>.js": {Data: []byte("//")},
+}
+
+func get(t *testing.T, handler func(*response.Writer, *request.Request), method, target string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(method + " " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	handler(response.NewWriter(&buf), req)
+	return buf.String()
+}
+
+func TestFileServer(t *testing.T) {
+	handler := New(testFS, Options{Prefix: "/static/", Listing: true})
+
+	// Test: plain file with MIME type from the extension
+	out := get(t, handler, "GET", "/static/hello.txt")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "content-type: text/plain; charset=utf-8\r\n")
+	assert.Contains(t, out, "last-modified: Tue, 02 Jan 2024 03:04:05 GMT\r\n")
+	assert.Contains(t, out, "accept-ranges: bytes\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello, world\n"))
+
+	// Test: MIME type sniffed from the content
+	out = get(t, handler, "GET", "/static/files/noext")
+	assert.Contains(t, out, "content-type: text/html; charset=utf-8\r\n")
+
+	// Test: index.html is served for directories
+	out = get(t, handler, "GET", "/static/docs/")
+	assert.True(t, strings.HasSuffix(out, "<html>docs</html>"))
+
+	// Test: directory without trailing slash redirects
+	out = get(t, handler, "GET", "/static/docs")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 301 Moved Permanently\r\n"))
+	assert.Contains(t, out, "location: /static/docs/\r\n")
+
+	// Test: the redirect keeps the query and is built from the cleaned path
+	out = get(t, handler, "GET", "/static/docs?lang=en&v=2")
+	assert.Contains(t, out, "location: /static/docs/?lang=en&v=2\r\n")
+	out = get(t, handler, "GET", "/static//docs")
+	assert.Contains(t, out, "location: /static/docs/\r\n")
+	out = get(t, handler, "GET", "/static/files/./sub/../sub")
+	assert.Contains(t, out, "location: /static/files/sub/\r\n")
+	out = get(t, handler, "GET", "/static")
+	assert.Contains(t, out, "location: /static/\r\n")
+	out = get(t, New(testFS, Options{}), "GET", "//docs")
+	assert.Contains(t, out, "location: /docs/\r\n")
+
+	// Test: listing escapes names
+	out = get(t, handler, "GET", "/static/files/")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, `<a href="sub/">sub/</a>`)
+	assert.Contains(t, out, `<a href="a.css">a.css</a> (7 bytes)`)
+	assert.Contains(t, out, "&lt;script&gt;.js")
+	assert.NotContains(t, out, "<script>")
+
+	// Test: missing file and wrong prefix
+	out = get(t, handler, "GET", "/static/nope.txt")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+	out = get(t, handler, "GET", "/staticfiles/hello.txt")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: unsupported method
+	out = get(t, handler, "DELETE", "/static/hello.txt")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, out, "allow: GET, HEAD\r\n")
+
+	// Test: listing disabled
+	out = get(t, New(testFS, Options{}), "GET", "/files/")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 403 Forbidden\r\n"))
+}
+
+func TestFileServerConditional(t *testing.T) {
+	handler := New(testFS, Options{})
+	out := get(t, handler, "GET", "/hello.txt")
+	_, rest, _ := strings.Cut(out, "etag: ")
+	etag, _, _ := strings.Cut(rest, "\r\n")
+	assert.True(t, strings.HasPrefix(etag, `W/"`))
+
+	req, err := request.RequestFromReader(strings.NewReader("GET /hello.txt HTTP/1.1\r\nHost: localhost\r\nIf-None-Match: " + etag + "\r\n\r\n"))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	handler(response.NewWriter(&buf), req)
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 304 Not Modified\r\n"))
+	assert.NotContains(t, buf.String(), "hello, world")
+}
+
+func TestFileServerTraversal(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "public")
+	require.NoError(t, os.Mkdir(root, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "ok.txt"), []byte("ok"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0o644))
+	handler := Dir(root, Options{})
+
+	out := get(t, handler, "GET", "/ok.txt")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nok"))
+
+	for _, target := range []string{"/../secret.txt", "/%2e%2e/secret.txt", "/..%2fsecret.txt", "/a/../../secret.txt", "/..\\secret.txt"} {
+		out = get(t, handler, "GET", target)
+		assert.NotContains(t, out, "secret", target)
+		assert.False(t, strings.HasPrefix(out, "HTTP/1.1 200"), target)
+	}
+}
+
+func TestSniffContentType(t *testing.T) {
+	assert.Equal(t, "image/png", sniffContentType([]byte("\x89PNG\r\n\x1a\n....")))
+	assert.Equal(t, "video/mp4", sniffContentType([]byte("\x00\x00\x00\x20ftypisom")))
+	assert.Equal(t, "text/plain; charset=utf-8", sniffContentType([]byte("just some text")))
+	assert.Equal(t, "application/octet-stream", sniffContentType([]byte{0x00, 0x01, 0x02}))
+	// a multi-byte character cut off by the sniff window is still text
+	assert.Equal(t, "text/plain; charset=utf-8", sniffContentType([]byte("caf\xc3")))
+}
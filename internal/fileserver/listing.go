@@ -0,0 +1,89 @@
+package fileserver
+
+import (
+	"bytes"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/url"
+	"sort"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+)
+
+type listingEntry struct {
+	Name string
+	Href string
+	Size int64
+	Dir  bool
+}
+
+type listingData struct {
+	Path    string
+	Entries []listingEntry
+}
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<html>
+
+<head>
+  <title>Index of {{.Path}}</title>
+</head>
+
+<body>
+  <h1>Index of {{.Path}}</h1>
+  <ul>
+    {{- if ne .Path "/"}}
+    <li><a href="../">../</a></li>
+    {{- end}}
+    {{- range .Entries}}
+    <li><a href="{{.Href}}">{{.Name}}{{if .Dir}}/{{end}}</a>{{if not .Dir}} ({{.Size}} bytes){{end}}</li>
+    {{- end}}
+  </ul>
+</body>
+
+</html>
+`))
+
+func (fsrv *fileServer) serveListing(w *response.Writer, req *request.Request, name, urlPath string) {
+	dirEntries, err := fs.ReadDir(fsrv.fsys, name)
+	if err != nil {
+		fsrv.writeFSError(w, req, err)
+		return
+	}
+	entries := make([]listingEntry, 0, len(dirEntries))
+	for _, e := range dirEntries {
+		entry := listingEntry{
+			Name: e.Name(),
+			Href: url.PathEscape(e.Name()),
+			Dir:  e.IsDir(),
+		}
+		if entry.Dir {
+			entry.Href += "/"
+		} else if info, err := e.Info(); err == nil {
+			entry.Size = info.Size()
+		}
+		entries = append(entries, entry)
+	}
+	// directories first, then files, each alphabetically
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Dir != entries[j].Dir {
+			return entries[i].Dir
+		}
+		return entries[i].Name < entries[j].Name
+	})
+
+	var body bytes.Buffer
+	if err := listingTemplate.Execute(&body, listingData{Path: fsrv.opts.Prefix + urlPath, Entries: entries}); err != nil {
+		log.Printf("error rendering listing for %s: %v", name, err)
+		response.WriteError(w, req, response.StatusCodeInternalServerError, "The directory listing couldn't be rendered.")
+		return
+	}
+	h := response.GetDefaultHeaders(body.Len())
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	w.WriteHeaders(h)
+	if req.RequestLine.Method != "HEAD" {
+		w.WriteBody(body.Bytes())
+	}
+}
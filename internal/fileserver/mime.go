@@ -0,0 +1,87 @@
+package fileserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"unicode/utf8"
+)
+
+// the sniffer only ever looks at this many leading bytes
+const sniffLen = 512
+
+type signature struct {
+	prefix      []byte
+	offset      int
+	contentType string
+}
+
+var signatures = []signature{
+	{prefix: []byte("%PDF-"), contentType: "application/pdf"},
+	{prefix: []byte("\x89PNG\r\n\x1a\n"), contentType: "image/png"},
+	{prefix: []byte("\xff\xd8\xff"), contentType: "image/jpeg"},
+	{prefix: []byte("GIF87a"), contentType: "image/gif"},
+	{prefix: []byte("GIF89a"), contentType: "image/gif"},
+	{prefix: []byte("WEBP"), offset: 8, contentType: "image/webp"},
+	{prefix: []byte("ftyp"), offset: 4, contentType: "video/mp4"},
+	{prefix: []byte("\x1a\x45\xdf\xa3"), contentType: "video/webm"},
+	{prefix: []byte("OggS"), contentType: "application/ogg"},
+	{prefix: []byte("ID3"), contentType: "audio/mpeg"},
+	{prefix: []byte("PK\x03\x04"), contentType: "application/zip"},
+	{prefix: []byte("\x1f\x8b\x08"), contentType: "application/gzip"},
+	{prefix: []byte("wOFF"), contentType: "font/woff"},
+	{prefix: []byte("wOF2"), contentType: "font/woff2"},
+}
+
+// detectContentType uses the file extension if it's known and falls back to
+// sniffing the first bytes of the content, which is rewound afterwards.
+func detectContentType(name string, content io.ReadSeeker) (string, error) {
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType, nil
+	}
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(content, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("failed to sniff %s: %w", name, err)
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to rewind %s: %w", name, err)
+	}
+	return sniffContentType(buf[:n]), nil
+}
+
+func sniffContentType(data []byte) string {
+	for _, sig := range signatures {
+		if len(data) >= sig.offset+len(sig.prefix) && bytes.Equal(data[sig.offset:sig.offset+len(sig.prefix)], sig.prefix) {
+			return sig.contentType
+		}
+	}
+	trimmed := bytes.ToLower(bytes.TrimLeft(data, " \t\r\n"))
+	for _, prefix := range []string{"<!doctype html", "<html", "<head", "<body"} {
+		if bytes.HasPrefix(trimmed, []byte(prefix)) {
+			return "text/html; charset=utf-8"
+		}
+	}
+	if isText(data) {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+func isText(data []byte) bool {
+	// the sniff window may cut a multi-byte character in half
+	for i := 0; i < utf8.UTFMax-1 && len(data) > 0 && !utf8.Valid(data); i++ {
+		data = data[:len(data)-1]
+	}
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, b := range data {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' {
+			return false
+		}
+	}
+	return true
+}
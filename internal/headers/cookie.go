@@ -0,0 +1,141 @@
+package headers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SameSite int
+
+const (
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+type Cookie struct {
+	Name        string
+	Value       string
+	Path        string
+	Domain      string
+	Expires     time.Time
+	MaxAge      int // 0 means unset, negative means delete now (Max-Age=0)
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+// ParseCookies parses the value of a Cookie request header into name/value pairs.
+// Malformed pairs are skipped rather than failing the whole header.
+func ParseCookies(value string) []*Cookie {
+	cookies := []*Cookie{}
+	for _, part := range strings.Split(value, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, val, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		if !validCookieName(name) {
+			continue
+		}
+		val = strings.TrimSpace(val)
+		if len(val) > 1 && val[0] == '"' && val[len(val)-1] == '"' {
+			val = val[1 : len(val)-1]
+		}
+		if !validCookieValue(val) {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: val})
+	}
+	return cookies
+}
+
+func (c *Cookie) Valid() error {
+	if !validCookieName(c.Name) {
+		return fmt.Errorf("invalid cookie name: %q", c.Name)
+	}
+	if !validCookieValue(c.Value) {
+		return fmt.Errorf("invalid cookie value for %s: %q", c.Name, c.Value)
+	}
+	if strings.ContainsAny(c.Path, ";\r\n") {
+		return fmt.Errorf("invalid cookie path for %s: %q", c.Name, c.Path)
+	}
+	if strings.ContainsAny(c.Domain, "; \r\n") {
+		return fmt.Errorf("invalid cookie domain for %s: %q", c.Name, c.Domain)
+	}
+	if c.SameSite == SameSiteNone && !c.Secure {
+		return fmt.Errorf("cookie %s: SameSite=None requires Secure", c.Name)
+	}
+	if c.Partitioned && !c.Secure {
+		return fmt.Errorf("cookie %s: Partitioned requires Secure", c.Name)
+	}
+	return nil
+}
+
+// String serializes the cookie as the value of a Set-Cookie header.
+func (c *Cookie) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteByte('=')
+	if strings.ContainsAny(c.Value, " ,") {
+		b.WriteString(`"` + c.Value + `"`)
+	} else {
+		b.WriteString(c.Value)
+	}
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + FormatHTTPDate(c.Expires))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	switch c.SameSite {
+	case SameSiteLax:
+		b.WriteString("; SameSite=Lax")
+	case SameSiteStrict:
+		b.WriteString("; SameSite=Strict")
+	case SameSiteNone:
+		b.WriteString("; SameSite=None")
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+func validCookieName(name string) bool {
+	// cookie names are tokens, the same charset as header names (but case preserved)
+	return validateHeaderName(strings.ToLower(name))
+}
+
+func validCookieValue(value string) bool {
+	// cookie-octet from RFC 6265, relaxed to allow spaces and commas which browsers accept
+	for i := 0; i < len(value); i++ {
+		ch := value[i]
+		if ch < 0x20 || ch == 0x7f || ch == '"' || ch == ';' || ch == '\\' || ch >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
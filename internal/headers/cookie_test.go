@@ -0,0 +1,56 @@
+package headers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCookies(t *testing.T) {
+	// Test: multiple cookies
+	cookies := ParseCookies("session=abc123; theme=dark")
+	require.Len(t, cookies, 2)
+	assert.Equal(t, "session", cookies[0].Name)
+	assert.Equal(t, "abc123", cookies[0].Value)
+	assert.Equal(t, "theme", cookies[1].Name)
+	assert.Equal(t, "dark", cookies[1].Value)
+
+	// Test: quoted value and stray separators
+	cookies = ParseCookies(`; id="42";;`)
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "42", cookies[0].Value)
+
+	// Test: malformed pairs are skipped
+	cookies = ParseCookies("novalue; b@d=1; ok=yes")
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "ok", cookies[0].Name)
+}
+
+func TestCookieString(t *testing.T) {
+	// Test: all attributes
+	c := &Cookie{
+		Name:        "session",
+		Value:       "abc123",
+		Path:        "/",
+		Domain:      ".example.com",
+		Expires:     time.Date(2025, time.January, 2, 3, 4, 5, 0, time.UTC),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteStrict,
+		Partitioned: true,
+	}
+	require.NoError(t, c.Valid())
+	assert.Equal(t, "session=abc123; Path=/; Domain=example.com; Expires=Thu, 02 Jan 2025 03:04:05 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=Strict; Partitioned", c.String())
+
+	// Test: deleting a cookie
+	c = &Cookie{Name: "session", MaxAge: -1}
+	assert.Equal(t, "session=; Max-Age=0", c.String())
+
+	// Test: invalid cookies
+	assert.Error(t, (&Cookie{Name: "bad name", Value: "x"}).Valid())
+	assert.Error(t, (&Cookie{Name: "a", Value: "x;y"}).Valid())
+	assert.Error(t, (&Cookie{Name: "a", Value: "x", SameSite: SameSiteNone}).Valid())
+}
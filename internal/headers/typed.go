@@ -0,0 +1,306 @@
+package headers
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeFormat is the IMF-fixdate format that HTTP-date values must be sent in.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// obsolete HTTP-date formats that recipients still have to accept (RFC 9110 5.6.7)
+var obsoleteTimeFormats = []string{
+	"Monday, 02-Jan-06 15:04:05 GMT", // RFC 850
+	"Mon Jan _2 15:04:05 2006",       // asctime
+}
+
+type QualityValue struct {
+	Value  string
+	Params map[string]string
+	Q      float64
+}
+
+func (h Headers) ContentType() (string, map[string]string, error) {
+	value, ok := h.Get("Content-Type")
+	if !ok {
+		return "", nil, fmt.Errorf("missing content-type")
+	}
+	return ParseMediaType(value)
+}
+
+// ContentLength returns the parsed Content-Length and whether the header was present.
+func (h Headers) ContentLength() (int64, bool, error) {
+	value, ok := h.Get("Content-Length")
+	if !ok {
+		return 0, false, nil
+	}
+	// repeated Content-Length lines are only allowed if they all agree
+	var length int64 = -1
+	for _, part := range strings.Split(value, ",") {
+		n, err := parseContentLength(strings.TrimSpace(part))
+		if err != nil {
+			return 0, true, err
+		}
+		if length != -1 && n != length {
+			return 0, true, fmt.Errorf("conflicting content-length values: %s", value)
+		}
+		length = n
+	}
+	return length, true, nil
+}
+
+func (h Headers) GetTime(name string) (time.Time, bool, error) {
+	value, ok := h.Get(name)
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	t, err := ParseHTTPDate(value)
+	return t, true, err
+}
+
+func (h Headers) SetTime(name string, t time.Time) {
+	h.Set(name, FormatHTTPDate(t))
+}
+
+// Values returns the comma-separated elements of a list-based header.
+func (h Headers) Values(name string) []string {
+	value, ok := h.Get(name)
+	if !ok {
+		return []string{}
+	}
+	return ParseList(value)
+}
+
+func (h Headers) QualityValues(name string) []QualityValue {
+	value, ok := h.Get(name)
+	if !ok {
+		return []QualityValue{}
+	}
+	return ParseQualityList(value)
+}
+
+// CacheControl returns the Cache-Control directives keyed by lowercase name,
+// with quoted arguments unquoted. Directives without an argument map to "".
+func (h Headers) CacheControl() map[string]string {
+	directives := map[string]string{}
+	for _, element := range h.Values("Cache-Control") {
+		name, value, _ := strings.Cut(element, "=")
+		directives[strings.ToLower(strings.TrimSpace(name))] = Unquote(strings.TrimSpace(value))
+	}
+	return directives
+}
+
+func ParseMediaType(value string) (string, map[string]string, error) {
+	mediaType, rest, _ := strings.Cut(value, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	typ, subtype, ok := strings.Cut(mediaType, "/")
+	if !ok || !validateHeaderName(typ) || !validateHeaderName(subtype) {
+		return "", nil, fmt.Errorf("invalid media type: %s", value)
+	}
+	params, err := parseParams(rest)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid media type %s: %w", value, err)
+	}
+	return mediaType, params, nil
+}
+
+func FormatMediaType(mediaType string, params map[string]string) string {
+	var b strings.Builder
+	b.WriteString(mediaType)
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteString("; " + k + "=" + Quote(params[k]))
+	}
+	return b.String()
+}
+
+func ParseHTTPDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	t, err := time.Parse(TimeFormat, value)
+	if err == nil {
+		return t, nil
+	}
+	for _, layout := range obsoleteTimeFormats {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid http-date: %s", value)
+}
+
+func FormatHTTPDate(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+// ParseList splits a comma-separated header value into its elements. Commas
+// inside quoted-strings don't split, and empty elements are dropped.
+func ParseList(value string) []string {
+	elements := []string{}
+	inQuotes := false
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			if inQuotes {
+				i++ // skip the escaped character
+			}
+		case '"':
+			inQuotes = !inQuotes
+		case ',':
+			if inQuotes {
+				continue
+			}
+			if element := strings.TrimSpace(value[start:i]); element != "" {
+				elements = append(elements, element)
+			}
+			start = i + 1
+		}
+	}
+	if start < len(value) {
+		if element := strings.TrimSpace(value[start:]); element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
+
+// ParseQualityList parses a weighted list like Accept or Accept-Encoding and
+// returns the elements ordered by descending q-value. Elements with an
+// invalid q-value are dropped; ties keep their original order.
+func ParseQualityList(value string) []QualityValue {
+	values := []QualityValue{}
+	for _, element := range ParseList(value) {
+		v, rest, _ := strings.Cut(element, ";")
+		params, err := parseParams(rest)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			q, err = parseQValue(qs)
+			if err != nil {
+				continue
+			}
+			delete(params, "q")
+		}
+		values = append(values, QualityValue{
+			Value:  strings.TrimSpace(v),
+			Params: params,
+			Q:      q,
+		})
+	}
+	sort.SliceStable(values, func(i, j int) bool {
+		return values[i].Q > values[j].Q
+	})
+	return values
+}
+
+func Quote(s string) string {
+	if s != "" && validateHeaderName(strings.ToLower(s)) {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func Unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	s = s[1 : len(s)-1]
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func parseContentLength(value string) (int64, error) {
+	if value == "" {
+		return 0, fmt.Errorf("empty content-length")
+	}
+	var n int64
+	for i := 0; i < len(value); i++ {
+		ch := value[i]
+		// strconv would accept a leading sign, which Content-Length doesn't allow
+		if ch < '0' || ch > '9' {
+			return 0, fmt.Errorf("invalid content-length: %s", value)
+		}
+		if n > (math.MaxInt64-int64(ch-'0'))/10 {
+			return 0, fmt.Errorf("content-length overflows: %s", value)
+		}
+		n = n*10 + int64(ch-'0')
+	}
+	return n, nil
+}
+
+func parseParams(s string) (map[string]string, error) {
+	params := map[string]string{}
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		var param string
+		param, s = cutParam(s)
+		param = strings.TrimSpace(param)
+		if param == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(param, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || !validateHeaderName(name) {
+			return nil, fmt.Errorf("invalid parameter: %s", param)
+		}
+		params[name] = Unquote(strings.TrimSpace(value))
+	}
+	return params, nil
+}
+
+// cutParam splits off the first ';'-terminated parameter, ignoring
+// semicolons inside quoted-strings.
+func cutParam(s string) (string, string) {
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if inQuotes {
+				i++
+			}
+		case '"':
+			inQuotes = !inQuotes
+		case ';':
+			if !inQuotes {
+				return s[:i], s[i+1:]
+			}
+		}
+	}
+	return s, ""
+}
+
+func parseQValue(s string) (float64, error) {
+	// qvalue = ( "0" [ "." 0*3DIGIT ] ) / ( "1" [ "." 0*3("0") ] )
+	if len(s) == 0 || len(s) > 5 || (s[0] != '0' && s[0] != '1') || (len(s) > 1 && s[1] != '.') {
+		return 0, fmt.Errorf("invalid q-value: %s", s)
+	}
+	q, err := strconv.ParseFloat(s, 64)
+	if err != nil || q > 1 {
+		return 0, fmt.Errorf("invalid q-value: %s", s)
+	}
+	return q, nil
+}
@@ -0,0 +1,99 @@
+package headers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentLength(t *testing.T) {
+	// Test: valid length
+	h := NewHeaders()
+	h.Set("Content-Length", "42")
+	n, ok, err := h.ContentLength()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(42), n)
+
+	// Test: missing header
+	h = NewHeaders()
+	_, ok, err = h.ContentLength()
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Test: repeated matching values
+	h.Set("Content-Length", "7,7")
+	n, _, err = h.ContentLength()
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), n)
+
+	// Test: invalid values
+	for _, v := range []string{"-1", "+5", "1.0", "", "7, 8", "99999999999999999999"} {
+		h.Set("Content-Length", v)
+		_, _, err = h.ContentLength()
+		assert.Error(t, err, v)
+	}
+}
+
+func TestParseMediaType(t *testing.T) {
+	// Test: type with parameters
+	mt, params, err := ParseMediaType(`Text/HTML; Charset="utf-8"; q=1`)
+	require.NoError(t, err)
+	assert.Equal(t, "text/html", mt)
+	assert.Equal(t, map[string]string{"charset": "utf-8", "q": "1"}, params)
+
+	// Test: quoted parameter containing a semicolon
+	_, params, err = ParseMediaType(`multipart/form-data; boundary="a;b"`)
+	require.NoError(t, err)
+	assert.Equal(t, "a;b", params["boundary"])
+
+	// Test: invalid media types
+	_, _, err = ParseMediaType("texthtml")
+	assert.Error(t, err)
+	_, _, err = ParseMediaType("text/html; charset")
+	assert.Error(t, err)
+
+	// Test: formatting round trips
+	assert.Equal(t, `text/plain; charset=utf-8; name="a b"`, FormatMediaType("text/plain", map[string]string{"name": "a b", "charset": "utf-8"}))
+}
+
+func TestHTTPDate(t *testing.T) {
+	want := time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC)
+	for _, v := range []string{
+		"Sun, 06 Nov 1994 08:49:37 GMT",
+		"Sunday, 06-Nov-94 08:49:37 GMT",
+		"Sun Nov  6 08:49:37 1994",
+	} {
+		got, err := ParseHTTPDate(v)
+		require.NoError(t, err, v)
+		assert.True(t, want.Equal(got), v)
+	}
+	_, err := ParseHTTPDate("yesterday")
+	assert.Error(t, err)
+	assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", FormatHTTPDate(want.In(time.FixedZone("X", 3600))))
+}
+
+func TestParseList(t *testing.T) {
+	assert.Equal(t, []string{"gzip", "deflate"}, ParseList("gzip, deflate"))
+	assert.Equal(t, []string{"a", `b="x, y"`, "c"}, ParseList(` a ,, b="x, y",c,`))
+	assert.Equal(t, []string{`"esc\"aped, still"`}, ParseList(`"esc\"aped, still"`))
+	assert.Empty(t, ParseList(" , "))
+
+	h := NewHeaders()
+	h.Set("Cache-Control", `max-age=60, no-cache="set-cookie, foo", Public`)
+	assert.Equal(t, map[string]string{"max-age": "60", "no-cache": "set-cookie, foo", "public": ""}, h.CacheControl())
+}
+
+func TestParseQualityList(t *testing.T) {
+	values := ParseQualityList("text/html;q=0.5, application/json, text/*;q=0.5;level=1, */*;q=0, bad;q=2")
+	require.Len(t, values, 4)
+	assert.Equal(t, "application/json", values[0].Value)
+	assert.Equal(t, 1.0, values[0].Q)
+	assert.Equal(t, "text/html", values[1].Value)
+	assert.Equal(t, "text/*", values[2].Value)
+	assert.Equal(t, map[string]string{"level": "1"}, values[2].Params)
+	assert.Equal(t, "*/*", values[3].Value)
+	assert.Equal(t, 0.0, values[3].Q)
+}
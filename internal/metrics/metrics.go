@@ -0,0 +1,326 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+)
+
+// ContentType is the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are latency buckets in seconds, the same as Prometheus'
+// client libraries use.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var validName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// Registry holds metrics in the order they were created.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+	names   map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+type metricType string
+
+const (
+	typeCounter   metricType = "counter"
+	typeGauge     metricType = "gauge"
+	typeHistogram metricType = "histogram"
+)
+
+type metric struct {
+	name       string
+	help       string
+	typ        metricType
+	labelNames []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is one combination of label values.
+type series struct {
+	labelValues []string
+	value       float64
+	// histograms only: per-bucket counts (not cumulative), then sum and count
+	bucketCounts []uint64
+	count        uint64
+}
+
+// Counter only goes up.
+type Counter struct{ m *metric }
+
+// Gauge goes up and down.
+type Gauge struct{ m *metric }
+
+// Histogram counts observations into buckets.
+type Histogram struct{ m *metric }
+
+// Counter creates a counter. Names and labels have to be valid Prometheus
+// names and a name can only be registered once, anything else panics since
+// it's a programming error.
+func (r *Registry) Counter(name, help string, labelNames ...string) *Counter {
+	return &Counter{r.register(name, help, typeCounter, nil, labelNames)}
+}
+
+func (r *Registry) Gauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{r.register(name, help, typeGauge, nil, labelNames)}
+}
+
+// Histogram creates a histogram with the given upper bucket bounds, nil
+// meaning DefaultBuckets.
+func (r *Registry) Histogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = slices.Clone(buckets)
+	sort.Float64s(buckets)
+	return &Histogram{r.register(name, help, typeHistogram, buckets, labelNames)}
+}
+
+func (r *Registry) register(name, help string, typ metricType, buckets []float64, labelNames []string) *metric {
+	if !validName.MatchString(name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", name))
+	}
+	for _, l := range labelNames {
+		if !validName.MatchString(l) || strings.Contains(l, ":") || strings.HasPrefix(l, "__") || (typ == typeHistogram && l == "le") {
+			panic(fmt.Sprintf("metrics: invalid label name %q for %s", l, name))
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("metrics: %s is already registered", name))
+	}
+	r.names[name] = true
+	m := &metric{
+		name:       name,
+		help:       help,
+		typ:        typ,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     map[string]*series{},
+	}
+	r.metrics = append(r.metrics, m)
+	if len(labelNames) == 0 {
+		// the only series exists from the start, reading 0
+		m.with(nil, func(*series) {})
+	}
+	return m
+}
+
+// with runs f on the series for labelValues with the metric locked,
+// creating the series first.
+func (m *metric) with(labelValues []string, f func(s *series)) {
+	m.do(labelValues, true, f)
+}
+
+// read runs f on the series for labelValues, or on an empty one if there's
+// none yet, without adding it to the output.
+func (m *metric) read(labelValues []string, f func(s *series)) {
+	m.do(labelValues, false, f)
+}
+
+func (m *metric) do(labelValues []string, create bool, f func(s *series)) {
+	if len(labelValues) != len(m.labelNames) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", m.name, len(m.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.series[key]
+	if !ok && !create {
+		s = &series{}
+	} else if !ok {
+		s = &series{labelValues: slices.Clone(labelValues)}
+		if m.typ == typeHistogram {
+			s.bucketCounts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	f(s)
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter, negative values are ignored.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	c.m.with(labelValues, func(s *series) { s.value += v })
+}
+
+// Value returns the current value, for tests and callers that need to read
+// a counter back.
+func (c *Counter) Value(labelValues ...string) float64 {
+	var v float64
+	c.m.read(labelValues, func(s *series) { v = s.value })
+	return v
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.m.with(labelValues, func(s *series) { s.value = v })
+}
+
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.m.with(labelValues, func(s *series) { s.value += v })
+}
+
+func (g *Gauge) Inc(labelValues ...string) { g.Add(1, labelValues...) }
+
+func (g *Gauge) Dec(labelValues ...string) { g.Add(-1, labelValues...) }
+
+func (g *Gauge) Value(labelValues ...string) float64 {
+	var v float64
+	g.m.read(labelValues, func(s *series) { v = s.value })
+	return v
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.m.with(labelValues, func(s *series) {
+		if i := sort.SearchFloat64s(h.m.buckets, v); i < len(h.m.buckets) {
+			s.bucketCounts[i]++
+		}
+		s.value += v
+		s.count++
+	})
+}
+
+// Count returns how many values were observed.
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	var n uint64
+	h.m.read(labelValues, func(s *series) { n = s.count })
+	return n
+}
+
+// WriteText writes every metric in the Prometheus text format, series
+// sorted by their label values.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.writeText(bw)
+	}
+	return bw.Flush()
+}
+
+func (m *metric) writeText(w *bufio.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.typ)
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := m.series[k]
+		if m.typ != typeHistogram {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, labels(m.labelNames, s.labelValues, ""), formatFloat(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += s.bucketCounts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, labels(m.labelNames, s.labelValues, formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, labels(m.labelNames, s.labelValues, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, labels(m.labelNames, s.labelValues, ""), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, labels(m.labelNames, s.labelValues, ""), s.count)
+	}
+}
+
+// labels renders {name="value",...}, with le last for histogram buckets.
+func labels(names, values []string, le string) string {
+	if len(names) == 0 && le == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(values[i]))
+		b.WriteByte('"')
+	}
+	if le != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(`le="`)
+		b.WriteString(le)
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string       { return helpEscaper.Replace(s) }
+func escapeLabelValue(s string) string { return labelEscaper.Replace(s) }
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Handler serves the registry's metrics, for mounting at e.g. /metrics.
+func (r *Registry) Handler() func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method != "GET" && req.RequestLine.Method != "HEAD" {
+			response.WriteError(w, req, response.StatusCodeMethodNotAllowed, "Metrics can only be read with GET.")
+			return
+		}
+		var buf bytes.Buffer
+		r.WriteText(&buf)
+		h := response.GetDefaultHeaders(buf.Len())
+		h.Set("Content-Type", ContentType)
+		h.Set("Cache-Control", "no-store")
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(h)
+		if req.RequestLine.Method == "HEAD" {
+			w.WriteBody(nil)
+			return
+		}
+		w.WriteBody(buf.Bytes())
+	}
+}
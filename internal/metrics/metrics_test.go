@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteText(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.Counter("requests_total", "Requests handled.", "method", "status")
+	active := reg.Gauge("active", "Open connections.")
+	latency := reg.Histogram("latency_seconds", "Request latency.", []float64{1, 0.1}, "method")
+
+	requests.Inc("GET", "200")
+	requests.Add(2, "POST", "201")
+	requests.Add(-1, "POST", "201")
+	active.Inc()
+	active.Inc()
+	active.Dec()
+	latency.Observe(0.05, "GET")
+	latency.Observe(0.5, "GET")
+	latency.Observe(5, "GET")
+
+	// Test: values can be read back
+	assert.Equal(t, float64(2), requests.Value("POST", "201"))
+	assert.Equal(t, float64(0), requests.Value("PUT", "204"))
+	assert.Equal(t, float64(1), active.Value())
+	assert.Equal(t, uint64(3), latency.Count("GET"))
+
+	// Test: metrics come out in the Prometheus text format, buckets cumulative
+	var buf bytes.Buffer
+	require.NoError(t, reg.WriteText(&buf))
+	assert.Equal(t, `# HELP requests_total Requests handled.
+# TYPE requests_total counter
+requests_total{method="GET",status="200"} 1
+requests_total{method="POST",status="201"} 2
+# HELP active Open connections.
+# TYPE active gauge
+active 1
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{method="GET",le="0.1"} 1
+latency_seconds_bucket{method="GET",le="1"} 2
+latency_seconds_bucket{method="GET",le="+Inf"} 3
+latency_seconds_sum{method="GET"} 5.55
+latency_seconds_count{method="GET"} 3
+`, buf.String())
+}
+
+func TestEscaping(t *testing.T) {
+	reg := NewRegistry()
+	reg.Counter("odd_total", "Help with a \\ and a\nnewline.", "path").Inc("a \"quoted\"\\path\n")
+
+	// Test: help text and label values are escaped
+	var buf bytes.Buffer
+	require.NoError(t, reg.WriteText(&buf))
+	assert.Contains(t, buf.String(), `# HELP odd_total Help with a \\ and a\nnewline.`)
+	assert.Contains(t, buf.String(), `odd_total{path="a \"quoted\"\\path\n"} 1`)
+}
+
+func TestRegisterPanics(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.Counter("dup_total", "", "a")
+
+	// Test: invalid or duplicate names and wrong label counts are programming errors
+	assert.Panics(t, func() { reg.Counter("dup_total", "") })
+	assert.Panics(t, func() { reg.Gauge("1bad", "") })
+	assert.Panics(t, func() { reg.Gauge("ok", "", "__reserved") })
+	assert.Panics(t, func() { reg.Histogram("h", "", nil, "le") })
+	assert.Panics(t, func() { c.Inc() })
+}
+
+func TestHandler(t *testing.T) {
+	reg := NewRegistry()
+	reg.Counter("hits_total", "Hits.").Inc()
+	handler := reg.Handler()
+
+	serve := func(method string) *http.Response {
+		req, err := request.RequestFromReader(strings.NewReader(method + " /metrics HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		w := response.NewWriter(&buf)
+		handler(w, req)
+		require.NoError(t, w.Close())
+		resp, err := http.ReadResponse(bufio.NewReader(&buf), &http.Request{Method: method})
+		require.NoError(t, err)
+		return resp
+	}
+
+	// Test: GET serves the text format
+	resp := serve("GET")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, ContentType, resp.Header.Get("Content-Type"))
+	assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+	var body bytes.Buffer
+	body.ReadFrom(resp.Body)
+	assert.Contains(t, body.String(), "hits_total 1\n")
+
+	// Test: other methods aren't allowed
+	assert.Equal(t, 405, serve("POST").StatusCode)
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+)
+
+// Backend is one upstream server the proxy balances across.
+type Backend struct {
+	URL *url.URL
+
+	active    atomic.Int64
+	unhealthy atomic.Bool // set by active health checks
+
+	mu           sync.Mutex
+	failures     int
+	ejectedUntil time.Time
+}
+
+// ActiveRequests counts the requests currently forwarded to the backend.
+func (b *Backend) ActiveRequests() int64 {
+	return b.active.Load()
+}
+
+// Healthy reports the result of the last active health check.
+func (b *Backend) Healthy() bool {
+	return !b.unhealthy.Load()
+}
+
+// Ejected reports whether the backend is sitting out after failing too often.
+func (b *Backend) Ejected() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Now().Before(b.ejectedUntil)
+}
+
+func (b *Backend) available() bool {
+	return b.Healthy() && !b.Ejected()
+}
+
+// recordResult tracks consecutive failures, ejecting the backend for
+// ejectFor once there are maxFails of them. maxFails 0 disables ejection.
+func (b *Backend) recordResult(failed bool, maxFails int, ejectFor time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.failures = 0
+		return false
+	}
+	b.failures++
+	if maxFails <= 0 || b.failures < maxFails {
+		return false
+	}
+	b.failures = 0
+	b.ejectedUntil = time.Now().Add(ejectFor)
+	return true
+}
+
+// Balancer picks the backend for a request. backends holds the ones that are
+// currently available and is never empty.
+type Balancer interface {
+	Pick(req *request.Request, backends []*Backend) *Backend
+}
+
+// RoundRobin hands requests to each backend in turn.
+type RoundRobin struct {
+	next atomic.Uint64
+}
+
+func (r *RoundRobin) Pick(req *request.Request, backends []*Backend) *Backend {
+	n := r.next.Add(1) - 1
+	return backends[n%uint64(len(backends))]
+}
+
+// LeastConnections picks the backend with the fewest requests in flight,
+// the first one listed on ties.
+type LeastConnections struct{}
+
+func (LeastConnections) Pick(req *request.Request, backends []*Backend) *Backend {
+	best := backends[0]
+	for _, b := range backends[1:] {
+		if b.ActiveRequests() < best.ActiveRequests() {
+			best = b
+		}
+	}
+	return best
+}
+
+// ConsistentHash sends requests with the same key to the same backend, and
+// only moves the keys of a backend that goes away. It uses rendezvous
+// hashing: every backend scores the key and the highest score wins.
+type ConsistentHash struct {
+	// the header to hash, empty hashes the request path
+	Header string
+}
+
+func (c ConsistentHash) Pick(req *request.Request, backends []*Backend) *Backend {
+	key, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	if c.Header != "" {
+		key, _ = req.Headers.Get(c.Header)
+	}
+	var best *Backend
+	var bestScore uint64
+	for _, b := range backends {
+		h := fnv.New64a()
+		h.Write([]byte(b.URL.String()))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		if score := mix(h.Sum64()); best == nil || score > bestScore {
+			best, bestScore = b, score
+		}
+	}
+	return best
+}
+
+// mix is the murmur3 finalizer. FNV alone barely changes which of two
+// similar inputs scores higher, which would send every key to one backend.
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
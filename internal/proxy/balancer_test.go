@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testBackends(n int) []*Backend {
+	backends := make([]*Backend, n)
+	for i := range backends {
+		backends[i] = &Backend{URL: &url.URL{Scheme: "http", Host: fmt.Sprintf("10.0.0.%d:80", i+1)}}
+	}
+	return backends
+}
+
+func testRequest(target string, h map[string]string) *request.Request {
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: target, HttpVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+	}
+	for k, v := range h {
+		req.Headers.Set(k, v)
+	}
+	return req
+}
+
+func TestBalancers(t *testing.T) {
+	backends := testBackends(3)
+	req := testRequest("/", nil)
+
+	// Test: round robin cycles through the backends
+	rr := &RoundRobin{}
+	for i := 0; i < 6; i++ {
+		assert.Same(t, backends[i%3], rr.Pick(req, backends))
+	}
+
+	// Test: least connections prefers the idlest backend
+	backends[0].active.Store(2)
+	backends[1].active.Store(1)
+	backends[2].active.Store(3)
+	assert.Same(t, backends[1], LeastConnections{}.Pick(req, backends))
+	backends[1].active.Store(2)
+	assert.Same(t, backends[0], LeastConnections{}.Pick(req, backends))
+
+	// Test: consistent hashing keeps a key on one backend
+	byUser := ConsistentHash{Header: "X-User"}
+	first := byUser.Pick(testRequest("/a", map[string]string{"X-User": "alice"}), backends)
+	for i := 0; i < 5; i++ {
+		assert.Same(t, first, byUser.Pick(testRequest(fmt.Sprintf("/%d", i), map[string]string{"X-User": "alice"}), backends))
+	}
+	// Test: the path is hashed without the query
+	byPath := ConsistentHash{}
+	assert.Same(t, byPath.Pick(testRequest("/file?v=1", nil), backends), byPath.Pick(testRequest("/file?v=2", nil), backends))
+
+	// Test: removing a backend only moves the keys it had
+	moved := 0
+	for i := 0; i < 200; i++ {
+		r := testRequest(fmt.Sprintf("/key/%d", i), nil)
+		before := byPath.Pick(r, backends)
+		after := byPath.Pick(r, backends[:2])
+		if before != backends[2] {
+			assert.Same(t, before, after)
+		} else {
+			moved++
+		}
+	}
+	assert.Greater(t, moved, 0)
+}
+
+func TestEjection(t *testing.T) {
+	b := testBackends(1)[0]
+
+	// Test: consecutive failures eject, a success resets the count
+	assert.False(t, b.recordResult(true, 2, time.Minute))
+	assert.False(t, b.recordResult(false, 2, time.Minute))
+	assert.False(t, b.recordResult(true, 2, time.Minute))
+	assert.False(t, b.Ejected())
+	assert.True(t, b.recordResult(true, 2, time.Minute))
+	assert.True(t, b.Ejected())
+
+	// Test: ejection expires
+	b = testBackends(1)[0]
+	b.recordResult(true, 1, 10*time.Millisecond)
+	assert.True(t, b.Ejected())
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, b.Ejected())
+}
+
+func namedUpstream(t *testing.T, name string, hits *atomic.Int32) *httptest.Server {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path == "/health" && name == "sick" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		io.WriteString(w, name)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func proxiedBody(t *testing.T, p *ReverseProxy, method, target string) (int, string) {
+	t.Helper()
+	resp := proxyRequest(t, p, method+" "+target+" HTTP/1.1\r\nHost: localhost\r\nAccept: text/plain\r\n\r\n")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestLoadBalancing(t *testing.T) {
+	var hitsA, hitsB atomic.Int32
+	a := namedUpstream(t, "a", &hitsA)
+	b := namedUpstream(t, "b", &hitsB)
+	p, err := New(Config{Upstreams: []string{a.URL, b.URL}})
+	require.NoError(t, err)
+	defer p.Close()
+
+	// Test: requests alternate between the upstreams
+	bodies := []string{}
+	for i := 0; i < 4; i++ {
+		_, body := proxiedBody(t, p, "GET", "/")
+		bodies = append(bodies, body)
+	}
+	assert.Equal(t, []string{"a", "b", "a", "b"}, bodies)
+}
+
+func TestRetriesAndEjection(t *testing.T) {
+	var hits atomic.Int32
+	alive := namedUpstream(t, "alive", &hits)
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	p, err := New(Config{
+		Upstreams: []string{dead.URL, alive.URL},
+		Balancer:  &RoundRobin{},
+		Retries:   1,
+		MaxFails:  2,
+	})
+	require.NoError(t, err)
+	defer p.Close()
+
+	// Test: an idempotent request is retried on the next upstream
+	status, body := proxiedBody(t, p, "GET", "/")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "alive", body)
+
+	// Test: a non-idempotent request isn't retried
+	p.balancer = &RoundRobin{}
+	status, _ = proxiedBody(t, p, "POST", "/")
+	assert.Equal(t, 502, status)
+
+	// Test: after MaxFails failures the dead upstream is skipped entirely
+	assert.True(t, p.Backends()[0].Ejected())
+	for i := 0; i < 3; i++ {
+		status, body = proxiedBody(t, p, "POST", "/")
+		assert.Equal(t, 200, status)
+		assert.Equal(t, "alive", body)
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	var sickHits, wellHits atomic.Int32
+	sick := namedUpstream(t, "sick", &sickHits)
+	well := namedUpstream(t, "well", &wellHits)
+
+	p, err := New(Config{
+		Upstreams:   []string{sick.URL, well.URL},
+		HealthCheck: HealthCheck{Path: "/health", Interval: 10 * time.Millisecond},
+	})
+	require.NoError(t, err)
+	defer p.Close()
+
+	// Test: the failing upstream is taken out of rotation
+	require.Eventually(t, func() bool {
+		return !p.Backends()[0].Healthy() && p.Backends()[1].Healthy()
+	}, time.Second, 5*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		_, body := proxiedBody(t, p, "GET", "/")
+		assert.Equal(t, "well", body)
+	}
+
+	// Test: with nothing available the proxy answers 503
+	p.Backends()[1].recordResult(true, 1, time.Minute)
+	status, body := proxiedBody(t, p, "GET", "/")
+	assert.Equal(t, 503, status)
+	assert.True(t, strings.Contains(body, "No upstream server is available."))
+}
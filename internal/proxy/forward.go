@@ -0,0 +1,269 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/client"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+)
+
+type ForwardConfig struct {
+	// destination ports clients may reach, empty allows DefaultAllowedPorts
+	AllowedPorts []int
+	// destination hosts clients may reach, "*.example.com" matches any
+	// subdomain and "*" every host. Empty allows none.
+	AllowedHosts []string
+	// lets destinations resolve to loopback, link-local or unspecified
+	// addresses. They're refused otherwise, so no allowed name can lead to
+	// the proxy's own host or a cloud metadata endpoint.
+	AllowLocalAddrs bool
+	// how long connecting to a destination may take, 0 means DefaultDialTimeout
+	DialTimeout time.Duration
+	// how long a forwarded absolute-form request may take, 0 means no limit.
+	// Tunnels aren't limited.
+	Timeout time.Duration
+	// used for absolute-form requests, nil uses a client with default settings
+	Client *client.Client
+}
+
+var DefaultAllowedPorts = []int{80, 443}
+
+const DefaultDialTimeout = 10 * time.Second
+
+// ForwardProxy is a forward proxy: clients send it absolute-form requests
+// ("GET http://example.com/ HTTP/1.1") to have them forwarded, or CONNECT
+// requests to open a TCP tunnel, e.g. for HTTPS.
+type ForwardProxy struct {
+	config ForwardConfig
+	client *client.Client
+}
+
+func NewForward(cfg ForwardConfig) *ForwardProxy {
+	if len(cfg.AllowedPorts) == 0 {
+		cfg.AllowedPorts = DefaultAllowedPorts
+	}
+	if cfg.DialTimeout == 0 {
+		cfg.DialTimeout = DefaultDialTimeout
+	}
+	p := &ForwardProxy{config: cfg, client: cfg.Client}
+	if p.client == nil {
+		p.client = &client.Client{DialTimeout: cfg.DialTimeout, Control: p.checkDialAddr}
+	}
+	return p
+}
+
+var errLocalAddr = errors.New("destination resolves to a local address")
+
+// checkDialAddr fits net.Dialer.Control. It checks the address actually
+// dialed, since a name on the allowlist can resolve to anything.
+func (p *ForwardProxy) checkDialAddr(network, address string, _ syscall.RawConn) error {
+	if p.config.AllowLocalAddrs {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: %s", errLocalAddr, host)
+	}
+	return nil
+}
+
+func (p *ForwardProxy) Handle(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method == "CONNECT" {
+		p.tunnel(w, req)
+		return
+	}
+	p.forward(w, req)
+}
+
+// Allowed reports whether the allowlists let clients reach host and port.
+func (p *ForwardProxy) Allowed(host string, port int) bool {
+	portAllowed := false
+	for _, allowed := range p.config.AllowedPorts {
+		if port == allowed {
+			portAllowed = true
+			break
+		}
+	}
+	if !portAllowed {
+		return false
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range p.config.AllowedHosts {
+		pattern = strings.ToLower(pattern)
+		if pattern == "*" {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ForwardProxy) tunnel(w *response.Writer, req *request.Request) {
+	// CONNECT uses authority-form, the target is just "host:port"
+	host, portText, err := net.SplitHostPort(req.RequestLine.RequestTarget)
+	port, perr := strconv.Atoi(portText)
+	if err != nil || perr != nil || host == "" {
+		response.WriteError(w, req, response.StatusCodeBadRequest, "CONNECT needs a host:port target.")
+		return
+	}
+	if !p.Allowed(host, port) {
+		response.WriteError(w, req, response.StatusCodeForbidden, "Tunnels to that destination aren't allowed.")
+		return
+	}
+	dialer := &net.Dialer{Timeout: p.config.DialTimeout, Control: p.checkDialAddr}
+	upstream, err := dialer.Dial("tcp", net.JoinHostPort(host, portText))
+	if err != nil {
+		log.Printf("proxy: error connecting to %s: %v", req.RequestLine.RequestTarget, err)
+		if errors.Is(err, errLocalAddr) {
+			response.WriteError(w, req, response.StatusCodeForbidden, "Tunnels to that destination aren't allowed.")
+			return
+		}
+		if isTimeout(err) {
+			response.WriteError(w, req, response.StatusCodeGatewayTimeout, "The destination didn't respond in time.")
+			return
+		}
+		response.WriteError(w, req, response.StatusCodeBadGateway, "The destination couldn't be reached.")
+		return
+	}
+
+	// a 2xx to CONNECT has no body and no framing headers (RFC 9110 9.3.6)
+	err = w.WriteStatusLine(response.StatusCodeSuccess)
+	if err == nil {
+		err = w.WriteHeaders(headers.NewHeaders())
+	}
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		log.Printf("proxy: error taking over connection for %s: %v", req.RequestLine.RequestTarget, err)
+		upstream.Close()
+		return
+	}
+	if len(buffered) > 0 {
+		if _, err := upstream.Write(buffered); err != nil {
+			conn.Close()
+			upstream.Close()
+			return
+		}
+	}
+	splice(conn, upstream)
+}
+
+// splice copies bytes both ways until both sides are done, then closes them.
+func splice(a, b net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	copyHalf := func(dst, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		// pass the end of the stream on, but keep reading the other direction
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	wg.Wait()
+	a.Close()
+	b.Close()
+}
+
+// forward handles an absolute-form request.
+func (p *ForwardProxy) forward(w *response.Writer, req *request.Request) {
+	u, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		response.WriteError(w, req, response.StatusCodeBadRequest, "A forward proxy needs absolute-form request targets.")
+		return
+	}
+	if u.Scheme != "http" {
+		response.WriteError(w, req, response.StatusCodeBadRequest, "Only http can be forwarded, use CONNECT for https.")
+		return
+	}
+	port := 80
+	if u.Port() != "" {
+		port, err = strconv.Atoi(u.Port())
+		if err != nil {
+			response.WriteError(w, req, response.StatusCodeBadRequest, "Invalid port in the request target.")
+			return
+		}
+	}
+	if !p.Allowed(u.Hostname(), port) {
+		response.WriteError(w, req, response.StatusCodeForbidden, "Requests to that destination aren't allowed.")
+		return
+	}
+
+	h := headers.NewHeaders()
+	for k, v := range req.Headers {
+		h[k] = v
+	}
+	removeHopByHop(h)
+	h.Delete("Content-Length")
+	// the target's authority replaces any Host the client sent (RFC 9112 3.2.2)
+	h.Set("Host", u.Host)
+	h.Add("Via", "1.1 httpfromtcp")
+	outReq := &request.Request{
+		RequestLine: request.RequestLine{
+			Method:        req.RequestLine.Method,
+			RequestTarget: u.RequestURI(),
+			HttpVersion:   "1.1",
+		},
+		Headers: h,
+		Body:    req.Body,
+	}
+
+	ctx := context.Background()
+	if p.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.config.Timeout)
+		defer cancel()
+	}
+	target := &url.URL{Scheme: u.Scheme, Host: u.Host}
+	resp, err := p.client.Do(ctx, target, outReq)
+	if err != nil {
+		log.Printf("proxy: error forwarding to %s: %v", u, err)
+		if errors.Is(err, errLocalAddr) {
+			response.WriteError(w, req, response.StatusCodeForbidden, "Requests to that destination aren't allowed.")
+			return
+		}
+		if isTimeout(err) {
+			response.WriteError(w, req, response.StatusCodeGatewayTimeout, "The destination didn't respond in time.")
+			return
+		}
+		response.WriteError(w, req, response.StatusCodeBadGateway, "The destination couldn't be reached.")
+		return
+	}
+	defer resp.Body.Close()
+	if err := relayResponse(w, req, resp); err != nil {
+		log.Printf("proxy: error relaying response from %s: %v", u, err)
+	}
+}
@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startProxyListener serves handler on a local listener, one request per
+// connection, and returns its address.
+func startProxyListener(t *testing.T, handler func(*response.Writer, *request.Request)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				req, err := request.RequestFromReader(conn)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				req.RemoteAddr = conn.RemoteAddr().String()
+				w := response.NewWriter(conn)
+				handler(w, req)
+				if _, _, err := w.Hijack(); err == nil {
+					// still ours unless the handler took it
+					conn.Close()
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func startEchoServer(t *testing.T) (string, int) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String(), listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestAllowed(t *testing.T) {
+	p := NewForward(ForwardConfig{AllowedHosts: []string{"example.com", "*.example.org"}})
+
+	// Test: hosts and ports on the allowlists
+	assert.True(t, p.Allowed("example.com", 443))
+	assert.True(t, p.Allowed("EXAMPLE.com.", 80))
+	assert.True(t, p.Allowed("api.example.org", 443))
+	assert.True(t, p.Allowed("a.b.example.org", 443))
+
+	// Test: everything else
+	assert.False(t, p.Allowed("example.com", 22))
+	assert.False(t, p.Allowed("example.org", 443))
+	assert.False(t, p.Allowed("notexample.com", 443))
+	assert.False(t, p.Allowed("evil.com", 443))
+
+	// Test: no host allowlist allows no host, "*" allows every host on the allowed ports
+	p = NewForward(ForwardConfig{AllowedPorts: []int{8443}})
+	assert.False(t, p.Allowed("anything.test", 8443))
+	p = NewForward(ForwardConfig{AllowedPorts: []int{8443}, AllowedHosts: []string{"*"}})
+	assert.True(t, p.Allowed("anything.test", 8443))
+	assert.False(t, p.Allowed("anything.test", 443))
+}
+
+func TestConnectTunnel(t *testing.T) {
+	echoAddr, echoPort := startEchoServer(t)
+	p := NewForward(ForwardConfig{AllowedPorts: []int{echoPort}, AllowedHosts: []string{"127.0.0.1"}, AllowLocalAddrs: true})
+	proxyAddr := startProxyListener(t, p.Handle)
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "CONNECT "+echoAddr+" HTTP/1.1\r\nHost: "+echoAddr+"\r\n\r\n")
+	require.NoError(t, err)
+
+	// Test: the tunnel is confirmed without framing headers
+	r := bufio.NewReader(conn)
+	statusLine, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", statusLine)
+	blank, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", blank)
+
+	// Test: bytes flow both ways
+	_, err = io.WriteString(conn, "ping through the tunnel")
+	require.NoError(t, err)
+	buf := make([]byte, len("ping through the tunnel"))
+	_, err = io.ReadFull(r, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping through the tunnel", string(buf))
+
+	// Test: closing our side ends the tunnel
+	conn.(*net.TCPConn).CloseWrite()
+	rest, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Empty(t, rest)
+}
+
+func TestConnectRejected(t *testing.T) {
+	echoAddr, _ := startEchoServer(t)
+	p := NewForward(ForwardConfig{})
+	proxyAddr := startProxyListener(t, p.Handle)
+
+	for raw, status := range map[string]string{
+		"CONNECT " + echoAddr + " HTTP/1.1\r\n\r\n":   "HTTP/1.1 403 Forbidden\r\n",
+		"CONNECT nonsense HTTP/1.1\r\n\r\n":           "HTTP/1.1 400 Bad Request\r\n",
+		"GET /relative HTTP/1.1\r\nHost: x\r\n\r\n":   "HTTP/1.1 400 Bad Request\r\n",
+		"GET https://example.com/ HTTP/1.1\r\n\r\n":   "HTTP/1.1 400 Bad Request\r\n",
+		"GET http://example.com:22/ HTTP/1.1\r\n\r\n": "HTTP/1.1 403 Forbidden\r\n",
+	} {
+		conn, err := net.Dial("tcp", proxyAddr)
+		require.NoError(t, err)
+		io.WriteString(conn, raw)
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+		require.NoError(t, err)
+		// Test: disallowed or malformed requests are refused
+		assert.Equal(t, status, line, raw)
+	}
+}
+
+func TestAbsoluteForm(t *testing.T) {
+	var got *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		io.WriteString(w, "from upstream")
+	}))
+	defer upstream.Close()
+	u, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+	port, _ := strconv.Atoi(u.Port())
+	p := NewForward(ForwardConfig{AllowedPorts: []int{port}, AllowedHosts: []string{"127.0.0.1"}, AllowLocalAddrs: true})
+
+	// Test: the request is forwarded in origin-form to the target's host
+	req, err := request.RequestFromReader(strings.NewReader("GET " + upstream.URL + "/path?q=1 HTTP/1.1\r\n" +
+		"Host: ignored.example\r\nProxy-Connection: keep-alive\r\nProxy-Authorization: Basic eA==\r\n\r\n"))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	p.Handle(response.NewWriter(&buf), req)
+	resp, err := http.ReadResponse(bufio.NewReader(&buf), nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "from upstream", string(body))
+	require.NotNil(t, got)
+	assert.Equal(t, "/path", got.URL.Path)
+	assert.Equal(t, "q=1", got.URL.RawQuery)
+	assert.Equal(t, u.Host, got.Host)
+	assert.Equal(t, "1.1 httpfromtcp", got.Header.Get("Via"))
+	assert.Empty(t, got.Header.Get("Proxy-Connection"))
+	assert.Empty(t, got.Header.Get("Proxy-Authorization"))
+}
+
+func TestLocalAddrsRefused(t *testing.T) {
+	echoAddr, echoPort := startEchoServer(t)
+	p := NewForward(ForwardConfig{AllowedPorts: []int{echoPort}, AllowedHosts: []string{"*"}})
+	proxyAddr := startProxyListener(t, p.Handle)
+	localhost := "localhost:" + strconv.Itoa(echoPort)
+
+	for _, raw := range []string{
+		"CONNECT " + echoAddr + " HTTP/1.1\r\n\r\n",
+		"CONNECT " + localhost + " HTTP/1.1\r\n\r\n",
+		"GET http://" + echoAddr + "/ HTTP/1.1\r\n\r\n",
+		"GET http://" + localhost + "/ HTTP/1.1\r\n\r\n",
+	} {
+		conn, err := net.Dial("tcp", proxyAddr)
+		require.NoError(t, err)
+		io.WriteString(conn, raw)
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+		require.NoError(t, err)
+		// Test: allowed names and ports still can't reach the proxy's own host
+		assert.Equal(t, "HTTP/1.1 403 Forbidden\r\n", line, raw)
+	}
+
+	// Test: nor link-local addresses like the metadata endpoint
+	assert.ErrorIs(t, p.checkDialAddr("tcp", "169.254.169.254:80", nil), errLocalAddr)
+	assert.ErrorIs(t, p.checkDialAddr("tcp", "[::1]:80", nil), errLocalAddr)
+	assert.ErrorIs(t, p.checkDialAddr("tcp", "[::ffff:127.0.0.1]:80", nil), errLocalAddr)
+	assert.ErrorIs(t, p.checkDialAddr("tcp", "0.0.0.0:80", nil), errLocalAddr)
+	assert.NoError(t, p.checkDialAddr("tcp", "93.184.216.34:80", nil))
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"log"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+)
+
+type HealthCheck struct {
+	// path requested on every backend, e.g. "/healthz"
+	Path string
+	// time between checks, 0 disables active health checks
+	Interval time.Duration
+	// how long a check may take, 0 means the interval
+	Timeout time.Duration
+}
+
+func (p *ReverseProxy) runHealthChecks(stop <-chan struct{}) {
+	ticker := time.NewTicker(p.config.HealthCheck.Interval)
+	defer ticker.Stop()
+	for {
+		for _, b := range p.backends {
+			p.checkHealth(b)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *ReverseProxy) checkHealth(b *Backend) {
+	hc := p.config.HealthCheck
+	timeout := hc.Timeout
+	if timeout == 0 {
+		timeout = hc.Interval
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	target, err := joinTarget(b.URL, hc.Path)
+	healthy := err == nil
+	if healthy {
+		resp, err := p.client.Do(ctx, b.URL, &request.Request{
+			RequestLine: request.RequestLine{Method: "GET", RequestTarget: target, HttpVersion: "1.1"},
+			Headers:     headers.NewHeaders(),
+		})
+		healthy = err == nil && resp.StatusCode >= 200 && resp.StatusCode < 400
+		if err == nil {
+			// reading to the end lets the connection go back to the pool
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}
+	if was := b.Healthy(); was != healthy {
+		log.Printf("proxy: upstream %s is now %s", b.URL, healthState(healthy))
+	}
+	b.unhealthy.Store(!healthy)
+}
+
+func healthState(healthy bool) string {
+	if healthy {
+		return "healthy"
+	}
+	return "unhealthy"
+}
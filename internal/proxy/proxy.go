@@ -0,0 +1,446 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/client"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/tracing"
+)
+
+type Config struct {
+	// base URL requests are forwarded to, e.g. "http://localhost:8080/api"
+	Upstream string
+	// removed from the request path before it's joined to the upstream path
+	StripPrefix string
+	// optional rewrite of the path (and query) after StripPrefix
+	Rewrite func(target string) string
+	// forward the client's Host header instead of the upstream's
+	PreserveHost bool
+	// how long the upstream gets for the whole exchange, 0 means no limit
+	Timeout time.Duration
+	// used to reach the upstream, nil uses a client with default settings
+	Client *client.Client
+
+	// further upstreams to balance across together with Upstream
+	Upstreams []string
+	// picks the upstream for each request, nil means round robin
+	Balancer Balancer
+	// how many other upstreams an idempotent request is tried on when one
+	// can't be reached
+	Retries int
+	// consecutive failures after which an upstream is taken out of rotation,
+	// 0 disables passive ejection
+	MaxFails int
+	// how long an ejected upstream is left alone, 0 means DefaultEjectDuration
+	EjectDuration time.Duration
+	HealthCheck   HealthCheck
+
+	// traces each upstream call as a child of the request's span and sends
+	// its context upstream. Without one, traceparent is forwarded as is.
+	Tracer *tracing.Tracer
+}
+
+const DefaultEjectDuration = 30 * time.Second
+
+type ReverseProxy struct {
+	config   Config
+	backends []*Backend
+	balancer Balancer
+	client   *client.Client
+	stop     chan struct{}
+}
+
+// hop-by-hop headers only apply to a single connection and aren't forwarded
+// (RFC 9110 7.6.1), along with any header the Connection header names.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func New(cfg Config) (*ReverseProxy, error) {
+	upstreams := cfg.Upstreams
+	if cfg.Upstream != "" {
+		upstreams = append([]string{cfg.Upstream}, upstreams...)
+	}
+	if len(upstreams) == 0 {
+		return nil, fmt.Errorf("no upstream configured")
+	}
+	p := &ReverseProxy{
+		config:   cfg,
+		balancer: cfg.Balancer,
+		client:   cfg.Client,
+		stop:     make(chan struct{}),
+	}
+	for _, upstream := range upstreams {
+		u, err := parseUpstream(upstream)
+		if err != nil {
+			return nil, err
+		}
+		p.backends = append(p.backends, &Backend{URL: u})
+	}
+	if p.balancer == nil {
+		p.balancer = &RoundRobin{}
+	}
+	if p.client == nil {
+		p.client = &client.Client{}
+	}
+	if p.config.EjectDuration == 0 {
+		p.config.EjectDuration = DefaultEjectDuration
+	}
+	if cfg.HealthCheck.Interval > 0 {
+		go p.runHealthChecks(p.stop)
+	}
+	return p, nil
+}
+
+func parseUpstream(upstream string) (*url.URL, error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid upstream %q: scheme must be http or https", upstream)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid upstream %q: missing host", upstream)
+	}
+	return u, nil
+}
+
+// Backends returns the upstreams the proxy balances across.
+func (p *ReverseProxy) Backends() []*Backend {
+	return p.backends
+}
+
+// Close stops the active health checks.
+func (p *ReverseProxy) Close() error {
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+	return nil
+}
+
+// Handle forwards req to an upstream and relays its response. It can be
+// used as a server.Handler.
+func (p *ReverseProxy) Handle(w *response.Writer, req *request.Request) {
+	attempts := 1
+	if request.IsIdempotent(req.RequestLine.Method) {
+		attempts += p.config.Retries
+	}
+	tried := map[*Backend]bool{}
+	var lastErr error
+	for i := 0; i < attempts; i++ {
+		b := p.pick(req, tried)
+		if b == nil {
+			break
+		}
+		tried[b] = true
+		err := p.forward(w, req, b)
+		if err == nil {
+			return
+		}
+		if errors.Is(err, errBadTarget) {
+			response.WriteError(w, req, response.StatusCodeBadRequest, err.Error())
+			return
+		}
+		log.Printf("proxy: error forwarding %s to %s: %v", req.RequestLine.RequestTarget, b.URL.Host, err)
+		lastErr = err
+	}
+	switch {
+	case lastErr == nil:
+		response.WriteError(w, req, response.StatusCodeServiceUnavailable, "No upstream server is available.")
+	case isTimeout(lastErr):
+		response.WriteError(w, req, response.StatusCodeGatewayTimeout, "The upstream server didn't respond in time.")
+	default:
+		response.WriteError(w, req, response.StatusCodeBadGateway, "The upstream server couldn't be reached.")
+	}
+}
+
+// pick chooses among the available backends that haven't been tried yet.
+func (p *ReverseProxy) pick(req *request.Request, tried map[*Backend]bool) *Backend {
+	candidates := make([]*Backend, 0, len(p.backends))
+	for _, b := range p.backends {
+		if !tried[b] && b.available() {
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return p.balancer.Pick(req, candidates)
+}
+
+// forward sends req to b and relays the response. An error means nothing
+// was written to w and the request may be tried elsewhere.
+func (p *ReverseProxy) forward(w *response.Writer, req *request.Request, b *Backend) error {
+	ctx := context.Background()
+	if p.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.config.Timeout)
+		defer cancel()
+	}
+	outReq, err := p.outgoingRequest(req, b.URL)
+	if err != nil {
+		return err
+	}
+	span := p.startSpan(req, outReq, b)
+	b.active.Add(1)
+	defer b.active.Add(-1)
+	resp, err := p.client.Do(ctx, b.URL, outReq)
+	if err != nil {
+		p.recordResult(b, true)
+		finishSpan(span, 0, err)
+		return err
+	}
+	defer resp.Body.Close()
+	// the upstream answered, but a gateway error from it still counts against it
+	p.recordResult(b, resp.StatusCode == 502 || resp.StatusCode == 503 || resp.StatusCode == 504)
+	relayStart := time.Now()
+	err = relayResponse(w, req, resp)
+	if err != nil {
+		log.Printf("proxy: error relaying %s from %s: %v", outReq.RequestLine.RequestTarget, b.URL.Host, err)
+	}
+	if span != nil {
+		span.AddPhase("upstream", span.Start, relayStart)
+		span.AddPhase("relay", relayStart, time.Now())
+	}
+	finishSpan(span, resp.StatusCode, err)
+	return nil
+}
+
+// startSpan starts the span for calling b and puts its context on outReq,
+// nil without a tracer.
+func (p *ReverseProxy) startSpan(req, outReq *request.Request, b *Backend) *tracing.Span {
+	if p.config.Tracer == nil {
+		return nil
+	}
+	parent, _ := tracing.Extract(req.Headers)
+	span := p.config.Tracer.Start("proxy "+req.RequestLine.Method, parent, time.Now())
+	span.SetAttribute("upstream", b.URL.String())
+	span.SetAttribute("http.target", outReq.RequestLine.RequestTarget)
+	tracing.Inject(outReq.Headers, span.Context)
+	return span
+}
+
+func finishSpan(span *tracing.Span, status int, err error) {
+	if span == nil {
+		return
+	}
+	if status != 0 {
+		span.SetAttribute("http.status_code", status)
+	}
+	if err != nil {
+		span.SetAttribute("error", err.Error())
+	}
+	span.Finish(time.Now())
+}
+
+func (p *ReverseProxy) recordResult(b *Backend, failed bool) {
+	if b.recordResult(failed, p.config.MaxFails, p.config.EjectDuration) {
+		log.Printf("proxy: ejecting upstream %s for %s", b.URL, p.config.EjectDuration)
+	}
+}
+
+func (p *ReverseProxy) outgoingRequest(req *request.Request, upstream *url.URL) (*request.Request, error) {
+	target := req.RequestLine.RequestTarget
+	if p.config.StripPrefix != "" {
+		target = strings.TrimPrefix(target, p.config.StripPrefix)
+	}
+	if p.config.Rewrite != nil {
+		target = p.config.Rewrite(target)
+	}
+	target, err := joinTarget(upstream, target)
+	if err != nil {
+		return nil, err
+	}
+
+	h := headers.NewHeaders()
+	for k, v := range req.Headers {
+		h[k] = v
+	}
+	removeHopByHop(h)
+	// the client sets it from the body
+	h.Delete("Content-Length")
+
+	clientHost, _ := req.Headers.Get("Host")
+	h.Set("Host", upstream.Host)
+	if p.config.PreserveHost && clientHost != "" {
+		h.Set("Host", clientHost)
+	}
+	addForwardedHeaders(h, req, clientHost)
+	return &request.Request{
+		RequestLine: request.RequestLine{
+			Method:        req.RequestLine.Method,
+			RequestTarget: target,
+			HttpVersion:   "1.1",
+		},
+		Headers: h,
+		Body:    req.Body,
+	}, nil
+}
+
+var errBadTarget = errors.New("invalid request target")
+
+// joinTarget joins the request target onto the upstream URL's path and query.
+func joinTarget(upstream *url.URL, target string) (string, error) {
+	if !strings.HasPrefix(target, "/") {
+		target = "/" + target
+	}
+	ref, err := url.ParseRequestURI(target)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", errBadTarget, target)
+	}
+	u := url.URL{
+		Path:     strings.TrimSuffix(upstream.Path, "/") + ref.Path,
+		RawQuery: ref.RawQuery,
+	}
+	if upstream.RawQuery != "" {
+		u.RawQuery = upstream.RawQuery
+		if ref.RawQuery != "" {
+			u.RawQuery += "&" + ref.RawQuery
+		}
+	}
+	return u.RequestURI(), nil
+}
+
+func addForwardedHeaders(h headers.Headers, req *request.Request, clientHost string) {
+	clientIP := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		clientIP = host
+	}
+	if clientIP != "" {
+		h.Add("X-Forwarded-For", clientIP)
+	}
+	if clientHost != "" {
+		h.Set("X-Forwarded-Host", clientHost)
+	}
+	proto := "http"
+	if req.TLS {
+		proto = "https"
+	}
+	h.Set("X-Forwarded-Proto", proto)
+
+	// RFC 7239, IPv6 addresses have to be bracketed and quoted
+	var elem []string
+	if clientIP != "" {
+		node := clientIP
+		if strings.Contains(node, ":") {
+			node = `"[` + node + `]"`
+		}
+		elem = append(elem, "for="+node)
+	}
+	if clientHost != "" {
+		elem = append(elem, "host="+headers.Quote(clientHost))
+	}
+	elem = append(elem, "proto="+proto)
+	h.Add("Forwarded", strings.Join(elem, ";"))
+}
+
+// relayResponse writes an upstream response to w without its hop-by-hop headers.
+func relayResponse(w *response.Writer, req *request.Request, resp *client.Response) error {
+	trailerNames := resp.Headers.Values("Trailer")
+	h := headers.NewHeaders()
+	for k, v := range resp.Headers {
+		h[k] = v
+	}
+	removeHopByHop(h)
+	for _, c := range resp.SetCookies {
+		if err := w.AddSetCookie(c); err != nil {
+			return err
+		}
+	}
+	h.Set("Connection", "close")
+
+	if err := w.WriteStatusLine(response.StatusCode(resp.StatusCode)); err != nil {
+		return err
+	}
+	if !hasBody(req, resp) {
+		return w.WriteHeaders(h)
+	}
+	if resp.ContentLength >= 0 {
+		h.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+		if err := w.WriteHeaders(h); err != nil {
+			return err
+		}
+		_, err := w.WriteBodyFrom(resp.Body)
+		return err
+	}
+
+	// unknown length, relay it chunked along with any trailers
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	if len(trailerNames) > 0 {
+		h.Set("Trailer", strings.Join(trailerNames, ", "))
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.WriteChunkedBody(buf[:n]); werr != nil {
+				return werr
+			}
+			// don't hold back streamed responses
+			if ferr := w.Flush(); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	return w.WriteTrailers(resp.Trailers)
+}
+
+func hasBody(req *request.Request, resp *client.Response) bool {
+	if req.RequestLine.Method == "HEAD" {
+		return false
+	}
+	return resp.StatusCode >= 200 && resp.StatusCode != 204 && resp.StatusCode != 304
+}
+
+func removeHopByHop(h headers.Headers) {
+	for _, name := range h.Values("Connection") {
+		h.Delete(name)
+	}
+	for _, name := range hopByHopHeaders {
+		h.Delete(name)
+	}
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,300 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/server"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func proxyRequest(t *testing.T, p *ReverseProxy, raw string) *http.Response {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	req.RemoteAddr = "192.0.2.7:51234"
+	var buf bytes.Buffer
+	p.Handle(response.NewWriter(&buf), req)
+	resp, err := http.ReadResponse(bufio.NewReader(&buf), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestForwardRequest(t *testing.T) {
+	var got *http.Request
+	var gotBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("X-Upstream", "yes")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("Set-Cookie", "b=2; Path=/")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+	defer upstream.Close()
+
+	p, err := New(Config{Upstream: upstream.URL + "/api", StripPrefix: "/proxy"})
+	require.NoError(t, err)
+
+	// Test: method, path, query, body and end-to-end headers are forwarded
+	resp := proxyRequest(t, p, "POST /proxy/items?x=1 HTTP/1.1\r\n"+
+		"Host: example.com\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Content-Length: 5\r\n"+
+		"Connection: close, X-Secret\r\n"+
+		"X-Secret: hop\r\n"+
+		"X-Custom: kept\r\n"+
+		"X-Forwarded-For: 203.0.113.1\r\n"+
+		"\r\n"+
+		"hello")
+	require.NotNil(t, got)
+	assert.Equal(t, "POST", got.Method)
+	assert.Equal(t, "/api/items", got.URL.Path)
+	assert.Equal(t, "x=1", got.URL.RawQuery)
+	assert.Equal(t, "hello", string(gotBody))
+	assert.Equal(t, "kept", got.Header.Get("X-Custom"))
+	assert.Equal(t, "text/plain", got.Header.Get("Content-Type"))
+	assert.Empty(t, got.Header.Get("X-Secret"))
+	assert.Equal(t, strings.TrimPrefix(upstream.URL, "http://"), got.Host)
+
+	// Test: forwarding headers record the client
+	assert.Equal(t, "203.0.113.1, 192.0.2.7", got.Header.Get("X-Forwarded-For"))
+	assert.Equal(t, "example.com", got.Header.Get("X-Forwarded-Host"))
+	assert.Equal(t, "http", got.Header.Get("X-Forwarded-Proto"))
+	assert.Equal(t, "for=192.0.2.7;host=example.com;proto=http", got.Header.Get("Forwarded"))
+
+	// Test: the response is relayed without hop-by-hop headers
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, "yes", resp.Header.Get("X-Upstream"))
+	assert.Empty(t, resp.Header.Get("Keep-Alive"))
+	assert.Equal(t, []string{"a=1", "b=2; Path=/"}, resp.Header.Values("Set-Cookie"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "created", string(body))
+}
+
+// writeSelfSigned writes a self-signed certificate for localhost and its key
+// to a temporary directory, returning the files.
+func writeSelfSigned(t *testing.T) server.CertFiles {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	files := server.CertFiles{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}
+	require.NoError(t, os.WriteFile(files.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(files.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return files
+}
+
+func TestForwardedProtoOverTLS(t *testing.T) {
+	var got *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	p, err := New(Config{Upstream: upstream.URL})
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv, err := server.ServeTLSListener(p.Handle, server.TLSConfig{
+		Certificates:   []server.CertFiles{writeSelfSigned(t)},
+		ReloadInterval: -1,
+	}, ln)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// Test: requests that arrived over TLS are forwarded as https
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := c.Get("https://" + ln.Addr().String() + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	require.NotNil(t, got)
+	assert.Equal(t, "https", got.Header.Get("X-Forwarded-Proto"))
+	assert.Equal(t, "for=127.0.0.1;host=\""+ln.Addr().String()+"\";proto=https", got.Header.Get("Forwarded"))
+}
+
+func TestPreserveHostAndRewrite(t *testing.T) {
+	var got *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+	defer upstream.Close()
+
+	p, err := New(Config{
+		Upstream:     upstream.URL,
+		PreserveHost: true,
+		Rewrite: func(target string) string {
+			return strings.Replace(target, "/v1/", "/v2/", 1)
+		},
+	})
+	require.NoError(t, err)
+
+	// Test: Host is kept and the path rewritten
+	resp := proxyRequest(t, p, "DELETE /v1/thing HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+	require.NotNil(t, got)
+	assert.Equal(t, "DELETE", got.Method)
+	assert.Equal(t, "example.com", got.Host)
+	assert.Equal(t, "/v2/thing", got.URL.Path)
+}
+
+func TestStreamedResponse(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Write([]byte("part one, "))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("part two"))
+		w.Header().Set("X-Checksum", "abc")
+	}))
+	defer upstream.Close()
+
+	p, err := New(Config{Upstream: upstream.URL})
+	require.NoError(t, err)
+
+	// Test: responses without a length are relayed chunked with their trailers
+	resp := proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "part one, part two", string(body))
+	assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"))
+}
+
+func TestNoBodyResponses(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/elsewhere", http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Length", "11")
+		w.Write([]byte("hello world"))
+	}))
+	defer upstream.Close()
+
+	p, err := New(Config{Upstream: upstream.URL})
+	require.NoError(t, err)
+
+	// Test: redirects are passed to the client instead of followed
+	resp := proxyRequest(t, p, "GET /redirect HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, 302, resp.StatusCode)
+	assert.Equal(t, "/elsewhere", resp.Header.Get("Location"))
+
+	// Test: HEAD keeps the upstream's Content-Length but sends no body
+	req, err := request.RequestFromReader(strings.NewReader("HEAD / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	p.Handle(response.NewWriter(&buf), req)
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, buf.String(), "content-length: 11\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+}
+
+func TestUpstreamErrors(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	// Test: a slow upstream answers 504
+	p, err := New(Config{Upstream: slow.URL, Timeout: 20 * time.Millisecond})
+	require.NoError(t, err)
+	resp := proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: localhost\r\nAccept: application/json\r\n\r\n")
+	assert.Equal(t, 504, resp.StatusCode)
+
+	// Test: an unreachable upstream answers 502
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	p, err = New(Config{Upstream: closed.URL})
+	require.NoError(t, err)
+	resp = proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, 502, resp.StatusCode)
+
+	// Test: invalid upstream URLs are rejected
+	_, err = New(Config{Upstream: "ftp://example.com"})
+	assert.Error(t, err)
+	_, err = New(Config{Upstream: "http://"})
+	assert.Error(t, err)
+}
+
+type spanRecorder struct {
+	spans []*tracing.Span
+}
+
+func (r *spanRecorder) Export(span *tracing.Span) error {
+	r.spans = append(r.spans, span)
+	return nil
+}
+
+func TestTracePropagation(t *testing.T) {
+	var got *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	raw := "GET / HTTP/1.1\r\nHost: example.com\r\n" +
+		"traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\n" +
+		"tracestate: rojo=00f067aa0ba902b7\r\n\r\n"
+
+	// Test: without a tracer the trace context is forwarded as is
+	p, err := New(Config{Upstream: upstream.URL})
+	require.NoError(t, err)
+	proxyRequest(t, p, raw)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", got.Header.Get("traceparent"))
+	assert.Equal(t, "rojo=00f067aa0ba902b7", got.Header.Get("tracestate"))
+
+	// Test: with a tracer the upstream call is a child span, and its context is sent
+	var recorder spanRecorder
+	p, err = New(Config{Upstream: upstream.URL, Tracer: tracing.NewTracer(&recorder)})
+	require.NoError(t, err)
+	proxyRequest(t, p, raw)
+	require.Len(t, recorder.spans, 1)
+	span := recorder.spans[0]
+	assert.Equal(t, "00f067aa0ba902b7", span.Parent.String())
+	assert.Equal(t, span.Context.Traceparent(), got.Header.Get("traceparent"))
+	assert.Equal(t, "rojo=00f067aa0ba902b7", got.Header.Get("tracestate"))
+	assert.Equal(t, 200, span.Attributes["http.status_code"])
+	require.Len(t, span.Phases, 2)
+	assert.Equal(t, "upstream", span.Phases[0].Name)
+	assert.Equal(t, "relay", span.Phases[1].Name)
+}
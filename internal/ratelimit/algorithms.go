@@ -0,0 +1,171 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// TokenBucket lets a key make burst requests at once, refilled at limit
+// requests per window.
+type TokenBucket struct {
+	limit  int
+	window time.Duration
+	burst  int
+	// tokens added per second
+	rate  float64
+	store *store[bucket]
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket allows limit requests per window, with bursts of up to
+// burst requests. A burst of 0 means limit. limit and window have to be
+// positive.
+func NewTokenBucket(limit int, window time.Duration, burst int) *TokenBucket {
+	checkLimit(limit, window)
+	if burst <= 0 {
+		burst = limit
+	}
+	rate := float64(limit) / window.Seconds()
+	return &TokenBucket{
+		limit:  limit,
+		window: window,
+		burst:  burst,
+		rate:   rate,
+		// an idle bucket is full again after this long
+		store: newStore[bucket](time.Duration(float64(burst) / rate * float64(time.Second))),
+	}
+}
+
+func (tb *TokenBucket) Allow(key string) Result {
+	t := now()
+	res := Result{Limit: tb.burst}
+	tb.store.update(key, t, func(b *bucket, fresh bool) {
+		if fresh {
+			b.tokens = float64(tb.burst)
+		} else {
+			b.tokens = math.Min(float64(tb.burst), b.tokens+t.Sub(b.last).Seconds()*tb.rate)
+		}
+		b.last = t
+		if b.tokens >= 1 {
+			b.tokens--
+			res.Allowed = true
+		} else {
+			res.RetryAfter = tb.after(1 - b.tokens)
+		}
+		res.Remaining = int(b.tokens)
+		res.Reset = tb.after(float64(tb.burst) - b.tokens)
+	})
+	return res
+}
+
+// after is how long refilling tokens takes.
+func (tb *TokenBucket) after(tokens float64) time.Duration {
+	return time.Duration(tokens / tb.rate * float64(time.Second))
+}
+
+// SetMaxKeys caps how many keys are tracked at once, DefaultMaxKeys unless
+// set.
+func (tb *TokenBucket) SetMaxKeys(n int) {
+	tb.store.setMaxKeys(n)
+}
+
+func (tb *TokenBucket) Policy() string {
+	return fmt.Sprintf("%d;w=%d;burst=%d", tb.limit, seconds(tb.window), tb.burst)
+}
+
+// SlidingWindow allows limit requests in any window-long stretch of time.
+// It estimates the count from the current and previous fixed windows,
+// weighting the previous one by how much of it the sliding window still
+// covers, so it needs two counters per key instead of a log of requests.
+type SlidingWindow struct {
+	limit  int
+	window time.Duration
+	store  *store[windowCounts]
+}
+
+type windowCounts struct {
+	start         time.Time
+	previous, cur int
+}
+
+// NewSlidingWindow allows limit requests per window. Both have to be
+// positive.
+func NewSlidingWindow(limit int, window time.Duration) *SlidingWindow {
+	checkLimit(limit, window)
+	return &SlidingWindow{
+		limit:  limit,
+		window: window,
+		// two windows on, the previous counts no longer matter
+		store: newStore[windowCounts](2 * window),
+	}
+}
+
+func (sw *SlidingWindow) Allow(key string) Result {
+	t := now()
+	start := t.Truncate(sw.window)
+	res := Result{Limit: sw.limit, Reset: start.Add(sw.window).Sub(t)}
+	sw.store.update(key, t, func(c *windowCounts, fresh bool) {
+		switch {
+		case fresh || start.Sub(c.start) > sw.window:
+			*c = windowCounts{start: start}
+		case start.Sub(c.start) == sw.window:
+			*c = windowCounts{start: start, previous: c.cur}
+		}
+		elapsed := t.Sub(start)
+		estimate := float64(c.previous)*sw.weight(elapsed) + float64(c.cur)
+		if estimate+1 <= float64(sw.limit) {
+			c.cur++
+			estimate++
+			res.Allowed = true
+		} else {
+			res.RetryAfter = sw.retryAfter(*c, elapsed)
+		}
+		res.Remaining = max(0, int(float64(sw.limit)-estimate))
+	})
+	return res
+}
+
+// SetMaxKeys caps how many keys are tracked at once, DefaultMaxKeys unless
+// set.
+func (sw *SlidingWindow) SetMaxKeys(n int) {
+	sw.store.setMaxKeys(n)
+}
+
+func checkLimit(limit int, window time.Duration) {
+	if limit < 1 || window <= 0 {
+		panic(fmt.Sprintf("ratelimit: invalid limit %d per %s", limit, window))
+	}
+}
+
+// weight is how much of the previous window the sliding window covers.
+func (sw *SlidingWindow) weight(elapsed time.Duration) float64 {
+	return 1 - float64(elapsed)/float64(sw.window)
+}
+
+// retryAfter is how long until the estimate leaves room for one more
+// request, elapsed into the current window.
+func (sw *SlidingWindow) retryAfter(c windowCounts, elapsed time.Duration) time.Duration {
+	// how far into a window previous has to have decayed for room to be left
+	// over next to current requests
+	until := func(previous, current int) time.Duration {
+		room := float64(sw.limit - current - 1)
+		if previous == 0 || room >= float64(previous) {
+			return 0
+		}
+		return time.Duration((1 - room/float64(previous)) * float64(sw.window))
+	}
+	if c.cur+1 <= sw.limit {
+		return max(0, until(c.previous, c.cur)-elapsed)
+	}
+	// not before the next window, where the current count decays instead
+	return sw.window - elapsed + until(c.cur, 0)
+}
+
+func (sw *SlidingWindow) Policy() string {
+	return fmt.Sprintf("%d;w=%d", sw.limit, seconds(sw.window))
+}
@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock replaces now for the duration of a test.
+func fakeClock(t *testing.T) *time.Time {
+	t.Helper()
+	clock := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return clock
+	}
+	t.Cleanup(func() { now = time.Now })
+	return &clock
+}
+
+func TestTokenBucket(t *testing.T) {
+	clock := fakeClock(t)
+	tb := NewTokenBucket(10, 10*time.Second, 3)
+	assert.Equal(t, "10;w=10;burst=3", tb.Policy())
+
+	// Test: a new key can use its whole burst at once
+	for remaining := 2; remaining >= 0; remaining-- {
+		res := tb.Allow("a")
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 3, res.Limit)
+		assert.Equal(t, remaining, res.Remaining)
+	}
+
+	// Test: then it has to wait for a token to be added
+	res := tb.Allow("a")
+	assert.False(t, res.Allowed)
+	assert.Equal(t, time.Second, res.RetryAfter)
+	assert.Equal(t, 3*time.Second, res.Reset)
+
+	// Test: tokens are added at limit per window
+	*clock = clock.Add(1500 * time.Millisecond)
+	assert.True(t, tb.Allow("a").Allowed)
+	res = tb.Allow("a")
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 500*time.Millisecond, res.RetryAfter)
+
+	// Test: keys are limited separately
+	assert.True(t, tb.Allow("b").Allowed)
+
+	// Test: keys are evicted once their bucket would be full again
+	*clock = clock.Add(3 * time.Second)
+	assert.True(t, tb.Allow("c").Allowed)
+	assert.Equal(t, 1, tb.store.len())
+}
+
+func TestSlidingWindow(t *testing.T) {
+	clock := fakeClock(t)
+	sw := NewSlidingWindow(4, 10*time.Second)
+	assert.Equal(t, "4;w=10", sw.Policy())
+
+	// Test: limit requests are allowed within a window
+	for remaining := 3; remaining >= 0; remaining-- {
+		res := sw.Allow("a")
+		assert.True(t, res.Allowed)
+		assert.Equal(t, remaining, res.Remaining)
+	}
+	res := sw.Allow("a")
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 10*time.Second, res.Reset)
+
+	// Test: the previous window's requests count less the further the
+	// window slides past them
+	assert.Equal(t, 12500*time.Millisecond, res.RetryAfter)
+	*clock = clock.Add(12500 * time.Millisecond)
+	res = sw.Allow("a")
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	res = sw.Allow("a")
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 2500*time.Millisecond, res.RetryAfter)
+	*clock = clock.Add(2500 * time.Millisecond)
+	assert.True(t, sw.Allow("a").Allowed)
+
+	// Test: after a full window without requests the count starts over
+	*clock = clock.Add(20 * time.Second)
+	assert.Equal(t, 3, sw.Allow("a").Remaining)
+
+	// Test: idle keys are evicted after two windows
+	*clock = clock.Add(20 * time.Second)
+	sw.Allow("b")
+	assert.Equal(t, 1, sw.store.len())
+}
+
+func TestMaxKeys(t *testing.T) {
+	clock := fakeClock(t)
+	tb := NewTokenBucket(1, time.Hour, 1)
+	tb.SetMaxKeys(3)
+
+	// Test: keys made up by the thousand don't grow the store past the cap
+	tb.Allow("a")
+	for i := range 1000 {
+		*clock = clock.Add(time.Millisecond)
+		tb.Allow(strconv.Itoa(i))
+		tb.Allow("a")
+	}
+	assert.Equal(t, 3, tb.store.len())
+
+	// Test: the least recently used key goes first, a key still in use stays
+	assert.False(t, tb.Allow("a").Allowed)
+	_, ok := tb.store.entries["996"]
+	assert.False(t, ok)
+	_, ok = tb.store.entries["999"]
+	assert.True(t, ok)
+
+	// Test: lowering the cap evicts right away
+	tb.SetMaxKeys(1)
+	assert.Equal(t, 1, tb.store.len())
+	assert.False(t, tb.Allow("a").Allowed)
+}
+
+func TestInvalidLimit(t *testing.T) {
+	// Test: limits that can't allow anything are programming errors
+	assert.Panics(t, func() { NewTokenBucket(0, time.Second, 0) })
+	assert.Panics(t, func() { NewSlidingWindow(1, 0) })
+}
@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/server"
+)
+
+// Limiter decides whether the next request counted under a key may go
+// ahead, and counts it if so.
+type Limiter interface {
+	Allow(key string) Result
+	// Policy describes the limit for the RateLimit-Policy header, e.g.
+	// "100;w=60".
+	Policy() string
+}
+
+// Result is a Limiter's decision about one request.
+type Result struct {
+	Allowed bool
+	// requests the key may make at once
+	Limit     int
+	Remaining int
+	// how long until the key's count starts over
+	Reset time.Duration
+	// how long a denied request should wait before retrying
+	RetryAfter time.Duration
+}
+
+// KeyFunc picks the key a request is counted under.
+type KeyFunc func(req *request.Request) string
+
+// ByIP counts requests per client IP address.
+func ByIP() KeyFunc {
+	return clientIP
+}
+
+func clientIP(req *request.Request) string {
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// ByHeader counts requests per value of the named header, e.g. an API key.
+// Requests without it are counted per client IP. The two never share a key,
+// so a client can't spend another's quota by sending their IP.
+//
+// A client that sends a new value with every request starts a new count
+// every time, so this only limits anything if the header has been
+// authenticated before the middleware runs, e.g. an API key checked against
+// the issued ones. Otherwise count by IP.
+func ByHeader(name string) KeyFunc {
+	return func(req *request.Request) string {
+		if v, ok := req.Headers.Get(name); ok && v != "" {
+			return "header:" + v
+		}
+		return "ip:" + clientIP(req)
+	}
+}
+
+// ByRoute counts requests per route, shared by every client. route names
+// the route serving a request, e.g. by its prefix; it should return a small
+// set of names rather than raw paths.
+func ByRoute(route func(req *request.Request) string) KeyFunc {
+	return KeyFunc(route)
+}
+
+// Middleware answers requests over limiter's limit with 429 Too Many
+// Requests and Retry-After. Every response gets the RateLimit-Limit,
+// RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy headers. A nil
+// key means ByIP.
+func Middleware(limiter Limiter, key KeyFunc) server.Middleware {
+	if key == nil {
+		key = ByIP()
+	}
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			res := limiter.Allow(key(req))
+			w.SetHeader("RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.SetHeader("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.SetHeader("RateLimit-Reset", strconv.Itoa(seconds(res.Reset)))
+			w.SetHeader("RateLimit-Policy", limiter.Policy())
+			if !res.Allowed {
+				// a denied client has to wait at least a moment
+				retryAfter := max(1, seconds(res.RetryAfter))
+				w.SetHeader("Retry-After", strconv.Itoa(retryAfter))
+				response.WriteError(w, req, response.StatusCodeTooManyRequests,
+					fmt.Sprintf("Too many requests, try again in %d seconds.", retryAfter))
+				return
+			}
+			next(w, req)
+		}
+	}
+}
+
+// seconds rounds d up to whole seconds, as the headers need.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeOK(w *response.Writer, _ *request.Request) {
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	w.WriteHeaders(response.GetDefaultHeaders(2))
+	w.WriteBody([]byte("ok"))
+}
+
+func serve(t *testing.T, handler server.Handler, remoteAddr string) *http.Response {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	req.RemoteAddr = remoteAddr
+	var buf bytes.Buffer
+	handler(response.NewWriter(&buf), req)
+	resp, err := http.ReadResponse(bufio.NewReader(&buf), nil)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestMiddleware(t *testing.T) {
+	clock := fakeClock(t)
+	handler := server.Chain(writeOK, Middleware(NewTokenBucket(2, time.Minute, 0), nil))
+
+	// Test: allowed responses report the quota left
+	resp := serve(t, handler, "192.0.2.1:1000")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "1", resp.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", resp.Header.Get("RateLimit-Reset"))
+	assert.Equal(t, "2;w=60;burst=2", resp.Header.Get("RateLimit-Policy"))
+	assert.Empty(t, resp.Header.Get("Retry-After"))
+
+	// Test: requests over the limit get 429 with Retry-After
+	serve(t, handler, "192.0.2.1:1001")
+	resp = serve(t, handler, "192.0.2.1:1002")
+	assert.Equal(t, 429, resp.StatusCode)
+	assert.Equal(t, "30", resp.Header.Get("Retry-After"))
+	assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+
+	// Test: requests are counted per client IP by default
+	assert.Equal(t, 200, serve(t, handler, "192.0.2.2:1000").StatusCode)
+
+	// Test: the client can go again after Retry-After
+	*clock = clock.Add(30 * time.Second)
+	assert.Equal(t, 200, serve(t, handler, "192.0.2.1:1003").StatusCode)
+}
+
+func TestKeyFuncs(t *testing.T) {
+	req := &request.Request{
+		RequestLine: request.RequestLine{RequestTarget: "/api/users"},
+		Headers:     headers.NewHeaders(),
+		RemoteAddr:  "[2001:db8::1]:443",
+	}
+
+	// Test: ByIP uses the client's address without its port
+	assert.Equal(t, "2001:db8::1", ByIP()(req))
+
+	// Test: ByHeader falls back to the IP in a separate key space
+	byKey := ByHeader("X-Api-Key")
+	assert.Equal(t, "ip:2001:db8::1", byKey(req))
+	req.Headers.Set("X-Api-Key", "2001:db8::1")
+	assert.Equal(t, "header:2001:db8::1", byKey(req))
+
+	// Test: ByRoute uses the route's name
+	byRoute := ByRoute(func(req *request.Request) string { return "/api" })
+	assert.Equal(t, "/api", byRoute(req))
+}
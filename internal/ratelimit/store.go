@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// DefaultMaxKeys is how many keys a limiter tracks unless told otherwise.
+const DefaultMaxKeys = 100_000
+
+// overridable in tests
+var now = time.Now
+
+// store keeps per-key limiter state in memory. Keys unused for idle are
+// evicted as keys are used. Limiters pick idle so that an evicted key is no
+// different from a new one. Past maxKeys the least recently used key is
+// evicted early, so clients making up keys can't grow the store without
+// bound; that key starts over as new.
+type store[T any] struct {
+	idle    time.Duration
+	maxKeys int
+
+	mu      sync.Mutex
+	order   *list.List // front is the most recently used
+	entries map[string]*list.Element
+}
+
+type storeEntry[T any] struct {
+	key      string
+	state    T
+	lastSeen time.Time
+}
+
+func newStore[T any](idle time.Duration) *store[T] {
+	return &store[T]{
+		idle:    idle,
+		maxKeys: DefaultMaxKeys,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// setMaxKeys changes the cap on tracked keys, n < 1 means DefaultMaxKeys.
+func (s *store[T]) setMaxKeys(n int) {
+	if n < 1 {
+		n = DefaultMaxKeys
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxKeys = n
+	for len(s.entries) > s.maxKeys {
+		s.remove(s.order.Back())
+	}
+}
+
+// update runs f on key's state with the store locked. fresh tells f the key
+// is new and its state zero.
+func (s *store[T]) update(key string, t time.Time, f func(state *T, fresh bool)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for back := s.order.Back(); back != nil && t.Sub(back.Value.(*storeEntry[T]).lastSeen) >= s.idle; back = s.order.Back() {
+		s.remove(back)
+	}
+	elem, ok := s.entries[key]
+	if ok {
+		s.order.MoveToFront(elem)
+	} else {
+		if len(s.entries) >= s.maxKeys {
+			s.remove(s.order.Back())
+		}
+		elem = s.order.PushFront(&storeEntry[T]{key: key})
+		s.entries[key] = elem
+	}
+	e := elem.Value.(*storeEntry[T])
+	e.lastSeen = t
+	f(&e.state, !ok)
+}
+
+func (s *store[T]) remove(elem *list.Element) {
+	s.order.Remove(elem)
+	delete(s.entries, elem.Value.(*storeEntry[T]).key)
+}
+
+func (s *store[T]) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
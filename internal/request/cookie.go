@@ -0,0 +1,26 @@
+package request
+
+import (
+	"errors"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+)
+
+var ErrNoCookie = errors.New("named cookie not present")
+
+func (r *Request) Cookies() []*headers.Cookie {
+	value, ok := r.Headers.Get("Cookie")
+	if !ok {
+		return []*headers.Cookie{}
+	}
+	return headers.ParseCookies(value)
+}
+
+func (r *Request) Cookie(name string) (*headers.Cookie, error) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return nil, ErrNoCookie
+}
@@ -5,7 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"strconv"
+	"math"
 	"strings"
 
 	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
@@ -15,9 +15,32 @@ type Request struct {
 	RequestLine RequestLine
 	Headers     headers.Headers
 	Body        []byte
-	state       requestState
+	// address of the client, set by the server
+	RemoteAddr string
+	// whether the request came over TLS, set by the server
+	TLS   bool
+	state requestState
+	// bytes read off the connection past the end of the request
+	buffered []byte
+	limits   Limits
+	// size of the request line and headers parsed so far
+	headerBytes int
 }
 
+// Limits caps how big a request may be, zero means no limit.
+type Limits struct {
+	// the request line and headers together
+	MaxHeaderBytes int
+	MaxBodyBytes   int64
+}
+
+var (
+	ErrHeaderTooLarge = errors.New("request header too large")
+	ErrBodyTooLarge   = errors.New("request body too large")
+	// the reader ended before the request did
+	ErrIncomplete = errors.New("incomplete request")
+)
+
 type RequestLine struct {
 	HttpVersion   string
 	RequestTarget string
@@ -49,12 +72,19 @@ var validMethods = map[string]struct{}{
 }
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
+	return ReadRequest(reader, Limits{})
+}
+
+// ReadRequest is like RequestFromReader, but fails with ErrHeaderTooLarge or
+// ErrBodyTooLarge once the request exceeds limits.
+func ReadRequest(reader io.Reader, limits Limits) (*Request, error) {
 	buf := make([]byte, bufferSize, bufferSize)
 	readToIndex := 0
 	req := &Request{
 		state:   requestStateInitialized,
 		Headers: headers.NewHeaders(),
 		Body:    make([]byte, 0),
+		limits:  limits,
 	}
 	for req.state != requestStateDone {
 		// if buffer is full, create new buffer twice the size and copy the old data
@@ -68,7 +98,7 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				if req.state != requestStateDone {
-					return nil, fmt.Errorf("incomplete request, in state: %d, read n bytes on EOF: %d", req.state, numBytesRead)
+					return nil, fmt.Errorf("%w, in state: %d, read n bytes on EOF: %d", ErrIncomplete, req.state, numBytesRead)
 				}
 				break
 			}
@@ -84,10 +114,35 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		// copy the data to a new buffer
 		copy(buf, buf[numBytesParsed:])
 		readToIndex -= numBytesParsed
+		// what's left unparsed is part of the header until it's complete
+		if limits.MaxHeaderBytes > 0 && req.state < requestStateParsingBody &&
+			req.headerBytes+readToIndex > limits.MaxHeaderBytes {
+			return nil, ErrHeaderTooLarge
+		}
+	}
+	if readToIndex > 0 {
+		req.buffered = append([]byte(nil), buf[:readToIndex]...)
 	}
 	return req, nil
 }
 
+// Buffered returns bytes that were read from the reader after the end of
+// the request, e.g. the first frames of a protocol the connection is
+// switching to. They'd otherwise be lost to whoever reads next.
+func (r *Request) Buffered() []byte {
+	return r.buffered
+}
+
+// IsIdempotent reports whether sending a request with method twice has the
+// same effect as sending it once (RFC 9110 9.2.2), so it can be retried.
+func IsIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
 func parseRequestLine(data []byte) (*RequestLine, int, error) {
 	idx := bytes.Index(data, []byte(crlf))
 	if idx == -1 {
@@ -167,6 +222,7 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		}
 		r.RequestLine = *requestLine
 		r.state = requestStateParsingHeaders
+		r.headerBytes += n
 		return n, nil
 	case requestStateParsingHeaders:
 		n, done, err := r.Headers.Parse(data)
@@ -176,17 +232,25 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		if done {
 			r.state = requestStateParsingBody
 		}
+		r.headerBytes += n
 		return n, nil
 	case requestStateParsingBody:
-		contentLengthStr, exists := r.Headers.Get("Content-Length")
+		contentLength, exists, err := r.Headers.ContentLength()
+		if err != nil {
+			return 0, fmt.Errorf("malformed content-length: %v", err)
+		}
 		if !exists {
+			// no body, anything left over belongs to whatever comes after the request
 			r.state = requestStateDone
-			return len(data), nil
+			return 0, nil
 		}
-		contentLengthInt, err := strconv.Atoi(contentLengthStr)
-		if err != nil {
-			return 0, fmt.Errorf("malformed content-length: %v", err)
+		if r.limits.MaxBodyBytes > 0 && contentLength > r.limits.MaxBodyBytes {
+			return 0, ErrBodyTooLarge
+		}
+		if contentLength > math.MaxInt32 {
+			return 0, fmt.Errorf("content-length too large: %d", contentLength)
 		}
+		contentLengthInt := int(contentLength)
 		tmpBody := make([]byte, 0, contentLengthInt)
 		tmpBody = append(tmpBody, r.Body...)
 		remainingSpace := cap(tmpBody) - len(tmpBody)
@@ -2,6 +2,7 @@ package request
 
 import (
 	"io"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -220,3 +221,75 @@ func TestBodyParse(t *testing.T) {
 	require.NotNil(t, r)
 	assert.Equal(t, 0, len(r.Body))
 }
+
+func TestCookies(t *testing.T) {
+	// Test: cookies from Cookie header
+	reader := &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nCookie: session=abc123; theme=dark\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.Len(t, r.Cookies(), 2)
+	c, err := r.Cookie("theme")
+	require.NoError(t, err)
+	assert.Equal(t, "dark", c.Value)
+	_, err = r.Cookie("missing")
+	assert.ErrorIs(t, err, ErrNoCookie)
+
+	// Test: no Cookie header
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Empty(t, r.Cookies())
+}
+
+func TestBodyContentLength(t *testing.T) {
+	// Test: negative Content-Length
+	reader := &chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: -1\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err := RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: conflicting Content-Length lines
+	reader = &chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 4\r\nContent-Length: 5\r\n\r\nhello",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+}
+
+func TestLimits(t *testing.T) {
+	raw := "POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello"
+
+	// Test: requests within the limits parse as usual
+	r, err := ReadRequest(&chunkReader{data: raw, numBytesPerRead: 3}, Limits{MaxHeaderBytes: 100, MaxBodyBytes: 5})
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+
+	// Test: a header over the limit fails, even before it's complete
+	_, err = ReadRequest(&chunkReader{data: raw, numBytesPerRead: 3}, Limits{MaxHeaderBytes: 40})
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+	_, err = ReadRequest(&chunkReader{data: "GET /" + strings.Repeat("a", 100), numBytesPerRead: 7}, Limits{MaxHeaderBytes: 40})
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	// Test: a body over the limit fails on its Content-Length
+	_, err = ReadRequest(&chunkReader{data: raw, numBytesPerRead: 3}, Limits{MaxBodyBytes: 4})
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+}
+
+func TestIsIdempotent(t *testing.T) {
+	// Test: safe methods, PUT and DELETE can be repeated, POST and PATCH can't
+	for _, method := range []string{"GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE"} {
+		assert.True(t, IsIdempotent(method), method)
+	}
+	for _, method := range []string{"POST", "PATCH", "CONNECT", "get"} {
+		assert.False(t, IsIdempotent(method), method)
+	}
+}
@@ -1,10 +1,11 @@
 package response
 
 import (
-	"bytes"
+	"encoding/json"
 	"fmt"
-	"log"
-	"text/template"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
 )
 
 type responseBodyData struct {
@@ -13,19 +14,78 @@ type responseBodyData struct {
 	ResponseBodyContent string
 }
 
+type errorBody struct {
+	Status  int    `json:"status"`
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// error pages can be rendered in any of these, in order of preference
+var errorContentTypes = []string{"text/html", "application/json", "text/plain"}
+
 func BuildResponseBody(statusCode StatusCode, content string) []byte {
-	tmpl, err := template.ParseFiles("internal/response/templates/response_body.html")
-	if err != nil {
-		log.Fatal("failed to load template")
+	return defaultTemplates.mustRender(statusCode, content)
+}
+
+// BuildErrorBody renders an error message as HTML, JSON or plain text,
+// whichever the request's Accept header prefers, and returns the body with
+// its Content-Type. req may be nil when the request couldn't be parsed.
+func BuildErrorBody(req *request.Request, statusCode StatusCode, message string) ([]byte, string) {
+	return buildErrorBody(defaultTemplates, req, statusCode, message)
+}
+
+func buildErrorBody(templates *Templates, req *request.Request, statusCode StatusCode, message string) ([]byte, string) {
+	contentType := errorContentTypes[0]
+	if req != nil {
+		// an error page is better than a 406, so fall back to HTML if nothing matches
+		if n, err := Negotiate(req.Headers, Offer{ContentTypes: errorContentTypes}); err == nil {
+			contentType = n.ContentType
+		}
 	}
-	var bodyBuffer bytes.Buffer
-	respBody := responseBodyData{
-		ResponseBodyTitle:   fmt.Sprintf("%d %s", statusCode, getStatusDescription(statusCode)),
-		ResponseBodyHeader:  getStatusDescription(statusCode),
-		ResponseBodyContent: content,
+
+	switch contentType {
+	case "application/json":
+		body, err := json.Marshal(errorBody{
+			Status:  int(statusCode),
+			Error:   getStatusDescription(statusCode),
+			Message: message,
+		})
+		if err != nil {
+			body = []byte("{}")
+		}
+		return body, "application/json"
+	case "text/plain":
+		body := fmt.Sprintf("%d %s\n\n%s\n", statusCode, getStatusDescription(statusCode), message)
+		return []byte(body), "text/plain; charset=utf-8"
+	default:
+		return templates.mustRender(statusCode, message), "text/html; charset=utf-8"
+	}
+}
+
+func WriteError(w *Writer, req *request.Request, statusCode StatusCode, message string) error {
+	return WriteErrorWithHeaders(w, req, statusCode, message, nil)
+}
+
+// WriteErrorWithHeaders is WriteError with extra response headers, like
+// Content-Range on a 416 or Allow on a 405.
+func WriteErrorWithHeaders(w *Writer, req *request.Request, statusCode StatusCode, message string, extra headers.Headers) error {
+	body, contentType := buildErrorBody(w.templates(), req, statusCode, message)
+	h := GetDefaultHeaders(len(body))
+	for k, v := range extra {
+		h.Set(k, v)
+	}
+	h.Set("Content-Type", contentType)
+	AddVary(h, "Accept")
+	return writeFull(w, statusCode, h, body)
+}
+
+func writeFull(w *Writer, statusCode StatusCode, h headers.Headers, body []byte) error {
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
 	}
-	if err := tmpl.Execute(&bodyBuffer, respBody); err != nil {
-		log.Fatal("failed to execute template")
+	if err := w.WriteHeaders(h); err != nil {
+		return err
 	}
-	return bodyBuffer.Bytes()
+	_, err := w.WriteBody(body)
+	return err
 }
@@ -0,0 +1,190 @@
+package response
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+)
+
+type CompressionConfig struct {
+	// bodies smaller than this many bytes are sent uncompressed
+	MinSize int
+	// media types eligible for compression, "type/*" matches a whole type
+	ContentTypes []string
+	// passed to gzip/zlib, 0 means the default level
+	Level int
+}
+
+var DefaultCompressionConfig = CompressionConfig{
+	MinSize: 1024,
+	ContentTypes: []string{
+		"text/*",
+		"application/json",
+		"application/javascript",
+		"application/xml",
+		"image/svg+xml",
+	},
+}
+
+// media that is already compressed, or that has to stream unbuffered
+var incompressibleTypes = []string{
+	"text/event-stream",
+	"image/*",
+	"audio/*",
+	"video/*",
+	"application/gzip",
+	"application/zip",
+	"application/zstd",
+	"application/octet-stream",
+}
+
+// content codings we can produce, in order of preference
+var supportedEncodings = []string{"gzip", "deflate", "identity"}
+
+type compressor struct {
+	config   CompressionConfig
+	encoding string // "" when the client didn't accept a coding we produce
+	enc      io.WriteCloser
+}
+
+// EnableCompression makes the writer compress eligible responses with the
+// best content coding the request accepts. It must be called before the
+// headers are written.
+func (w *Writer) EnableCompression(req *request.Request, cfg CompressionConfig) error {
+	if w.responseState != responseStateInitialized && w.responseState != responseStateHeaders {
+		return fmt.Errorf("enable compression called after headers were written")
+	}
+	if cfg.Level == 0 {
+		cfg.Level = gzip.DefaultCompression
+	}
+	c := &compressor{config: cfg}
+	if req.RequestLine.Method != "HEAD" {
+		n, err := Negotiate(req.Headers, Offer{Encodings: supportedEncodings})
+		if err == nil && n.Encoding != "identity" {
+			c.encoding = n.Encoding
+		}
+	}
+	w.compressor = c
+	return nil
+}
+
+// prepare decides whether a response is eligible for compression and updates
+// its headers. It returns true if the body should go through the compressor.
+func (c *compressor) prepare(statusCode StatusCode, h headers.Headers) bool {
+	if statusCode < 200 || statusCode == 204 || statusCode == 206 || statusCode == 304 {
+		return false
+	}
+	if _, ok := h.Get("Content-Encoding"); ok {
+		return false
+	}
+	if _, ok := h.Get("Content-Range"); ok {
+		return false
+	}
+	mediaType, _, err := h.ContentType()
+	if err != nil || !c.compressible(mediaType) {
+		return false
+	}
+	if length, ok, err := h.ContentLength(); ok && err == nil && length < int64(c.config.MinSize) && !isChunked(h) {
+		return false
+	}
+
+	// the representation depends on Accept-Encoding even when we don't compress it
+	AddVary(h, "Accept-Encoding")
+	if c.encoding == "" {
+		return false
+	}
+	if isChunked(h) {
+		h.Set("Content-Encoding", c.encoding)
+		h.Delete("Content-Length")
+		weakenETag(h)
+	}
+	return true
+}
+
+func (c *compressor) start(dst io.Writer) error {
+	enc, err := c.newEncoder(dst)
+	if err != nil {
+		return err
+	}
+	c.enc = enc
+	return nil
+}
+
+func (c *compressor) active() bool {
+	return c.enc != nil
+}
+
+func (c *compressor) Write(p []byte) (int, error) {
+	return c.enc.Write(p)
+}
+
+func (c *compressor) flush() error {
+	if f, ok := c.enc.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func (c *compressor) close() error {
+	err := c.enc.Close()
+	c.enc = nil
+	return err
+}
+
+func (c *compressor) compress(p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	enc, err := c.newEncoder(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := enc.Write(p); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *compressor) newEncoder(dst io.Writer) (io.WriteCloser, error) {
+	switch c.encoding {
+	case "gzip":
+		return gzip.NewWriterLevel(dst, c.config.Level)
+	case "deflate":
+		// "deflate" in HTTP is the zlib format, not a raw deflate stream
+		return zlib.NewWriterLevel(dst, c.config.Level)
+	default:
+		return nil, fmt.Errorf("unsupported content coding: %s", c.encoding)
+	}
+}
+
+func (c *compressor) compressible(mediaType string) bool {
+	for _, t := range c.config.ContentTypes {
+		// an exact entry wins over the incompressible wildcards, e.g. image/svg+xml
+		if t == mediaType {
+			return true
+		}
+	}
+	return matchMediaType(mediaType, c.config.ContentTypes) && !matchMediaType(mediaType, incompressibleTypes)
+}
+
+func matchMediaType(mediaType string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+			continue
+		}
+		if mediaType == pattern {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRequest(t *testing.T, raw string) *request.Request {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return req
+}
+
+// splitResponse returns the header block and the body of a raw response
+func splitResponse(t *testing.T, raw string) (headers.Headers, string) {
+	t.Helper()
+	_, rest, ok := strings.Cut(raw, "\r\n")
+	require.True(t, ok)
+	h := headers.NewHeaders()
+	for {
+		n, done, err := h.Parse([]byte(rest))
+		require.NoError(t, err)
+		rest = rest[n:]
+		if done {
+			break
+		}
+	}
+	return h, rest
+}
+
+func TestCompressFullBody(t *testing.T) {
+	body := strings.Repeat("hello compression ", 200)
+	req := newTestRequest(t, "GET / HTTP/1.1\r\nHost: localhost:42069\r\nAccept-Encoding: gzip, deflate\r\n\r\n")
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.EnableCompression(req, DefaultCompressionConfig))
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	h := GetDefaultHeaders(len(body))
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	require.NoError(t, w.WriteHeaders(h))
+	n, err := w.WriteBody([]byte(body))
+	require.NoError(t, err)
+	assert.Equal(t, len(body), n)
+
+	respHeaders, respBody := splitResponse(t, buf.String())
+	encoding, _ := respHeaders.Get("Content-Encoding")
+	assert.Equal(t, "gzip", encoding)
+	vary, _ := respHeaders.Get("Vary")
+	assert.Equal(t, "Accept-Encoding", vary)
+	length, _ := respHeaders.Get("Content-Length")
+	assert.Equal(t, strconv.Itoa(len(respBody)), length)
+	assert.Less(t, len(respBody), len(body))
+
+	zr, err := gzip.NewReader(strings.NewReader(respBody))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(decoded))
+}
+
+func TestCompressSkipped(t *testing.T) {
+	req := newTestRequest(t, "GET / HTTP/1.1\r\nHost: localhost:42069\r\nAccept-Encoding: gzip\r\n\r\n")
+	write := func(contentType, body string) string {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		require.NoError(t, w.EnableCompression(req, DefaultCompressionConfig))
+		require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+		h := GetDefaultHeaders(len(body))
+		h.Set("Content-Type", contentType)
+		require.NoError(t, w.WriteHeaders(h))
+		_, err := w.WriteBody([]byte(body))
+		require.NoError(t, err)
+		return buf.String()
+	}
+
+	// Test: body under the threshold
+	out := write("text/plain", "tiny")
+	assert.NotContains(t, out, "content-encoding")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\ntiny"))
+
+	// Test: already compressed media
+	out = write("video/mp4", strings.Repeat("x", 4096))
+	assert.NotContains(t, out, "content-encoding")
+	assert.NotContains(t, out, "vary")
+
+	// Test: client doesn't accept any coding we produce
+	req = newTestRequest(t, "GET / HTTP/1.1\r\nHost: localhost:42069\r\nAccept-Encoding: br\r\n\r\n")
+	out = write("text/plain", strings.Repeat("x", 4096))
+	assert.NotContains(t, out, "content-encoding")
+	assert.Contains(t, out, "vary: Accept-Encoding\r\n")
+}
+
+func TestCompressChunked(t *testing.T) {
+	req := newTestRequest(t, "GET / HTTP/1.1\r\nHost: localhost:42069\r\nAccept-Encoding: deflate\r\n\r\n")
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.EnableCompression(req, DefaultCompressionConfig))
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	h := GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+	for i := 0; i < 10; i++ {
+		_, err := w.WriteChunkedBody([]byte(`{"hello":"world"}`))
+		require.NoError(t, err)
+	}
+	_, err := w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.NewHeaders()))
+
+	respHeaders, respBody := splitResponse(t, buf.String())
+	encoding, _ := respHeaders.Get("Content-Encoding")
+	assert.Equal(t, "deflate", encoding)
+	_, ok := respHeaders.Get("Content-Length")
+	assert.False(t, ok)
+
+	// de-chunk the body and inflate it
+	var compressed bytes.Buffer
+	r := bufio.NewReader(strings.NewReader(respBody))
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+		require.NoError(t, err)
+		if size == 0 {
+			break
+		}
+		_, err = io.CopyN(&compressed, r, size)
+		require.NoError(t, err)
+		_, err = r.Discard(2)
+		require.NoError(t, err)
+	}
+	zr, err := zlib.NewReader(&compressed)
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat(`{"hello":"world"}`, 10), string(decoded))
+}
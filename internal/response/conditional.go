@@ -0,0 +1,110 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+)
+
+// headers a 304 has to repeat from the 200 it stands in for (RFC 9110 15.4.5)
+var notModifiedHeaders = []string{"Cache-Control", "Content-Location", "Date", "ETag", "Expires", "Last-Modified", "Vary"}
+
+// StrongETag derives a strong entity tag from the full representation.
+func StrongETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// WeakETag derives a weak entity tag from file metadata, cheap enough for
+// large files that would be expensive to hash.
+func WeakETag(modTime time.Time, size int64) string {
+	return fmt.Sprintf(`W/"%x-%x"`, modTime.UnixNano(), size)
+}
+
+// CheckPreconditions evaluates If-Match, If-Unmodified-Since, If-None-Match
+// and If-Modified-Since, in the order RFC 9110 13.2.2 requires, against the
+// ETag and Last-Modified in the response headers h. When the request can be
+// answered without the representation it writes a 304 or 412 and returns
+// true, and the caller must not write anything else.
+func CheckPreconditions(w *Writer, req *request.Request, h headers.Headers) bool {
+	method := req.RequestLine.Method
+	etag, hasETag := h.Get("ETag")
+	lastModified, hasLastModified, err := h.GetTime("Last-Modified")
+	hasLastModified = hasLastModified && err == nil
+
+	if ifMatch, ok := req.Headers.Get("If-Match"); ok {
+		if !etagListMatches(ifMatch, etag, hasETag, true) {
+			WriteError(w, req, StatusCodePreconditionFailed, "The resource has changed since it was last fetched.")
+			return true
+		}
+	} else if since, ok, err := req.Headers.GetTime("If-Unmodified-Since"); ok && err == nil && hasLastModified {
+		if lastModified.Truncate(time.Second).After(since) {
+			WriteError(w, req, StatusCodePreconditionFailed, "The resource has changed since it was last fetched.")
+			return true
+		}
+	}
+
+	if ifNoneMatch, ok := req.Headers.Get("If-None-Match"); ok {
+		if etagListMatches(ifNoneMatch, etag, hasETag, false) {
+			if method == "GET" || method == "HEAD" {
+				writeNotModified(w, h)
+			} else {
+				WriteError(w, req, StatusCodePreconditionFailed, "The resource already exists.")
+			}
+			return true
+		}
+	} else if since, ok, err := req.Headers.GetTime("If-Modified-Since"); ok && err == nil && hasLastModified && (method == "GET" || method == "HEAD") {
+		if !lastModified.Truncate(time.Second).After(since) {
+			writeNotModified(w, h)
+			return true
+		}
+	}
+	return false
+}
+
+func writeNotModified(w *Writer, h headers.Headers) {
+	notModified := headers.NewHeaders()
+	notModified.Set("Connection", "close")
+	for _, name := range notModifiedHeaders {
+		if v, ok := h.Get(name); ok {
+			notModified.Set(name, v)
+		}
+	}
+	w.WriteStatusLine(StatusCodeNotModified)
+	w.WriteHeaders(notModified)
+}
+
+// etagListMatches reports whether the current etag is in an If-Match or
+// If-None-Match list. "*" matches any current representation, which is
+// always the case for the responses we evaluate.
+func etagListMatches(list, etag string, hasETag, strong bool) bool {
+	for _, candidate := range headers.ParseList(list) {
+		if candidate == "*" {
+			return true
+		}
+		if hasETag && etagsEqual(candidate, etag, strong) {
+			return true
+		}
+	}
+	return false
+}
+
+func etagsEqual(a, b string, strong bool) bool {
+	if strong && (strings.HasPrefix(a, "W/") || strings.HasPrefix(b, "W/")) {
+		return false
+	}
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// weakenETag marks a strong ETag as weak, since a content-coded response
+// isn't byte-for-byte the representation the strong tag was computed from.
+func weakenETag(h headers.Headers) {
+	if etag, ok := h.Get("ETag"); ok && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+}
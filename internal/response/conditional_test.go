@@ -0,0 +1,94 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func checkPreconditions(t *testing.T, method string, reqHeaders map[string]string) (bool, string) {
+	t.Helper()
+	raw := method + " / HTTP/1.1\r\nHost: localhost\r\n"
+	for k, v := range reqHeaders {
+		raw += k + ": " + v + "\r\n"
+	}
+	req := newTestRequest(t, raw+"\r\n")
+	h := headers.NewHeaders()
+	h.Set("ETag", `"v2"`)
+	h.Set("Cache-Control", "max-age=60")
+	h.SetTime("Last-Modified", time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	var buf bytes.Buffer
+	done := CheckPreconditions(NewWriter(&buf), req, h)
+	statusLine, _, _ := strings.Cut(buf.String(), "\r\n")
+	return done, statusLine
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	// Test: no conditional headers
+	done, _ := checkPreconditions(t, "GET", nil)
+	assert.False(t, done)
+
+	// Test: If-None-Match hit uses weak comparison
+	done, status := checkPreconditions(t, "GET", map[string]string{"If-None-Match": `"v1", W/"v2"`})
+	assert.True(t, done)
+	assert.Equal(t, "HTTP/1.1 304 Not Modified", status)
+
+	// Test: If-None-Match on an unsafe method fails the precondition
+	done, status = checkPreconditions(t, "PUT", map[string]string{"If-None-Match": "*"})
+	assert.True(t, done)
+	assert.Equal(t, "HTTP/1.1 412 Precondition Failed", status)
+
+	// Test: If-None-Match miss wins over a matching If-Modified-Since
+	done, _ = checkPreconditions(t, "GET", map[string]string{
+		"If-None-Match":     `"v1"`,
+		"If-Modified-Since": "Wed, 01 May 2024 12:00:00 GMT",
+	})
+	assert.False(t, done)
+
+	// Test: If-Modified-Since
+	done, status = checkPreconditions(t, "GET", map[string]string{"If-Modified-Since": "Wed, 01 May 2024 12:00:00 GMT"})
+	assert.True(t, done)
+	assert.Equal(t, "HTTP/1.1 304 Not Modified", status)
+	done, _ = checkPreconditions(t, "GET", map[string]string{"If-Modified-Since": "Tue, 30 Apr 2024 12:00:00 GMT"})
+	assert.False(t, done)
+
+	// Test: If-Match uses strong comparison
+	done, _ = checkPreconditions(t, "PUT", map[string]string{"If-Match": `"v2"`})
+	assert.False(t, done)
+	done, status = checkPreconditions(t, "PUT", map[string]string{"If-Match": `W/"v2"`})
+	assert.True(t, done)
+	assert.Equal(t, "HTTP/1.1 412 Precondition Failed", status)
+
+	// Test: If-Match takes precedence over If-Unmodified-Since
+	done, _ = checkPreconditions(t, "PUT", map[string]string{
+		"If-Match":            `"v2"`,
+		"If-Unmodified-Since": "Tue, 30 Apr 2024 12:00:00 GMT",
+	})
+	assert.False(t, done)
+
+	// Test: If-Unmodified-Since
+	done, status = checkPreconditions(t, "DELETE", map[string]string{"If-Unmodified-Since": "Tue, 30 Apr 2024 12:00:00 GMT"})
+	assert.True(t, done)
+	assert.Equal(t, "HTTP/1.1 412 Precondition Failed", status)
+}
+
+func TestServeBytesNotModified(t *testing.T) {
+	body := []byte("cache me")
+	etag := StrongETag(body)
+	req := newTestRequest(t, "GET / HTTP/1.1\r\nHost: localhost\r\nIf-None-Match: "+etag+"\r\n\r\n")
+	var buf bytes.Buffer
+	h := headers.NewHeaders()
+	h.Set("Cache-Control", "no-cache")
+	require.NoError(t, ServeBytes(NewWriter(&buf), req, h, body))
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.Contains(t, out, "etag: "+etag+"\r\n")
+	assert.Contains(t, out, "cache-control: no-cache\r\n")
+	assert.NotContains(t, out, "content-length")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+}
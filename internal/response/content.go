@@ -0,0 +1,162 @@
+package response
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+)
+
+// ServeContent writes content as the response to req, honoring conditional
+// requests, Range and If-Range. h holds the response headers the caller wants sent, such as
+// Content-Type, ETag or Last-Modified; framing headers are set here.
+func ServeContent(w *Writer, req *request.Request, h headers.Headers, content io.ReadSeeker) error {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to find content size: %w", err)
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind content: %w", err)
+	}
+	if _, ok := h.Get("Content-Type"); !ok {
+		h.Set("Content-Type", "application/octet-stream")
+	}
+	h.Set("Accept-Ranges", "bytes")
+	if CheckPreconditions(w, req, h) {
+		return nil
+	}
+
+	method := req.RequestLine.Method
+	if rangeHeader, ok := req.Headers.Get("Range"); ok && method == "GET" && ifRangeMatches(req, h) {
+		ranges, err := ParseRange(rangeHeader, size)
+		switch {
+		case errors.Is(err, ErrUnsatisfiableRange):
+			extra := headers.NewHeaders()
+			extra.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			return WriteErrorWithHeaders(w, req, StatusCodeRangeNotSatisfiable, "The requested range is not satisfiable.", extra)
+		case err != nil:
+			// a malformed Range is ignored and the whole representation sent
+		case sumRanges(ranges) > size:
+			// overlapping ranges asking for more than the whole thing aren't worth honoring
+		case len(ranges) == 1:
+			return serveRange(w, method, h, content, ranges[0], size)
+		default:
+			return serveMultipartRanges(w, h, content, ranges, size)
+		}
+	}
+
+	h.Set("Content-Length", fmt.Sprintf("%d", size))
+	if err := w.WriteStatusLine(StatusCodeSuccess); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	if method == "HEAD" {
+		return nil
+	}
+	_, err = w.WriteBodyFrom(content)
+	return err
+}
+
+// ServeBytes is ServeContent for an in-memory body, tagged with a strong
+// ETag unless the caller already set one.
+func ServeBytes(w *Writer, req *request.Request, h headers.Headers, body []byte) error {
+	if _, ok := h.Get("ETag"); !ok {
+		h.Set("ETag", StrongETag(body))
+	}
+	return ServeContent(w, req, h, bytes.NewReader(body))
+}
+
+func serveRange(w *Writer, method string, h headers.Headers, content io.ReadSeeker, r ByteRange, size int64) error {
+	if _, err := content.Seek(r.Start, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to range: %w", err)
+	}
+	h.Set("Content-Range", r.contentRange(size))
+	h.Set("Content-Length", fmt.Sprintf("%d", r.Length))
+	if err := w.WriteStatusLine(StatusCodePartialContent); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	if method == "HEAD" {
+		return nil
+	}
+	_, err := w.WriteBodyFrom(io.LimitReader(content, r.Length))
+	return err
+}
+
+func serveMultipartRanges(w *Writer, h headers.Headers, content io.ReadSeeker, ranges []ByteRange, size int64) error {
+	boundary, err := randomBoundary()
+	if err != nil {
+		return err
+	}
+	contentType, _ := h.Get("Content-Type")
+
+	// build the part headers up front so the total length is known
+	readers := []io.Reader{}
+	var length int64
+	for i, r := range ranges {
+		partHeader := fmt.Sprintf("--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n", boundary, contentType, r.contentRange(size))
+		if i > 0 {
+			partHeader = "\r\n" + partHeader
+		}
+		readers = append(readers, strings.NewReader(partHeader), &rangeReader{content: content, r: r})
+		length += int64(len(partHeader)) + r.Length
+	}
+	closing := fmt.Sprintf("\r\n--%s--\r\n", boundary)
+	readers = append(readers, strings.NewReader(closing))
+	length += int64(len(closing))
+
+	h.Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	h.Set("Content-Length", fmt.Sprintf("%d", length))
+	if err := w.WriteStatusLine(StatusCodePartialContent); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	_, err = w.WriteBodyFrom(io.MultiReader(readers...))
+	return err
+}
+
+// rangeReader seeks to its range on the first read, so several of them can
+// share one io.ReadSeeker as long as they're read in sequence.
+type rangeReader struct {
+	content io.ReadSeeker
+	r       ByteRange
+	limited io.Reader
+}
+
+func (rr *rangeReader) Read(p []byte) (int, error) {
+	if rr.limited == nil {
+		if _, err := rr.content.Seek(rr.r.Start, io.SeekStart); err != nil {
+			return 0, err
+		}
+		rr.limited = io.LimitReader(rr.content, rr.r.Length)
+	}
+	return rr.limited.Read(p)
+}
+
+func sumRanges(ranges []ByteRange) int64 {
+	var total int64
+	for _, r := range ranges {
+		total += r.Length
+	}
+	return total
+}
+
+func randomBoundary() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate boundary: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
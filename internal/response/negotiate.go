@@ -0,0 +1,204 @@
+package response
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+)
+
+var ErrNotAcceptable = errors.New("no acceptable representation")
+
+// Offer lists the representations a handler can produce, in order of
+// preference. An empty dimension isn't negotiated.
+type Offer struct {
+	ContentTypes []string
+	Languages    []string
+	Encodings    []string
+}
+
+type Negotiated struct {
+	ContentType string
+	Language    string
+	Encoding    string
+	Vary        []string
+}
+
+func Negotiate(reqHeaders headers.Headers, offer Offer) (Negotiated, error) {
+	result := Negotiated{Vary: []string{}}
+	if reqHeaders == nil {
+		reqHeaders = headers.NewHeaders()
+	}
+
+	if len(offer.ContentTypes) > 0 {
+		result.Vary = append(result.Vary, "Accept")
+		contentType, ok := negotiateContentType(reqHeaders, offer.ContentTypes)
+		if !ok {
+			return result, ErrNotAcceptable
+		}
+		result.ContentType = contentType
+	}
+	if len(offer.Languages) > 0 {
+		result.Vary = append(result.Vary, "Accept-Language")
+		language, ok := negotiateLanguage(reqHeaders, offer.Languages)
+		if !ok {
+			return result, ErrNotAcceptable
+		}
+		result.Language = language
+	}
+	if len(offer.Encodings) > 0 {
+		result.Vary = append(result.Vary, "Accept-Encoding")
+		encoding, ok := negotiateEncoding(reqHeaders, offer.Encodings)
+		if !ok {
+			return result, ErrNotAcceptable
+		}
+		result.Encoding = encoding
+	}
+	return result, nil
+}
+
+// NegotiateResponse negotiates against the request and answers 406 Not
+// Acceptable itself when nothing matches. Handlers should return when ok is false.
+func NegotiateResponse(w *Writer, req *request.Request, offer Offer) (Negotiated, bool) {
+	result, err := Negotiate(req.Headers, offer)
+	if err != nil {
+		WriteError(w, req, StatusCodeNotAcceptable, "None of the available representations are acceptable.")
+		return result, false
+	}
+	return result, true
+}
+
+// Apply sets the negotiated Content-Language, Content-Encoding and Vary on
+// response headers. Content-Type is left to the caller since it may need params.
+func (n Negotiated) Apply(h headers.Headers) {
+	if n.Language != "" {
+		h.Set("Content-Language", n.Language)
+	}
+	if n.Encoding != "" && n.Encoding != "identity" {
+		h.Set("Content-Encoding", n.Encoding)
+	}
+	AddVary(h, n.Vary...)
+}
+
+// AddVary merges field names into the Vary header without duplicating them.
+func AddVary(h headers.Headers, fields ...string) {
+	existing := h.Values("Vary")
+	for _, field := range fields {
+		found := false
+		for _, e := range existing {
+			if e == "*" || strings.EqualFold(e, field) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			existing = append(existing, field)
+		}
+	}
+	if len(existing) > 0 {
+		h.Set("Vary", strings.Join(existing, ", "))
+	}
+}
+
+func negotiateContentType(reqHeaders headers.Headers, offers []string) (string, bool) {
+	if _, ok := reqHeaders.Get("Accept"); !ok {
+		return offers[0], true
+	}
+	accepted := reqHeaders.QualityValues("Accept")
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		offerType, offerSubtype, _ := strings.Cut(strings.ToLower(offer), "/")
+		// the most specific matching range decides the q-value for this offer
+		q, specificity := 0.0, -1
+		for _, a := range accepted {
+			rangeType, rangeSubtype, _ := strings.Cut(strings.ToLower(a.Value), "/")
+			s := -1
+			switch {
+			case rangeType == offerType && rangeSubtype == offerSubtype:
+				s = 2
+			case rangeType == offerType && rangeSubtype == "*":
+				s = 1
+			case rangeType == "*" && rangeSubtype == "*":
+				s = 0
+			}
+			if s > specificity {
+				q, specificity = a.Q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+func negotiateLanguage(reqHeaders headers.Headers, offers []string) (string, bool) {
+	if _, ok := reqHeaders.Get("Accept-Language"); !ok {
+		return offers[0], true
+	}
+	accepted := reqHeaders.QualityValues("Accept-Language")
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		// basic filtering from RFC 4647: "en" matches "en" and "en-US"
+		q, specificity := 0.0, -1
+		for _, a := range accepted {
+			s := -1
+			switch {
+			case strings.EqualFold(a.Value, offer):
+				s = len(a.Value) + 1
+			case len(offer) > len(a.Value) && strings.EqualFold(offer[:len(a.Value)], a.Value) && offer[len(a.Value)] == '-':
+				s = len(a.Value)
+			case a.Value == "*":
+				s = 0
+			}
+			if s > specificity {
+				q, specificity = a.Q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+func negotiateEncoding(reqHeaders headers.Headers, offers []string) (string, bool) {
+	if _, ok := reqHeaders.Get("Accept-Encoding"); !ok {
+		// any encoding is technically acceptable, but identity is the safe choice
+		for _, offer := range offers {
+			if offer == "identity" {
+				return offer, true
+			}
+		}
+		return offers[0], true
+	}
+	accepted := reqHeaders.QualityValues("Accept-Encoding")
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, specificity := -1.0, -1
+		for _, a := range accepted {
+			s := -1
+			switch {
+			case strings.EqualFold(a.Value, offer):
+				s = 1
+			case a.Value == "*":
+				s = 0
+			}
+			if s > specificity {
+				q, specificity = a.Q, s
+			}
+		}
+		if q < 0 {
+			// identity is acceptable unless explicitly excluded, anything else unless listed isn't
+			q = 0
+			if offer == "identity" {
+				q = 0.001
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best, bestQ > 0
+}
@@ -0,0 +1,95 @@
+package response
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	offer := Offer{
+		ContentTypes: []string{"text/html", "application/json"},
+		Languages:    []string{"en", "fr-CA"},
+		Encodings:    []string{"gzip", "identity"},
+	}
+
+	// Test: no Accept headers picks the first offers
+	n, err := Negotiate(headers.NewHeaders(), offer)
+	require.NoError(t, err)
+	assert.Equal(t, "text/html", n.ContentType)
+	assert.Equal(t, "en", n.Language)
+	assert.Equal(t, "identity", n.Encoding)
+	assert.Equal(t, []string{"Accept", "Accept-Language", "Accept-Encoding"}, n.Vary)
+
+	// Test: q-values and specificity
+	h := headers.NewHeaders()
+	h.Set("Accept", "text/*;q=0.3, application/json;q=0.8, */*;q=0.1")
+	h.Set("Accept-Language", "fr;q=0.9, en;q=0.5")
+	h.Set("Accept-Encoding", "gzip, deflate")
+	n, err = Negotiate(h, offer)
+	require.NoError(t, err)
+	assert.Equal(t, "application/json", n.ContentType)
+	assert.Equal(t, "fr-CA", n.Language)
+	assert.Equal(t, "gzip", n.Encoding)
+
+	// Test: more specific range excludes an offer
+	h = headers.NewHeaders()
+	h.Set("Accept", "*/*, text/html;q=0")
+	n, err = Negotiate(h, offer)
+	require.NoError(t, err)
+	assert.Equal(t, "application/json", n.ContentType)
+
+	// Test: nothing acceptable
+	h = headers.NewHeaders()
+	h.Set("Accept", "image/png")
+	_, err = Negotiate(h, offer)
+	assert.ErrorIs(t, err, ErrNotAcceptable)
+
+	// Test: identity excluded
+	h = headers.NewHeaders()
+	h.Set("Accept-Encoding", "br, identity;q=0")
+	_, err = Negotiate(h, Offer{Encodings: []string{"identity"}})
+	assert.ErrorIs(t, err, ErrNotAcceptable)
+}
+
+func TestAddVary(t *testing.T) {
+	h := headers.NewHeaders()
+	h.Set("Vary", "accept")
+	AddVary(h, "Accept", "Accept-Encoding")
+	v, _ := h.Get("Vary")
+	assert.Equal(t, "accept, Accept-Encoding", v)
+}
+
+func TestNegotiateResponse(t *testing.T) {
+	// Test: 406 rendered in a representation the client accepts
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\nAccept: application/json\r\n\r\n"))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	_, ok := NegotiateResponse(w, req, Offer{ContentTypes: []string{"text/csv"}})
+	assert.False(t, ok)
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 406 Not Acceptable\r\n"))
+	assert.Contains(t, out, "content-type: application/json\r\n")
+	assert.Contains(t, out, "vary: Accept\r\n")
+
+	_, body, _ := strings.Cut(out, "\r\n\r\n")
+	var decoded errorBody
+	require.NoError(t, json.Unmarshal([]byte(body), &decoded))
+	assert.Equal(t, 406, decoded.Status)
+	assert.Equal(t, "Not Acceptable", decoded.Error)
+}
+
+func TestBuildErrorBodyPlainText(t *testing.T) {
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\nAccept: text/plain\r\n\r\n"))
+	require.NoError(t, err)
+	body, contentType := BuildErrorBody(req, StatusCodeBadRequest, "nope")
+	assert.Equal(t, "text/plain; charset=utf-8", contentType)
+	assert.Equal(t, "400 Bad Request\n\nnope\n", string(body))
+}
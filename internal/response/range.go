@@ -0,0 +1,120 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+)
+
+var ErrUnsatisfiableRange = errors.New("range not satisfiable")
+
+// ByteRange is a resolved range of Length bytes starting at Start.
+type ByteRange struct {
+	Start  int64
+	Length int64
+}
+
+func (r ByteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.Start+r.Length-1, size)
+}
+
+// ParseRange parses a Range header against a representation of size bytes.
+// It returns ErrUnsatisfiableRange if no range overlaps the representation,
+// and any other error if the header is malformed, in which case it should be
+// ignored and the full representation served.
+func ParseRange(value string, size int64) ([]ByteRange, error) {
+	unit, set, ok := strings.Cut(value, "=")
+	if !ok || strings.TrimSpace(unit) != "bytes" {
+		return nil, fmt.Errorf("unsupported range unit: %s", value)
+	}
+	ranges := []ByteRange{}
+	specs := headers.ParseList(set)
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("empty range set: %s", value)
+	}
+	for _, spec := range specs {
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid range: %s", spec)
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+		var r ByteRange
+		if first == "" {
+			// suffix range, the last n bytes
+			n, err := parseRangeInt(last)
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r = ByteRange{Start: size - n, Length: n}
+		} else {
+			start, err := parseRangeInt(first)
+			if err != nil {
+				return nil, err
+			}
+			end := size - 1
+			if last != "" {
+				end, err = parseRangeInt(last)
+				if err != nil {
+					return nil, err
+				}
+				if end < start {
+					return nil, fmt.Errorf("invalid range: %s", spec)
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			if start >= size {
+				continue
+			}
+			r = ByteRange{Start: start, Length: end - start + 1}
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, ErrUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+func parseRangeInt(s string) (int64, error) {
+	if s == "" || s[0] < '0' || s[0] > '9' {
+		return 0, fmt.Errorf("invalid range position: %q", s)
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid range position: %q", s)
+	}
+	return n, nil
+}
+
+// ifRangeMatches reports whether a Range header should be honored given the
+// request's If-Range and the validators on the response headers.
+func ifRangeMatches(req *request.Request, h headers.Headers) bool {
+	value, ok := req.Headers.Get("If-Range")
+	if !ok {
+		return true
+	}
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "W/") {
+		etag, ok := h.Get("ETag")
+		// If-Range needs a strong comparison, so weak tags never match
+		return ok && !strings.HasPrefix(value, "W/") && value == etag
+	}
+	since, err := headers.ParseHTTPDate(value)
+	if err != nil {
+		return false
+	}
+	lastModified, ok, err := h.GetTime("Last-Modified")
+	return ok && err == nil && lastModified.Equal(since)
+}
@@ -0,0 +1,137 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	// Test: single, open-ended and suffix ranges
+	ranges, err := ParseRange("bytes=0-4, 10-, -3", 20)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 5}, {Start: 10, Length: 10}, {Start: 17, Length: 3}}, ranges)
+
+	// Test: end past the size is clamped
+	ranges, err = ParseRange("bytes=15-100", 20)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 15, Length: 5}}, ranges)
+
+	// Test: suffix larger than the size
+	ranges, err = ParseRange("bytes=-50", 20)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 20}}, ranges)
+
+	// Test: unsatisfiable
+	_, err = ParseRange("bytes=20-30", 20)
+	assert.ErrorIs(t, err, ErrUnsatisfiableRange)
+	_, err = ParseRange("bytes=-0", 20)
+	assert.ErrorIs(t, err, ErrUnsatisfiableRange)
+
+	// Test: malformed
+	for _, v := range []string{"items=0-1", "bytes=5-1", "bytes=a-b", "bytes=", "bytes=-", "bytes=+1-2"} {
+		_, err = ParseRange(v, 20)
+		assert.Error(t, err, v)
+		assert.NotErrorIs(t, err, ErrUnsatisfiableRange, v)
+	}
+}
+
+func serveTestContent(t *testing.T, rawRequest string, h headers.Headers) (string, headers.Headers, string) {
+	t.Helper()
+	req := newTestRequest(t, rawRequest)
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if h == nil {
+		h = headers.NewHeaders()
+	}
+	h.Set("Content-Type", "text/plain")
+	require.NoError(t, ServeContent(w, req, h, strings.NewReader("0123456789abcdefghij")))
+	statusLine, _, _ := strings.Cut(buf.String(), "\r\n")
+	respHeaders, body := splitResponse(t, buf.String())
+	return statusLine, respHeaders, body
+}
+
+func TestServeContent(t *testing.T) {
+	// Test: full content advertises ranges
+	status, h, body := serveTestContent(t, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", nil)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "0123456789abcdefghij", body)
+	v, _ := h.Get("Accept-Ranges")
+	assert.Equal(t, "bytes", v)
+	v, _ = h.Get("Content-Length")
+	assert.Equal(t, "20", v)
+
+	// Test: single range
+	status, h, body = serveTestContent(t, "GET / HTTP/1.1\r\nHost: localhost\r\nRange: bytes=5-9\r\n\r\n", nil)
+	assert.Equal(t, "HTTP/1.1 206 Partial Content", status)
+	assert.Equal(t, "56789", body)
+	v, _ = h.Get("Content-Range")
+	assert.Equal(t, "bytes 5-9/20", v)
+	v, _ = h.Get("Content-Length")
+	assert.Equal(t, "5", v)
+
+	// Test: unsatisfiable range
+	status, h, _ = serveTestContent(t, "GET / HTTP/1.1\r\nHost: localhost\r\nRange: bytes=50-\r\n\r\n", nil)
+	assert.Equal(t, "HTTP/1.1 416 Range Not Satisfiable", status)
+	v, _ = h.Get("Content-Range")
+	assert.Equal(t, "bytes */20", v)
+
+	// Test: malformed range is ignored
+	status, _, body = serveTestContent(t, "GET / HTTP/1.1\r\nHost: localhost\r\nRange: bytes=9-1\r\n\r\n", nil)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "0123456789abcdefghij", body)
+
+	// Test: If-Range with a matching and a stale ETag
+	etag := headers.NewHeaders()
+	etag.Set("ETag", `"v1"`)
+	status, _, body = serveTestContent(t, "GET / HTTP/1.1\r\nHost: localhost\r\nRange: bytes=0-1\r\nIf-Range: \"v1\"\r\n\r\n", etag)
+	assert.Equal(t, "HTTP/1.1 206 Partial Content", status)
+	assert.Equal(t, "01", body)
+	etag = headers.NewHeaders()
+	etag.Set("ETag", `"v2"`)
+	status, _, _ = serveTestContent(t, "GET / HTTP/1.1\r\nHost: localhost\r\nRange: bytes=0-1\r\nIf-Range: \"v1\"\r\n\r\n", etag)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+
+	// Test: HEAD sends no body
+	status, h, body = serveTestContent(t, "HEAD / HTTP/1.1\r\nHost: localhost\r\n\r\n", nil)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Empty(t, body)
+	v, _ = h.Get("Content-Length")
+	assert.Equal(t, "20", v)
+}
+
+func TestServeContentMultipart(t *testing.T) {
+	status, h, body := serveTestContent(t, "GET / HTTP/1.1\r\nHost: localhost\r\nRange: bytes=0-2, -2\r\n\r\n", nil)
+	assert.Equal(t, "HTTP/1.1 206 Partial Content", status)
+	v, _ := h.Get("Content-Length")
+	assert.Equal(t, strconv.Itoa(len(body)), v)
+	contentType, _ := h.Get("Content-Type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+
+	mr := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	want := []struct{ contentRange, data string }{
+		{"bytes 0-2/20", "012"},
+		{"bytes 18-19/20", "ij"},
+	}
+	for _, w := range want {
+		part, err := mr.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, w.contentRange, part.Header.Get("Content-Range"))
+		assert.Equal(t, "text/plain", part.Header.Get("Content-Type"))
+		data, err := io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, w.data, string(data))
+	}
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+}
@@ -1,27 +1,107 @@
 package response
 
 import (
+	"fmt"
 	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
 )
 
 type Writer struct {
-	writer        io.Writer
+	// conn is what the writer was created with, writer counts what goes to it
+	conn          io.Writer
+	writer        *countingWriter
 	responseState ResponseState
+	// serialized Set-Cookie values, each sent on its own line
+	cookies    []string
+	errorPages *Templates
+	statusCode StatusCode
+	compressor *compressor
+	// headers held back until the body length is known
+	pendingHeaders headers.Headers
+	// headers added to whatever the handler writes
+	extraHeaders headers.Headers
+	// bytes written before the body started
+	headerBytes int64
+
+	closeNotifyOnce sync.Once
+	closeNotify     <-chan struct{}
+	hijacker        func() (net.Conn, []byte, error)
 }
 
 type ResponseState int
 
 func NewWriter(w io.Writer) *Writer {
 	return &Writer{
-		writer:        w,
+		conn:          w,
+		writer:        &countingWriter{w: w},
 		responseState: responseStateInitialized,
 	}
 }
 
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
 const (
 	responseStateInitialized ResponseState = iota
 	responseStateHeaders
 	responseStateBody
 	responseStateTrailers
 	responseStateDone
+	responseStateHijacked
 )
+
+// Status returns the status code written, 0 if the status line wasn't
+// written yet.
+func (w *Writer) Status() StatusCode {
+	return w.statusCode
+}
+
+// BytesWritten counts the bytes sent after the headers, as they went out:
+// compressed and with chunk framing.
+func (w *Writer) BytesWritten() int64 {
+	if w.headerBytes == 0 {
+		return 0
+	}
+	return w.writer.n - w.headerBytes
+}
+
+// SetHeader adds a header to the response whatever headers the handler
+// writes, e.g. a request ID set by middleware. It has to be called before
+// the headers are written.
+func (w *Writer) SetHeader(name, value string) error {
+	if w.responseState != responseStateInitialized && w.responseState != responseStateHeaders {
+		return fmt.Errorf("set header called after headers were written")
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("invalid value for header %s: %q", name, value)
+	}
+	if w.extraHeaders == nil {
+		w.extraHeaders = headers.NewHeaders()
+	}
+	w.extraHeaders.Set(name, value)
+	return nil
+}
+
+// SetTemplates overrides the templates WriteError renders HTML pages with.
+func (w *Writer) SetTemplates(t *Templates) {
+	w.errorPages = t
+}
+
+func (w *Writer) templates() *Templates {
+	if w.errorPages == nil {
+		return defaultTemplates
+	}
+	return w.errorPages
+}
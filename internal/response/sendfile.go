@@ -0,0 +1,30 @@
+package response
+
+import (
+	"io"
+	"net"
+	"os"
+)
+
+// copyBody streams r to the connection. When r is a file and the connection
+// is plain TCP, the copy goes through TCPConn.ReadFrom, which hands it to
+// sendfile(2) so the bytes never pass through user space. Anything that
+// wraps either side (TLS, compression, a multipart body) gets a normal copy.
+func (w *Writer) copyBody(r io.Reader) (int64, error) {
+	if conn, ok := w.conn.(*net.TCPConn); ok && isFileReader(r) {
+		n, err := conn.ReadFrom(r)
+		w.writer.n += n
+		return n, err
+	}
+	return io.Copy(w.writer, r)
+}
+
+// isFileReader reports whether r is a file, or a section of one limited by
+// io.LimitReader as ServeContent does for ranges.
+func isFileReader(r io.Reader) bool {
+	if lr, ok := r.(*io.LimitedReader); ok {
+		r = lr.R
+	}
+	_, ok := r.(*os.File)
+	return ok
+}
@@ -0,0 +1,142 @@
+package response
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tcpPair returns both ends of a loopback TCP connection
+func tcpPair(tb testing.TB) (*net.TCPConn, *net.TCPConn) {
+	tb.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(tb, err)
+	defer listener.Close()
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(tb, err)
+	server, ok := <-accepted
+	require.True(tb, ok)
+	tb.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server.(*net.TCPConn), client.(*net.TCPConn)
+}
+
+func writeTempFile(tb testing.TB, size int) string {
+	tb.Helper()
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	require.NoError(tb, err)
+	name := filepath.Join(tb.TempDir(), "body.bin")
+	require.NoError(tb, os.WriteFile(name, data, 0o644))
+	return name
+}
+
+func writeHeadersOnly(tb testing.TB, w *Writer, size int64) {
+	tb.Helper()
+	require.NoError(tb, w.WriteStatusLine(StatusCodeSuccess))
+	h := headers.NewHeaders()
+	h.Set("Content-Length", strconv.FormatInt(size, 10))
+	require.NoError(tb, w.WriteHeaders(h))
+}
+
+func TestWriteBodyFromFileTCP(t *testing.T) {
+	server, client := tcpPair(t)
+	name := writeTempFile(t, 256*1024)
+	want, err := os.ReadFile(name)
+	require.NoError(t, err)
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+
+	received := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(client)
+		received <- data
+	}()
+
+	w := NewWriter(server)
+	writeHeadersOnly(t, w, 100*1024)
+	// a limited section of the file, like a range request
+	n, err := w.WriteBodyFrom(io.LimitReader(f, 100*1024))
+	require.NoError(t, err)
+	assert.Equal(t, int64(100*1024), n)
+	server.Close()
+
+	data := <-received
+	idx := bytes.Index(data, []byte("\r\n\r\n"))
+	require.NotEqual(t, -1, idx)
+	assert.Equal(t, want[:100*1024], data[idx+4:])
+}
+
+const benchFileSize = 8 << 20
+
+func benchmarkFileBody(b *testing.B, write func(w *Writer, f *os.File) error) {
+	server, client := tcpPair(b)
+	name := writeTempFile(b, benchFileSize)
+	go io.Copy(io.Discard, client)
+
+	b.SetBytes(benchFileSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, err := os.Open(name)
+		if err != nil {
+			b.Fatal(err)
+		}
+		w := NewWriter(server)
+		writeHeadersOnly(b, w, benchFileSize)
+		if err := write(w, f); err != nil {
+			b.Fatal(err)
+		}
+		f.Close()
+	}
+}
+
+// BenchmarkFileBodyReadAll is the old way of serving files: read the whole
+// thing into memory, then write it out.
+func BenchmarkFileBodyReadAll(b *testing.B) {
+	benchmarkFileBody(b, func(w *Writer, f *os.File) error {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		_, err = w.WriteBody(data)
+		return err
+	})
+}
+
+func BenchmarkFileBodySendfile(b *testing.B) {
+	benchmarkFileBody(b, func(w *Writer, f *os.File) error {
+		_, err := w.WriteBodyFrom(f)
+		return err
+	})
+}
+
+// BenchmarkFileBodyCopy hides the file behind a plain io.Reader so the copy
+// goes through a user-space buffer, isolating what sendfile saves.
+func BenchmarkFileBodyCopy(b *testing.B) {
+	benchmarkFileBody(b, func(w *Writer, f *os.File) error {
+		_, err := w.WriteBodyFrom(struct{ io.Reader }{f})
+		return err
+	})
+}
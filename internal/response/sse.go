@@ -0,0 +1,169 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+)
+
+var ErrStreamClosed = errors.New("event stream closed")
+
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	// Retry tells the client how long to wait before reconnecting, 0 leaves it unset
+	Retry time.Duration
+}
+
+// EventStream writes Server-Sent Events over a chunked response. It's safe
+// for concurrent use, so events and heartbeats can come from different goroutines.
+type EventStream struct {
+	w           *Writer
+	mu          sync.Mutex
+	done        chan struct{}
+	closeOnce   sync.Once
+	lastEventID string
+}
+
+// NewEventStream starts a text/event-stream response. The stream ends when
+// Close is called, a write fails, or the client disconnects; Done reports all three.
+func NewEventStream(w *Writer, req *request.Request) (*EventStream, error) {
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "close")
+	h.Set("Transfer-Encoding", "chunked")
+	// keeps reverse proxies like nginx from buffering the stream
+	h.Set("X-Accel-Buffering", "no")
+	if err := w.WriteStatusLine(StatusCodeSuccess); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+
+	lastEventID, _ := req.Headers.Get("Last-Event-ID")
+	es := &EventStream{
+		w:           w,
+		done:        make(chan struct{}),
+		lastEventID: lastEventID,
+	}
+	go func() {
+		select {
+		case <-w.CloseNotify():
+			es.stop()
+		case <-es.done:
+		}
+	}()
+	return es, nil
+}
+
+// LastEventID is the ID of the last event a reconnecting client received.
+func (es *EventStream) LastEventID() string {
+	return es.lastEventID
+}
+
+func (es *EventStream) Done() <-chan struct{} {
+	return es.done
+}
+
+func (es *EventStream) Send(e Event) error {
+	if strings.ContainsAny(e.ID, "\r\n\x00") || strings.ContainsAny(e.Event, "\r\n") {
+		return fmt.Errorf("event id and type can't contain newlines")
+	}
+	var b strings.Builder
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	data := strings.ReplaceAll(strings.ReplaceAll(e.Data, "\r\n", "\n"), "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return es.write(b.String())
+}
+
+// Comment sends a comment line, which clients ignore.
+func (es *EventStream) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		b.WriteString(": " + line + "\n")
+	}
+	b.WriteString("\n")
+	return es.write(b.String())
+}
+
+// Heartbeat sends a comment every interval until the stream is done, so
+// idle connections aren't dropped by intermediaries and dead clients are noticed.
+// A non-positive interval sends none.
+func (es *EventStream) Heartbeat(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := es.Comment("heartbeat"); err != nil {
+					return
+				}
+			case <-es.done:
+				return
+			}
+		}
+	}()
+}
+
+// Close ends the stream and finishes the chunked response.
+func (es *EventStream) Close() error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	select {
+	case <-es.done:
+		return nil
+	default:
+	}
+	es.stop()
+	if _, err := es.w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	return es.w.WriteTrailers(headers.NewHeaders())
+}
+
+func (es *EventStream) write(s string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	select {
+	case <-es.done:
+		return ErrStreamClosed
+	default:
+	}
+	if _, err := es.w.WriteChunkedBody([]byte(s)); err != nil {
+		es.stop()
+		return err
+	}
+	if err := es.w.Flush(); err != nil {
+		es.stop()
+		return err
+	}
+	return nil
+}
+
+func (es *EventStream) stop() {
+	es.closeOnce.Do(func() { close(es.done) })
+}
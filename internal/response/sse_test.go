@@ -0,0 +1,73 @@
+package response
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventStream(t *testing.T) {
+	req := newTestRequest(t, "GET /events HTTP/1.1\r\nHost: localhost\r\nLast-Event-ID: 41\r\n\r\n")
+	var buf bytes.Buffer
+	es, err := NewEventStream(NewWriter(&buf), req)
+	require.NoError(t, err)
+	assert.Equal(t, "41", es.LastEventID())
+
+	require.NoError(t, es.Send(Event{ID: "42", Event: "update", Data: "line one\r\nline two", Retry: 3 * time.Second}))
+	require.NoError(t, es.Comment("ping"))
+	assert.Error(t, es.Send(Event{ID: "4\n2"}))
+	require.NoError(t, es.Close())
+	assert.Equal(t, ErrStreamClosed, es.Send(Event{Data: "late"}))
+	select {
+	case <-es.Done():
+	default:
+		t.Fatal("stream should be done after Close")
+	}
+
+	h, body := splitResponse(t, buf.String())
+	v, _ := h.Get("Content-Type")
+	assert.Equal(t, "text/event-stream", v)
+	v, _ = h.Get("Cache-Control")
+	assert.Equal(t, "no-cache", v)
+
+	event := "event: update\nid: 42\nretry: 3000\ndata: line one\ndata: line two\n\n"
+	comment := ": ping\n\n"
+	assert.Equal(t, fmt.Sprintf("%x\r\n%s\r\n%x\r\n%s\r\n0\r\n\r\n", len(event), event, len(comment), comment), body)
+}
+
+func TestEventStreamClientDisconnect(t *testing.T) {
+	server, client := tcpPair(t)
+	req := newTestRequest(t, "GET /events HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	es, err := NewEventStream(NewWriter(server), req)
+	require.NoError(t, err)
+
+	client.Close()
+	select {
+	case <-es.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream didn't notice the client disconnecting")
+	}
+	assert.Equal(t, ErrStreamClosed, es.Send(Event{Data: "anyone there?"}))
+}
+
+func TestEventStreamHeartbeat(t *testing.T) {
+	req := newTestRequest(t, "GET /events HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	var buf bytes.Buffer
+	es, err := NewEventStream(NewWriter(&buf), req)
+	require.NoError(t, err)
+
+	// Test: a non-positive interval sends no heartbeats instead of panicking
+	es.Heartbeat(0)
+	es.Heartbeat(-time.Second)
+
+	// Test: a positive one sends comments until the stream is closed
+	es.Heartbeat(5 * time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, es.Close())
+	_, body := splitResponse(t, buf.String())
+	assert.Contains(t, body, ": heartbeat\n\n")
+}
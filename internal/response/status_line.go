@@ -7,9 +7,40 @@ import (
 type StatusCode int
 
 const (
-	StatusCodeSuccess             StatusCode = 200
-	StatusCodeBadRequest          StatusCode = 400
-	StatusCodeInternalServerError StatusCode = 500
+	StatusCodeSwitchingProtocols   StatusCode = 101
+	StatusCodeSuccess              StatusCode = 200
+	StatusCodeCreated              StatusCode = 201
+	StatusCodeAccepted             StatusCode = 202
+	StatusCodeNoContent            StatusCode = 204
+	StatusCodePartialContent       StatusCode = 206
+	StatusCodeMovedPermanently     StatusCode = 301
+	StatusCodeFound                StatusCode = 302
+	StatusCodeSeeOther             StatusCode = 303
+	StatusCodeNotModified          StatusCode = 304
+	StatusCodeTemporaryRedirect    StatusCode = 307
+	StatusCodePermanentRedirect    StatusCode = 308
+	StatusCodeBadRequest           StatusCode = 400
+	StatusCodeUnauthorized         StatusCode = 401
+	StatusCodeForbidden            StatusCode = 403
+	StatusCodeNotFound             StatusCode = 404
+	StatusCodeMethodNotAllowed     StatusCode = 405
+	StatusCodeNotAcceptable        StatusCode = 406
+	StatusCodeRequestTimeout       StatusCode = 408
+	StatusCodeConflict             StatusCode = 409
+	StatusCodeGone                 StatusCode = 410
+	StatusCodePreconditionFailed   StatusCode = 412
+	StatusCodeContentTooLarge      StatusCode = 413
+	StatusCodeUnsupportedMediaType StatusCode = 415
+	StatusCodeRangeNotSatisfiable  StatusCode = 416
+	StatusCodeUnprocessableContent StatusCode = 422
+	StatusCodeUpgradeRequired      StatusCode = 426
+	StatusCodeTooManyRequests      StatusCode = 429
+	StatusCodeHeaderFieldsTooLarge StatusCode = 431
+	StatusCodeInternalServerError  StatusCode = 500
+	StatusCodeNotImplemented       StatusCode = 501
+	StatusCodeBadGateway           StatusCode = 502
+	StatusCodeServiceUnavailable   StatusCode = 503
+	StatusCodeGatewayTimeout       StatusCode = 504
 )
 
 func getStatusLine(statusCode StatusCode) string {
@@ -19,13 +50,77 @@ func getStatusLine(statusCode StatusCode) string {
 
 func getStatusDescription(statusCode StatusCode) string {
 	switch statusCode {
+	case StatusCodeSwitchingProtocols:
+		return "Switching Protocols"
 	case StatusCodeSuccess:
 		return "OK"
+	case StatusCodeCreated:
+		return "Created"
+	case StatusCodeAccepted:
+		return "Accepted"
+	case StatusCodeNoContent:
+		return "No Content"
+	case StatusCodePartialContent:
+		return "Partial Content"
+	case StatusCodeMovedPermanently:
+		return "Moved Permanently"
+	case StatusCodeFound:
+		return "Found"
+	case StatusCodeSeeOther:
+		return "See Other"
+	case StatusCodeNotModified:
+		return "Not Modified"
+	case StatusCodeTemporaryRedirect:
+		return "Temporary Redirect"
+	case StatusCodePermanentRedirect:
+		return "Permanent Redirect"
 	case StatusCodeBadRequest:
 		return "Bad Request"
+	case StatusCodeUnauthorized:
+		return "Unauthorized"
+	case StatusCodeForbidden:
+		return "Forbidden"
+	case StatusCodeNotFound:
+		return "Not Found"
+	case StatusCodeMethodNotAllowed:
+		return "Method Not Allowed"
+	case StatusCodeNotAcceptable:
+		return "Not Acceptable"
+	case StatusCodeRequestTimeout:
+		return "Request Timeout"
+	case StatusCodeConflict:
+		return "Conflict"
+	case StatusCodeGone:
+		return "Gone"
+	case StatusCodePreconditionFailed:
+		return "Precondition Failed"
+	case StatusCodeContentTooLarge:
+		return "Content Too Large"
+	case StatusCodeUnsupportedMediaType:
+		return "Unsupported Media Type"
+	case StatusCodeRangeNotSatisfiable:
+		return "Range Not Satisfiable"
+	case StatusCodeUnprocessableContent:
+		return "Unprocessable Content"
+	case StatusCodeUpgradeRequired:
+		return "Upgrade Required"
+	case StatusCodeTooManyRequests:
+		return "Too Many Requests"
+	case StatusCodeHeaderFieldsTooLarge:
+		return "Request Header Fields Too Large"
 	case StatusCodeInternalServerError:
 		return "Internal Server Error"
+	case StatusCodeNotImplemented:
+		return "Not Implemented"
+	case StatusCodeBadGateway:
+		return "Bad Gateway"
+	case StatusCodeServiceUnavailable:
+		return "Service Unavailable"
+	case StatusCodeGatewayTimeout:
+		return "Gateway Timeout"
 	default:
-		return "Internal Server Error"
+		// the reason phrase is optional, so codes we don't know (e.g. from a
+		// proxied upstream) go out without one rather than with a wrong one
+		return ""
 	}
 }
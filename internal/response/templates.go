@@ -0,0 +1,112 @@
+package response
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var defaultTemplates = mustLoadDefaultTemplates()
+
+// Templates renders HTML response bodies, optionally with a different
+// template per status code. It's safe for concurrent use.
+type Templates struct {
+	mu       sync.RWMutex
+	fallback *template.Template
+	byStatus map[StatusCode]*template.Template
+}
+
+func NewTemplates() *Templates {
+	return &Templates{
+		fallback: defaultTemplates.fallback,
+		byStatus: map[StatusCode]*template.Template{},
+	}
+}
+
+// LoadTemplates builds Templates from a directory of files named after the
+// status code they render, like 404.html, plus an optional default.html used
+// for every other status. Missing files fall back to the built-in page.
+func LoadTemplates(fsys fs.FS) (*Templates, error) {
+	t := NewTemplates()
+	matches, err := fs.Glob(fsys, "*.html")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range matches {
+		tmpl, err := template.ParseFS(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+		}
+		base := strings.TrimSuffix(path.Base(name), ".html")
+		if base == "default" {
+			t.SetDefault(tmpl)
+			continue
+		}
+		code, err := strconv.Atoi(base)
+		if err != nil || code < 100 || code > 999 {
+			return nil, fmt.Errorf("template %s is not named after a status code", name)
+		}
+		t.Set(StatusCode(code), tmpl)
+	}
+	return t, nil
+}
+
+func (t *Templates) Set(statusCode StatusCode, tmpl *template.Template) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.byStatus[statusCode] = tmpl
+}
+
+func (t *Templates) SetDefault(tmpl *template.Template) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.fallback = tmpl
+}
+
+func (t *Templates) Render(statusCode StatusCode, content string) ([]byte, error) {
+	t.mu.RLock()
+	tmpl, ok := t.byStatus[statusCode]
+	if !ok {
+		tmpl = t.fallback
+	}
+	t.mu.RUnlock()
+
+	var bodyBuffer bytes.Buffer
+	respBody := responseBodyData{
+		ResponseBodyTitle:   fmt.Sprintf("%d %s", statusCode, getStatusDescription(statusCode)),
+		ResponseBodyHeader:  getStatusDescription(statusCode),
+		ResponseBodyContent: content,
+	}
+	if err := tmpl.Execute(&bodyBuffer, respBody); err != nil {
+		return nil, fmt.Errorf("failed to execute template: %w", err)
+	}
+	return bodyBuffer.Bytes(), nil
+}
+
+func (t *Templates) mustRender(statusCode StatusCode, content string) []byte {
+	body, err := t.Render(statusCode, content)
+	if err != nil {
+		// a broken custom template shouldn't take the error page down with it
+		log.Printf("error rendering template for %d: %v", statusCode, err)
+		body, _ = defaultTemplates.Render(statusCode, content)
+	}
+	return body
+}
+
+func mustLoadDefaultTemplates() *Templates {
+	tmpl := template.Must(template.ParseFS(templateFS, "templates/response_body.html"))
+	return &Templates{
+		fallback: tmpl,
+		byStatus: map[StatusCode]*template.Template{},
+	}
+}
@@ -0,0 +1,56 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildResponseBody(t *testing.T) {
+	// Test: embedded template renders and escapes the message
+	body := string(BuildResponseBody(StatusCodeBadRequest, "<script>alert(1)</script>"))
+	assert.Contains(t, body, "<title>400 Bad Request</title>")
+	assert.Contains(t, body, "&lt;script&gt;alert(1)&lt;/script&gt;")
+	assert.NotContains(t, body, "<script>")
+}
+
+func TestLoadTemplates(t *testing.T) {
+	fsys := fstest.MapFS{
+		"404.html":     {Data: []byte("missing: {{.ResponseBodyContent}}")},
+		"default.html": {Data: []byte("oops: {{.ResponseBodyTitle}}")},
+	}
+	templates, err := LoadTemplates(fsys)
+	require.NoError(t, err)
+
+	// Test: per status template
+	body, err := templates.Render(404, "/nope")
+	require.NoError(t, err)
+	assert.Equal(t, "missing: /nope", string(body))
+
+	// Test: default template for other codes
+	body, err = templates.Render(StatusCodeBadRequest, "x")
+	require.NoError(t, err)
+	assert.Equal(t, "oops: 400 Bad Request", string(body))
+
+	// Test: badly named template
+	_, err = LoadTemplates(fstest.MapFS{"oops.html": {Data: []byte("x")}})
+	assert.Error(t, err)
+}
+
+func TestWriteErrorCustomTemplates(t *testing.T) {
+	templates, err := LoadTemplates(fstest.MapFS{"500.html": {Data: []byte("custom {{.ResponseBodyContent}}")}})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetTemplates(templates)
+	require.NoError(t, WriteError(w, nil, StatusCodeInternalServerError, "boom"))
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.Contains(t, out, "content-length: 11\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\ncustom boom"))
+}
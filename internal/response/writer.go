@@ -2,6 +2,9 @@ package response
 
 import (
 	"fmt"
+	"io"
+	"net"
+	"strings"
 
 	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
 )
@@ -11,6 +14,7 @@ func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 		return fmt.Errorf("write status line called out of order")
 	}
 	defer func() { w.responseState = responseStateHeaders }()
+	w.statusCode = statusCode
 	statusLine := getStatusLine(statusCode)
 	_, err := w.writer.Write([]byte(statusLine))
 	if err != nil {
@@ -25,15 +29,17 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 		return fmt.Errorf("write headers called out of order")
 	}
 	defer func() { w.responseState = responseStateBody }()
-	for k, v := range headers {
-		_, err := w.writer.Write([]byte(fmt.Sprintf("%s: %s\r\n", k, v)))
-		if err != nil {
+	if w.compressor != nil && w.compressor.prepare(w.statusCode, headers) {
+		if !isChunked(headers) {
+			// the compressed length isn't known until the body is written
+			w.pendingHeaders = headers
+			return nil
+		}
+		if err := w.compressor.start(&chunkWriter{w: w.writer}); err != nil {
 			return err
 		}
 	}
-	_, err := w.writer.Write([]byte("\r\n"))
-
-	return err
+	return w.writeHeaderBlock(headers)
 }
 
 func (w *Writer) WriteBody(p []byte) (int, error) {
@@ -41,40 +47,45 @@ func (w *Writer) WriteBody(p []byte) (int, error) {
 		return 0, fmt.Errorf("write body called out of order")
 	}
 	defer func() { w.responseState = responseStateDone }()
+	if w.pendingHeaders != nil {
+		return w.writeCompressedBody(p)
+	}
 	return w.writer.Write(p)
 }
 
-func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+// WriteBodyFrom streams the body from r instead of requiring it in memory.
+// The caller is responsible for a Content-Length matching what r yields.
+func (w *Writer) WriteBodyFrom(r io.Reader) (int64, error) {
 	if w.responseState != responseStateBody {
 		return 0, fmt.Errorf("write body called out of order")
 	}
-	chunkSize := len(p)
-
-	nTotal := 0
-	n, err := fmt.Fprintf(w.writer, "%x\r\n", chunkSize)
-	if err != nil {
-		return nTotal, err
+	defer func() { w.responseState = responseStateDone }()
+	if w.pendingHeaders != nil {
+		return w.writeCompressedBodyFrom(r)
 	}
-	nTotal += n
+	return w.copyBody(r)
+}
 
-	n, err = w.writer.Write(p)
-	if err != nil {
-		return nTotal, err
+func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if w.responseState != responseStateBody {
+		return 0, fmt.Errorf("write body called out of order")
 	}
-	nTotal += n
-
-	n, err = w.writer.Write([]byte("\r\n"))
-	if err != nil {
-		return nTotal, err
+	if w.compressor != nil && w.compressor.active() {
+		return w.compressor.Write(p)
 	}
-	nTotal += n
-	return nTotal, nil
+	return writeChunk(w.writer, p)
 }
 
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	if w.responseState != responseStateBody {
 		return 0, fmt.Errorf("cannot write body in state %d", w.responseState)
 	}
+	if w.compressor != nil && w.compressor.active() {
+		// flushes the rest of the compressed stream as a final data chunk
+		if err := w.compressor.close(); err != nil {
+			return 0, err
+		}
+	}
 	n, err := w.writer.Write([]byte("0\r\n"))
 	if err != nil {
 		return n, err
@@ -97,3 +108,248 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 	_, err := w.writer.Write([]byte("\r\n"))
 	return err
 }
+
+// Hijack hands the underlying connection to the caller, along with any
+// bytes the request parser read past the end of the request, which must be
+// consumed before reading from the connection. The caller owns the
+// connection from then on and has to close it; the writer can't be used
+// afterwards.
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.responseState == responseStateHijacked {
+		return nil, nil, fmt.Errorf("connection already hijacked")
+	}
+	if w.pendingHeaders != nil {
+		// the handler wrote headers it expects the client to see before the switch
+		if err := w.Close(); err != nil {
+			return nil, nil, err
+		}
+	}
+	var (
+		conn     net.Conn
+		buffered []byte
+		err      error
+	)
+	if w.hijacker != nil {
+		conn, buffered, err = w.hijacker()
+	} else if c, ok := w.conn.(net.Conn); ok {
+		conn = c
+	} else {
+		err = fmt.Errorf("writer isn't backed by a connection")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	w.responseState = responseStateHijacked
+	return conn, buffered, nil
+}
+
+// SetHijacker lets the server take part in Hijack, to hand over parser
+// buffers and stop managing the connection.
+func (w *Writer) SetHijacker(hijacker func() (net.Conn, []byte, error)) {
+	w.hijacker = hijacker
+}
+
+// Close finishes the response, sending headers that are still held back
+// for compression if the handler never wrote a body.
+func (w *Writer) Close() error {
+	if w.pendingHeaders == nil {
+		return nil
+	}
+	h := w.pendingHeaders
+	w.pendingHeaders = nil
+	return w.writeHeaderBlock(h)
+}
+
+// Flush pushes out anything buffered between the handler and the client,
+// such as a partially filled compression block.
+func (w *Writer) Flush() error {
+	if w.compressor != nil && w.compressor.active() {
+		if err := w.compressor.flush(); err != nil {
+			return err
+		}
+	}
+	if f, ok := w.conn.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// CloseNotify returns a channel that's closed when the client hangs up.
+// It reads from the connection to notice, so it must not be combined with
+// anything else reading from it. A writer that isn't a connection uses its
+// writer's CloseNotify if it has one, otherwise the channel never closes.
+func (w *Writer) CloseNotify() <-chan struct{} {
+	w.closeNotifyOnce.Do(func() {
+		if n, ok := w.conn.(interface{ CloseNotify() <-chan struct{} }); ok {
+			w.closeNotify = n.CloseNotify()
+			return
+		}
+		conn, ok := w.conn.(net.Conn)
+		if !ok {
+			return
+		}
+		closed := make(chan struct{})
+		w.closeNotify = closed
+		go func() {
+			defer close(closed)
+			buf := make([]byte, 1)
+			for {
+				// the request has been read, so anything but more bytes means the client is gone
+				if _, err := conn.Read(buf); err != nil {
+					return
+				}
+			}
+		}()
+	})
+	return w.closeNotify
+}
+
+func (w *Writer) SetCookie(c *headers.Cookie) error {
+	if w.responseState != responseStateInitialized && w.responseState != responseStateHeaders {
+		return fmt.Errorf("set cookie called after headers were written")
+	}
+	if err := c.Valid(); err != nil {
+		return err
+	}
+	w.cookies = append(w.cookies, c.String())
+	return nil
+}
+
+// AddSetCookie sends a Set-Cookie value as is, for cookies that were already
+// serialized elsewhere, e.g. by a proxied upstream.
+func (w *Writer) AddSetCookie(value string) error {
+	if w.responseState != responseStateInitialized && w.responseState != responseStateHeaders {
+		return fmt.Errorf("add set-cookie called after headers were written")
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("invalid set-cookie value: %q", value)
+	}
+	w.cookies = append(w.cookies, value)
+	return nil
+}
+
+func (w *Writer) writeHeaderBlock(headers headers.Headers) error {
+	for k, v := range headers {
+		_, err := w.writer.Write([]byte(fmt.Sprintf("%s: %s\r\n", k, v)))
+		if err != nil {
+			return err
+		}
+	}
+	for k, v := range w.extraHeaders {
+		if _, ok := headers[k]; ok {
+			continue
+		}
+		_, err := w.writer.Write([]byte(fmt.Sprintf("%s: %s\r\n", k, v)))
+		if err != nil {
+			return err
+		}
+	}
+	// each cookie needs its own Set-Cookie line, they can't be comma-joined like other headers
+	for _, c := range w.cookies {
+		_, err := w.writer.Write([]byte(fmt.Sprintf("set-cookie: %s\r\n", c)))
+		if err != nil {
+			return err
+		}
+	}
+	_, err := w.writer.Write([]byte("\r\n"))
+	w.headerBytes = w.writer.n
+	return err
+}
+
+func (w *Writer) writeCompressedBody(p []byte) (int, error) {
+	h := w.pendingHeaders
+	w.pendingHeaders = nil
+	body := p
+	if len(p) >= w.compressor.config.MinSize {
+		compressed, err := w.compressor.compress(p)
+		if err != nil {
+			return 0, err
+		}
+		h.Set("Content-Encoding", w.compressor.encoding)
+		h.Set("Content-Length", fmt.Sprintf("%d", len(compressed)))
+		weakenETag(h)
+		body = compressed
+	}
+	if err := w.writeHeaderBlock(h); err != nil {
+		return 0, err
+	}
+	if _, err := w.writer.Write(body); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeCompressedBodyFrom compresses a streamed body. Its compressed length
+// isn't known up front, so the response switches to chunked framing.
+func (w *Writer) writeCompressedBodyFrom(r io.Reader) (int64, error) {
+	h := w.pendingHeaders
+	w.pendingHeaders = nil
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Content-Encoding", w.compressor.encoding)
+	weakenETag(h)
+	if err := w.writeHeaderBlock(h); err != nil {
+		return 0, err
+	}
+	if err := w.compressor.start(&chunkWriter{w: w.writer}); err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(w.compressor, r)
+	if err != nil {
+		return n, err
+	}
+	if err := w.compressor.close(); err != nil {
+		return n, err
+	}
+	_, err = w.writer.Write([]byte("0\r\n\r\n"))
+	return n, err
+}
+
+func writeChunk(w io.Writer, p []byte) (int, error) {
+	chunkSize := len(p)
+
+	nTotal := 0
+	n, err := fmt.Fprintf(w, "%x\r\n", chunkSize)
+	if err != nil {
+		return nTotal, err
+	}
+	nTotal += n
+
+	n, err = w.Write(p)
+	if err != nil {
+		return nTotal, err
+	}
+	nTotal += n
+
+	n, err = w.Write([]byte("\r\n"))
+	if err != nil {
+		return nTotal, err
+	}
+	nTotal += n
+	return nTotal, nil
+}
+
+// chunkWriter frames every write as a single chunk.
+type chunkWriter struct {
+	w io.Writer
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		// a zero-length chunk would end the body
+		return 0, nil
+	}
+	if _, err := writeChunk(cw.w, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func isChunked(h headers.Headers) bool {
+	for _, coding := range h.Values("Transfer-Encoding") {
+		if strings.EqualFold(coding, "chunked") {
+			return true
+		}
+	}
+	return false
+}
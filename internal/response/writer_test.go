@@ -0,0 +1,85 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetCookie(t *testing.T) {
+	// Test: each cookie gets its own Set-Cookie line
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.SetCookie(&headers.Cookie{Name: "a", Value: "1", HttpOnly: true}))
+	require.NoError(t, w.SetCookie(&headers.Cookie{Name: "b", Value: "2"}))
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "set-cookie: a=1; HttpOnly\r\n")
+	assert.Contains(t, out, "set-cookie: b=2\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+
+	// Test: cookies can't be set once headers are written
+	assert.Error(t, w.SetCookie(&headers.Cookie{Name: "c", Value: "x"}))
+
+	// Test: invalid cookie is rejected
+	w = NewWriter(&buf)
+	assert.Error(t, w.SetCookie(&headers.Cookie{Name: "bad name"}))
+}
+
+func TestStatusAndBytesWritten(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	// Test: nothing is reported before the response starts
+	assert.Equal(t, StatusCode(0), w.Status())
+	assert.Equal(t, int64(0), w.BytesWritten())
+
+	// Test: the status and body bytes are tracked, headers aren't counted
+	require.NoError(t, w.WriteStatusLine(StatusCodeNotFound))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	assert.Equal(t, StatusCodeNotFound, w.Status())
+	assert.Equal(t, int64(0), w.BytesWritten())
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), w.BytesWritten())
+
+	// Test: chunked bodies count with their framing
+	w = NewWriter(&buf)
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("abc"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.NewHeaders()))
+	assert.Equal(t, int64(len("3\r\nabc\r\n0\r\n\r\n")), w.BytesWritten())
+}
+
+func TestSetHeader(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.SetHeader("X-Request-Id", "abc"))
+	require.NoError(t, w.SetHeader("Content-Type", "text/plain"))
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/html")
+	require.NoError(t, w.WriteHeaders(h))
+
+	// Test: extra headers are added, the handler's own headers win
+	out := buf.String()
+	assert.Contains(t, out, "x-request-id: abc\r\n")
+	assert.Contains(t, out, "content-type: text/html\r\n")
+	assert.NotContains(t, out, "text/plain")
+
+	// Test: too late once headers are written, and no header injection
+	assert.Error(t, w.SetHeader("X-Late", "1"))
+	assert.Error(t, NewWriter(&buf).SetHeader("X-Bad", "a\r\nb: c"))
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+)
+
+// RequestIDHeader carries the request ID to handlers, upstreams and clients.
+const RequestIDHeader = "X-Request-Id"
+
+// longest request ID accepted from a client
+const maxRequestIDLength = 128
+
+// RequestID gives every request an ID in the X-Request-Id header, keeping a
+// valid one the client or a proxy in front sent, and echoes it in the
+// response.
+func RequestID() Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			id, ok := req.Headers.Get(RequestIDHeader)
+			if !ok || !validRequestID(id) {
+				id = newRequestID()
+				req.Headers.Set(RequestIDHeader, id)
+			}
+			w.SetHeader(RequestIDHeader, id)
+			next(w, req)
+		}
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		// visible ASCII only, so it's safe in headers and log lines
+		if id[i] <= ' ' || id[i] > '~' || id[i] == '"' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AccessLog logs every request to logger once its handler returns, at info
+// level with the message "request" and these attributes: method, target,
+// proto, status, bytes, duration, remote_addr, user_agent, referer and
+// request_id. Use slog.NewJSONHandler for JSON lines, or
+// NewCommonLogHandler/NewCombinedLogHandler for the classic formats. A nil
+// logger logs to slog.Default().
+func AccessLog(logger *slog.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			next(w, req)
+			l := logger
+			if l == nil {
+				l = slog.Default()
+			}
+			userAgent, _ := req.Headers.Get("User-Agent")
+			referer, _ := req.Headers.Get("Referer")
+			requestID, _ := req.Headers.Get(RequestIDHeader)
+			l.LogAttrs(context.Background(), slog.LevelInfo, "request",
+				slog.String("method", req.RequestLine.Method),
+				slog.String("target", req.RequestLine.RequestTarget),
+				slog.String("proto", "HTTP/"+req.RequestLine.HttpVersion),
+				slog.Int("status", int(w.Status())),
+				slog.Int64("bytes", w.BytesWritten()),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("remote_addr", req.RemoteAddr),
+				slog.String("user_agent", userAgent),
+				slog.String("referer", referer),
+				slog.String("request_id", requestID),
+			)
+		}
+	}
+}
+
+// NewCommonLogHandler writes AccessLog records in the Common Log Format:
+//
+//	127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /index.html HTTP/1.1" 200 2326
+func NewCommonLogHandler(w io.Writer) slog.Handler {
+	return &clfHandler{out: &lockedWriter{w: w}}
+}
+
+// NewCombinedLogHandler writes AccessLog records in the Combined Log Format,
+// the Common one followed by the quoted referer and user agent.
+func NewCombinedLogHandler(w io.Writer) slog.Handler {
+	return &clfHandler{out: &lockedWriter{w: w}, combined: true}
+}
+
+type clfHandler struct {
+	out      *lockedWriter
+	combined bool
+	attrs    []slog.Attr
+}
+
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (h *clfHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo
+}
+
+func (h *clfHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &clfHandler{out: h.out, combined: h.combined, attrs: append(append([]slog.Attr(nil), h.attrs...), attrs...)}
+}
+
+// WithGroup is a no-op, the format has a fixed set of fields.
+func (h *clfHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+func (h *clfHandler) Handle(_ context.Context, r slog.Record) error {
+	fields := map[string]slog.Value{}
+	for _, a := range h.attrs {
+		fields[a.Key] = a.Value
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		fields[a.Key] = a.Value
+		return true
+	})
+	get := func(key string) string {
+		if v, ok := fields[key]; ok {
+			return v.String()
+		}
+		return ""
+	}
+
+	host := get("remote_addr")
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	bytes := "-"
+	if n := get("bytes"); n != "" && n != "0" {
+		bytes = n
+	}
+	status := get("status")
+	if status == "" || status == "0" {
+		status = "-"
+	}
+	line := fmt.Sprintf("%s - - [%s] %s %s %s",
+		orDash(host),
+		r.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(strings.TrimSpace(get("method")+" "+get("target")+" "+get("proto"))),
+		status,
+		bytes,
+	)
+	if h.combined {
+		line += " " + quoteOrDash(get("referer")) + " " + quoteOrDash(get("user_agent"))
+	}
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+	_, err := io.WriteString(h.out.w, line+"\n")
+	return err
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func quoteOrDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strconv.Quote(s)
+}
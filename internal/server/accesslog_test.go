@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is written by the server's goroutines and read by the test.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func writeTeapot(w *response.Writer, _ *request.Request) {
+	w.WriteStatusLine(418)
+	w.WriteHeaders(response.GetDefaultHeaders(11))
+	w.WriteBody([]byte("short&stout"))
+}
+
+// logRequest sends raw through a server logging to handler and returns the
+// response once the log line is written.
+func logRequest(t *testing.T, handler slog.Handler, out *syncBuffer, raw string) *http.Response {
+	t.Helper()
+	_, addr := startTestServer(t, Chain(writeTeapot, RequestID(), AccessLog(slog.New(handler))))
+	conn := dialAndSend(t, addr, raw)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return out.String() != "" }, time.Second, 5*time.Millisecond)
+	return resp
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	var out syncBuffer
+	resp := logRequest(t, slog.NewJSONHandler(&out, nil), &out,
+		"GET /pot?brew=1 HTTP/1.1\r\nHost: localhost\r\nUser-Agent: test-agent\r\nX-Request-Id: req-42\r\n\r\n")
+
+	// Test: every field is recorded
+	var record map[string]any
+	require.NoError(t, json.Unmarshal([]byte(out.String()), &record))
+	assert.Equal(t, "request", record["msg"])
+	assert.Equal(t, "GET", record["method"])
+	assert.Equal(t, "/pot?brew=1", record["target"])
+	assert.Equal(t, "HTTP/1.1", record["proto"])
+	assert.Equal(t, float64(418), record["status"])
+	assert.Equal(t, float64(11), record["bytes"])
+	assert.Contains(t, record, "duration")
+	assert.NotEmpty(t, record["remote_addr"])
+	assert.Equal(t, "test-agent", record["user_agent"])
+
+	// Test: the client's request ID is kept and echoed
+	assert.Equal(t, "req-42", record["request_id"])
+	assert.Equal(t, "req-42", resp.Header.Get("X-Request-Id"))
+}
+
+func TestAccessLogCLF(t *testing.T) {
+	raw := "GET /pot HTTP/1.1\r\nHost: localhost\r\nUser-Agent: test-agent\r\nReferer: http://example.com/\r\n\r\n"
+
+	// Test: Common Log Format
+	var out syncBuffer
+	logRequest(t, NewCommonLogHandler(&out), &out, raw)
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f.:]+ - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /pot HTTP/1\.1" 418 11\n$`), out.String())
+
+	// Test: Combined Log Format adds the referer and user agent
+	out = syncBuffer{}
+	logRequest(t, NewCombinedLogHandler(&out), &out, raw)
+	assert.True(t, strings.HasSuffix(out.String(), `" 418 11 "http://example.com/" "test-agent"`+"\n"), out.String())
+
+	// Test: missing values are dashes
+	out = syncBuffer{}
+	slog.New(NewCombinedLogHandler(&out)).Info("request", "method", "GET", "target", "/", "proto", "HTTP/1.1")
+	assert.True(t, strings.HasPrefix(out.String(), "- - - ["), out.String())
+	assert.True(t, strings.HasSuffix(out.String(), `"GET / HTTP/1.1" - - - -`+"\n"), out.String())
+}
+
+func TestRequestID(t *testing.T) {
+	var seen []string
+	handler := Chain(func(w *response.Writer, req *request.Request) {
+		id, _ := req.Headers.Get(RequestIDHeader)
+		seen = append(seen, id)
+		writeOK(w, req)
+	}, RequestID())
+
+	for _, sent := range []string{"", "bad id with spaces", strings.Repeat("a", 200)} {
+		raw := "GET / HTTP/1.1\r\nHost: localhost\r\n"
+		if sent != "" {
+			raw += "X-Request-Id: " + sent + "\r\n"
+		}
+		req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		handler(response.NewWriter(&buf), req)
+		resp, err := http.ReadResponse(bufio.NewReader(&buf), nil)
+		require.NoError(t, err)
+
+		// Test: missing or invalid IDs are replaced with a generated one
+		id := seen[len(seen)-1]
+		assert.Regexp(t, `^[0-9a-f]{32}$`, id)
+		assert.Equal(t, id, resp.Header.Get("X-Request-Id"))
+	}
+	assert.NotEqual(t, seen[0], seen[1])
+}
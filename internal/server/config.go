@@ -0,0 +1,159 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/tracing"
+)
+
+type Config struct {
+	// addresses to listen on, see Listen
+	Addrs []string
+	// listeners opened by the caller, served next to Addrs
+	Listeners []net.Listener
+	// terminate TLS on every listener when set
+	TLS *TLSConfig
+
+	// how long reading a request may take, 0 means no limit
+	ReadTimeout time.Duration
+	// how long writing the response may take once the request is read, 0
+	// means no limit. Hijacked connections aren't limited.
+	WriteTimeout time.Duration
+	// limits on the size of requests, 0 means no limit
+	MaxHeaderBytes int
+	MaxBodyBytes   int64
+
+	// most connections served at once, in total and per client IP, 0 means
+	// no limit. Connections over them are answered 503 and closed. A
+	// hijacked connection counts until its handler returns.
+	MaxConns      int
+	MaxConnsPerIP int
+	// most handlers running at once, 0 means no limit. Other requests queue
+	// for a slot and are answered 503 when the queue is full or they've
+	// waited too long. Long-lived handlers like WebSockets hold their slot
+	// throughout.
+	MaxConcurrentRequests int
+	// most requests waiting for a slot and how long each may wait, 0 means
+	// no limit
+	MaxQueuedRequests int
+	MaxQueueWait      time.Duration
+	// what Retry-After tells clients turned away, 0 means a second
+	OverloadRetryAfter time.Duration
+
+	// custom error pages, nil uses the built-in ones
+	ErrorTemplates *response.Templates
+
+	// records request and connection metrics when set, see NewMetrics
+	Metrics *Metrics
+	// traces every request when set, continuing traces callers pass in
+	// traceparent headers
+	Tracer *tracing.Tracer
+}
+
+// FieldError is a validation error for one field of a configuration.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// Validate reports every invalid field, each as a *FieldError.
+func (c Config) Validate() error {
+	var errs []error
+	if len(c.Addrs) == 0 && len(c.Listeners) == 0 {
+		errs = append(errs, &FieldError{"Addrs", "no addresses or listeners to serve on"})
+	}
+	for i, addr := range c.Addrs {
+		if addr == "" {
+			errs = append(errs, &FieldError{fmt.Sprintf("Addrs[%d]", i), "empty address"})
+		}
+	}
+	if c.TLS != nil {
+		if len(c.TLS.Certificates) == 0 {
+			errs = append(errs, &FieldError{"TLS.Certificates", "at least one certificate is needed"})
+		}
+		for i, cert := range c.TLS.Certificates {
+			if cert.CertFile == "" || cert.KeyFile == "" {
+				errs = append(errs, &FieldError{fmt.Sprintf("TLS.Certificates[%d]", i), "needs both a certificate and a key file"})
+			}
+		}
+		if c.TLS.RedirectPort < 0 || c.TLS.RedirectPort > 65535 {
+			errs = append(errs, &FieldError{"TLS.RedirectPort", fmt.Sprintf("%d isn't a valid port", c.TLS.RedirectPort)})
+		}
+	}
+	if c.ReadTimeout < 0 {
+		errs = append(errs, &FieldError{"ReadTimeout", "must not be negative"})
+	}
+	if c.WriteTimeout < 0 {
+		errs = append(errs, &FieldError{"WriteTimeout", "must not be negative"})
+	}
+	if c.MaxHeaderBytes < 0 {
+		errs = append(errs, &FieldError{"MaxHeaderBytes", "must not be negative"})
+	}
+	if c.MaxBodyBytes < 0 {
+		errs = append(errs, &FieldError{"MaxBodyBytes", "must not be negative"})
+	}
+	for _, f := range []struct {
+		name  string
+		value int64
+	}{
+		{"MaxConns", int64(c.MaxConns)},
+		{"MaxConnsPerIP", int64(c.MaxConnsPerIP)},
+		{"MaxConcurrentRequests", int64(c.MaxConcurrentRequests)},
+		{"MaxQueuedRequests", int64(c.MaxQueuedRequests)},
+		{"MaxQueueWait", int64(c.MaxQueueWait)},
+		{"OverloadRetryAfter", int64(c.OverloadRetryAfter)},
+	} {
+		if f.value < 0 {
+			errs = append(errs, &FieldError{f.name, "must not be negative"})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ServeConfig listens on everything cfg lists and serves handler with cfg's
+// timeouts and limits.
+func ServeConfig(cfg Config, handler Handler) (*Server, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid server config: %w", err)
+	}
+	listeners := append([]net.Listener(nil), cfg.Listeners...)
+	closeAll := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+	for _, addr := range cfg.Addrs {
+		l, err := Listen(addr)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+
+	var server *Server
+	var err error
+	if cfg.TLS != nil {
+		server, err = newTLSServer(handler, *cfg.TLS, listeners)
+	} else {
+		server = newServer(listeners, handler)
+	}
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	server.config = cfg
+	if cfg.ErrorTemplates != nil {
+		server.SetErrorTemplates(cfg.ErrorTemplates)
+	}
+	server.start()
+	return server, nil
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigValidate(t *testing.T) {
+	// Test: a valid config passes
+	assert.NoError(t, Config{Addrs: []string{":0"}, ReadTimeout: time.Second}.Validate())
+
+	// Test: every bad field is named
+	err := Config{
+		Addrs:          []string{":0", ""},
+		TLS:            &TLSConfig{Certificates: []CertFiles{{CertFile: "cert.pem"}}, RedirectPort: 70000},
+		ReadTimeout:    -time.Second,
+		MaxHeaderBytes: -1,
+	}.Validate()
+	require.Error(t, err)
+	var fields []string
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var fieldErr *FieldError
+		require.True(t, errors.As(e, &fieldErr))
+		fields = append(fields, fieldErr.Field)
+	}
+	assert.Equal(t, []string{"Addrs[1]", "TLS.Certificates[0]", "TLS.RedirectPort", "ReadTimeout", "MaxHeaderBytes"}, fields)
+	assert.Contains(t, err.Error(), "ReadTimeout: must not be negative")
+	assert.ErrorContains(t, Config{Addrs: []string{":0"}, MaxConnsPerIP: -1}.Validate(), "MaxConnsPerIP: must not be negative")
+
+	// Test: there has to be somewhere to listen
+	assert.ErrorContains(t, Config{}.Validate(), "Addrs")
+	_, err = ServeConfig(Config{}, writeOK)
+	assert.Error(t, err)
+}
+
+func TestServeConfigLimits(t *testing.T) {
+	s, err := ServeConfig(Config{
+		Addrs:          []string{"127.0.0.1:0"},
+		ReadTimeout:    100 * time.Millisecond,
+		MaxHeaderBytes: 128,
+		MaxBodyBytes:   8,
+	}, writeOK)
+	require.NoError(t, err)
+	defer s.Close()
+	addr := s.Addrs()[0].String()
+
+	for raw, status := range map[string]int{
+		"GET / HTTP/1.1\r\nHost: localhost\r\n\r\n":                                200,
+		"GET / HTTP/1.1\r\nHost: localhost\r\nX-Big: " + strings.Repeat("a", 200):  431,
+		"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 9\r\n\r\n123456789": 413,
+		"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 8\r\n\r\n1234":      408,
+		"GET / HTTP/1.1\r\nHost: loc":                                              408,
+	} {
+		conn := dialAndSend(t, addr, raw)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(t, err)
+		// Test: requests over the limits or too slow are refused
+		assert.Equal(t, status, resp.StatusCode, raw)
+	}
+}
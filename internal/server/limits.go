@@ -0,0 +1,194 @@
+package server
+
+import (
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+)
+
+// why a connection was turned away, for http_rejected_connections_total
+const (
+	rejectMaxConns      = "max_conns"
+	rejectMaxConnsPerIP = "max_conns_per_ip"
+	rejectOverload      = "overload"
+)
+
+const (
+	// most rejected connections answered at once, past that they're closed
+	// without a response
+	maxRejecting = 64
+	// how long answering a rejected connection may take
+	rejectTimeout = time.Second
+	// most of a rejected connection's request read before closing it
+	maxRejectDrain = 64 << 10
+)
+
+// connLimits enforces the caps on connections and running handlers in
+// Config. A nil *connLimits doesn't limit anything.
+type connLimits struct {
+	maxConns      int
+	maxConnsPerIP int
+	maxQueued     int
+	maxWait       time.Duration
+	retryAfter    string
+
+	mu    sync.Mutex
+	conns int
+	perIP map[string]int
+
+	// a token per running handler, nil without a limit
+	slots  chan struct{}
+	queued atomic.Int64
+	// a token per connection being rejected
+	rejecting chan struct{}
+}
+
+func newConnLimits(cfg Config) *connLimits {
+	retryAfter := cfg.OverloadRetryAfter
+	if retryAfter <= 0 {
+		retryAfter = time.Second
+	}
+	l := &connLimits{
+		maxConns:      cfg.MaxConns,
+		maxConnsPerIP: cfg.MaxConnsPerIP,
+		maxQueued:     cfg.MaxQueuedRequests,
+		maxWait:       cfg.MaxQueueWait,
+		retryAfter:    strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))),
+		perIP:         map[string]int{},
+		rejecting:     make(chan struct{}, maxRejecting),
+	}
+	if cfg.MaxConcurrentRequests > 0 {
+		l.slots = make(chan struct{}, cfg.MaxConcurrentRequests)
+	}
+	return l
+}
+
+// connIP is the client IP the per-IP cap applies to, "" for connections
+// that don't have one, like Unix sockets.
+func connIP(conn net.Conn) string {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return ""
+}
+
+// admit counts conn in if the caps leave room for it, and returns why not
+// otherwise. An admitted conn has to be released.
+func (l *connLimits) admit(conn net.Conn) (reason string) {
+	if l == nil {
+		return ""
+	}
+	ip := connIP(conn)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxConns > 0 && l.conns >= l.maxConns {
+		return rejectMaxConns
+	}
+	if ip != "" && l.maxConnsPerIP > 0 && l.perIP[ip] >= l.maxConnsPerIP {
+		return rejectMaxConnsPerIP
+	}
+	l.conns++
+	if ip != "" {
+		l.perIP[ip]++
+	}
+	return ""
+}
+
+func (l *connLimits) release(conn net.Conn) {
+	if l == nil {
+		return
+	}
+	ip := connIP(conn)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.conns--
+	if ip != "" {
+		if l.perIP[ip]--; l.perIP[ip] <= 0 {
+			delete(l.perIP, ip)
+		}
+	}
+}
+
+// acquire waits for a handler slot, false if the request should be shed
+// because the queue is full or the wait too long. A true has to be followed
+// by done once the handler returns.
+func (l *connLimits) acquire(m *Metrics) bool {
+	if l == nil || l.slots == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	n := l.queued.Add(1)
+	defer l.queued.Add(-1)
+	if l.maxQueued > 0 && n > int64(l.maxQueued) {
+		return false
+	}
+	m.queueChanged(1)
+	defer m.queueChanged(-1)
+	var timeout <-chan time.Time
+	if l.maxWait > 0 {
+		timer := time.NewTimer(l.maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timeout:
+		return false
+	}
+}
+
+func (l *connLimits) done() {
+	if l == nil || l.slots == nil {
+		return
+	}
+	<-l.slots
+}
+
+// writeOverloaded answers a request shed for lack of a handler slot.
+func (s *Server) writeOverloaded(w *response.Writer, req *request.Request) {
+	w.SetHeader("Retry-After", s.limits.retryAfter)
+	response.WriteError(w, req, response.StatusCodeServiceUnavailable, "The server is too busy right now, try again later.")
+}
+
+// reject answers a connection over the caps with 503 and closes it, without
+// reading more than it has to.
+func (s *Server) reject(conn net.Conn, reason string) {
+	s.config.Metrics.connRejected(reason)
+	select {
+	case s.limits.rejecting <- struct{}{}:
+	default:
+		// answering is as much work as we can spare already
+		conn.Close()
+		return
+	}
+	go func() {
+		defer func() { <-s.limits.rejecting }()
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(rejectTimeout))
+		w := response.NewWriter(conn)
+		if t := s.templates.Load(); t != nil {
+			w.SetTemplates(t)
+		}
+		w.SetHeader("Retry-After", s.limits.retryAfter)
+		response.WriteError(w, nil, response.StatusCodeServiceUnavailable, "The server is handling too many connections, try again later.")
+		w.Close()
+		// closing with the request unread would reset the connection and
+		// could lose the response, so let the client finish first
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+		io.Copy(io.Discard, io.LimitReader(conn, maxRejectDrain))
+	}()
+}
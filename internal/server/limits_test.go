@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/metrics"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	blockRequest = "GET /block HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	okRequest    = "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
+)
+
+// startLimitedServer serves with cfg's limits, holding requests for /block
+// until the returned channel is closed.
+func startLimitedServer(t *testing.T, cfg Config) (*Metrics, string, chan struct{}) {
+	t.Helper()
+	release := make(chan struct{})
+	handler := func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/block" {
+			<-release
+		}
+		writeOK(w, req)
+	}
+	cfg.Addrs = []string{"127.0.0.1:0"}
+	cfg.Metrics = NewMetrics(metrics.NewRegistry())
+	s, err := ServeConfig(cfg, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return cfg.Metrics, s.Addrs()[0].String(), release
+}
+
+func sendAndRead(t *testing.T, addr, raw string) *http.Response {
+	t.Helper()
+	resp, err := http.ReadResponse(bufio.NewReader(dialAndSend(t, addr, raw)), nil)
+	require.NoError(t, err)
+	io.ReadAll(resp.Body)
+	return resp
+}
+
+func TestConnectionLimits(t *testing.T) {
+	for name, tc := range map[string]struct {
+		cfg    Config
+		reason string
+	}{
+		"total":  {Config{MaxConns: 1}, rejectMaxConns},
+		"per IP": {Config{MaxConnsPerIP: 1}, rejectMaxConnsPerIP},
+	} {
+		t.Run(name, func(t *testing.T) {
+			m, addr, release := startLimitedServer(t, tc.cfg)
+			blocked := dialAndSend(t, addr, blockRequest)
+			require.Eventually(t, func() bool { return m.active.Value() == 1 }, time.Second, 5*time.Millisecond)
+
+			// Test: connections over the cap are answered 503 with Retry-After
+			resp := sendAndRead(t, addr, okRequest)
+			assert.Equal(t, 503, resp.StatusCode)
+			assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+			assert.Equal(t, float64(1), m.rejected.Value(tc.reason))
+
+			// Test: once a connection is done there's room again
+			close(release)
+			resp, err := http.ReadResponse(bufio.NewReader(blocked), nil)
+			require.NoError(t, err)
+			assert.Equal(t, 200, resp.StatusCode)
+			require.Eventually(t, func() bool { return m.active.Value() == 0 }, time.Second, 5*time.Millisecond)
+			assert.Equal(t, 200, sendAndRead(t, addr, okRequest).StatusCode)
+		})
+	}
+}
+
+func TestLoadShedding(t *testing.T) {
+	m, addr, release := startLimitedServer(t, Config{
+		MaxConcurrentRequests: 1,
+		MaxQueuedRequests:     1,
+		MaxQueueWait:          200 * time.Millisecond,
+		OverloadRetryAfter:    5 * time.Second,
+	})
+	blocked := dialAndSend(t, addr, blockRequest)
+	require.Eventually(t, func() bool { return m.active.Value() == 1 }, time.Second, 5*time.Millisecond)
+	queued := dialAndSend(t, addr, okRequest)
+	require.Eventually(t, func() bool { return m.queued.Value() == 1 }, time.Second, 5*time.Millisecond)
+
+	// Test: with the queue full requests are shed right away
+	start := time.Now()
+	resp := sendAndRead(t, addr, okRequest)
+	assert.Equal(t, 503, resp.StatusCode)
+	assert.Equal(t, "5", resp.Header.Get("Retry-After"))
+	assert.Less(t, time.Since(start), 150*time.Millisecond)
+
+	// Test: a queued request is shed after waiting too long
+	resp, err := http.ReadResponse(bufio.NewReader(queued), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 503, resp.StatusCode)
+	assert.Equal(t, float64(2), m.rejected.Value(rejectOverload))
+	assert.Equal(t, float64(0), m.queued.Value())
+
+	// Test: a queued request runs once a handler is free
+	queued = dialAndSend(t, addr, okRequest)
+	require.Eventually(t, func() bool { return m.queued.Value() == 1 }, time.Second, 5*time.Millisecond)
+	close(release)
+	resp, err = http.ReadResponse(bufio.NewReader(queued), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	resp, err = http.ReadResponse(bufio.NewReader(blocked), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// the first file descriptor passed by socket activation (SD_LISTEN_FDS_START)
+const listenFDsStart = 3
+
+// Listen opens a listener for addr: "unix:/path/to.sock" for a Unix domain
+// socket, otherwise a TCP "host:port" where ":8080" listens on every
+// interface and port 0 picks a free one.
+func Listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if path == "" {
+			return nil, fmt.Errorf("failed to create listener: empty unix socket path")
+		}
+		// a socket file left behind by a previous run would fail the bind
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create listener: %v", err)
+		}
+		return listener, nil
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create listener: %v", err)
+	}
+	return listener, nil
+}
+
+// InheritedListeners returns the sockets passed in by systemd-style socket
+// activation (LISTEN_PID and LISTEN_FDS), nil if there are none. The
+// variables are cleared so child processes don't pick the sockets up too.
+func InheritedListeners() ([]net.Listener, error) {
+	pidText, fdsText := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	if fdsText == "" {
+		return nil, nil
+	}
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	// the sockets are meant for the process with this pid, not for us
+	if pid, err := strconv.Atoi(pidText); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fdsText)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fdsText)
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make([]net.Listener, 0, n)
+	for i := range n {
+		fd := listenFDsStart + i
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		listener, err := net.FileListener(f)
+		// the listener holds its own copy of the descriptor
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("inherited socket %s isn't a listener: %v", name, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getOK(t *testing.T, network, addr string) int {
+	t.Helper()
+	conn, err := net.Dial(network, addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	return resp.StatusCode
+}
+
+func TestServeReportsChosenPort(t *testing.T) {
+	s, err := Serve(0, writeOK)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: port 0 reports the port that was picked
+	assert.NotZero(t, s.Port)
+	assert.Equal(t, s.Port, s.Addrs()[0].(*net.TCPAddr).Port)
+	assert.Equal(t, 200, getOK(t, "tcp", "127.0.0.1:"+strconv.Itoa(s.Port)))
+}
+
+func TestServeListeners(t *testing.T) {
+	tcp, err := Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	socket := filepath.Join(t.TempDir(), "server.sock")
+	// a stale socket from an earlier run doesn't get in the way
+	stale, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	unix, err := Listen("unix:" + socket)
+	require.NoError(t, err)
+
+	s, err := ServeListener(writeOK, tcp, unix)
+	require.NoError(t, err)
+
+	// Test: every listener is served and reported
+	addrs := s.Addrs()
+	require.Len(t, addrs, 2)
+	assert.Equal(t, "tcp", addrs[0].Network())
+	assert.Equal(t, "unix", addrs[1].Network())
+	assert.Equal(t, tcp.Addr().(*net.TCPAddr).Port, s.Port)
+	assert.Equal(t, 200, getOK(t, "tcp", addrs[0].String()))
+	assert.Equal(t, 200, getOK(t, "unix", socket))
+
+	// Test: Close stops all of them
+	require.NoError(t, s.Close())
+	_, err = net.Dial("tcp", addrs[0].String())
+	assert.Error(t, err)
+	_, err = net.Dial("unix", socket)
+	assert.Error(t, err)
+
+	// Test: there has to be something to serve on
+	_, err = ServeListener(writeOK)
+	assert.Error(t, err)
+	_, err = Listen("unix:")
+	assert.Error(t, err)
+}
+
+func TestInheritedListeners(t *testing.T) {
+	// Test: without socket activation there's nothing to inherit
+	t.Setenv("LISTEN_FDS", "")
+	listeners, err := InheritedListeners()
+	assert.NoError(t, err)
+	assert.Nil(t, listeners)
+
+	// Test: sockets meant for another process are left alone, the variables cleared
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err = InheritedListeners()
+	assert.NoError(t, err)
+	assert.Nil(t, listeners)
+	assert.Empty(t, os.Getenv("LISTEN_FDS"))
+
+	// Test: an invalid count is an error
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "many")
+	_, err = InheritedListeners()
+	assert.Error(t, err)
+}
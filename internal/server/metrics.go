@@ -0,0 +1,129 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/metrics"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+)
+
+// Metrics instruments a server, set it in Config.Metrics.
+type Metrics struct {
+	// names the route a request goes to for the route label. It has to
+	// return a small set of names, not raw paths. nil labels every request
+	// "all".
+	Route func(req *request.Request) string
+
+	requests      *metrics.Counter
+	duration      *metrics.Histogram
+	requestBytes  *metrics.Counter
+	responseBytes *metrics.Counter
+	connections   *metrics.Counter
+	active        *metrics.Gauge
+	parseErrors   *metrics.Counter
+	rejected      *metrics.Counter
+	queued        *metrics.Gauge
+}
+
+// parse error kinds for http_parse_errors_total
+const (
+	parseErrorHeaderTooLarge = "header_too_large"
+	parseErrorBodyTooLarge   = "body_too_large"
+	parseErrorTimeout        = "timeout"
+	parseErrorIncomplete     = "incomplete"
+	parseErrorMalformed      = "malformed"
+)
+
+// NewMetrics registers the server's metrics in reg.
+func NewMetrics(reg *metrics.Registry) *Metrics {
+	return &Metrics{
+		requests: reg.Counter("http_requests_total",
+			"Requests handled, by method, route and status code.", "method", "route", "status"),
+		duration: reg.Histogram("http_request_duration_seconds",
+			"Time from a request being read to its response being finished.", nil, "method", "route"),
+		requestBytes: reg.Counter("http_request_body_bytes_total",
+			"Bytes of request bodies read.", "method", "route"),
+		responseBytes: reg.Counter("http_response_body_bytes_total",
+			"Bytes of response bodies written, as sent.", "method", "route"),
+		connections: reg.Counter("http_connections_total",
+			"Connections accepted."),
+		active: reg.Gauge("http_active_connections",
+			"Connections being served, hijacked ones excluded."),
+		parseErrors: reg.Counter("http_parse_errors_total",
+			"Requests that couldn't be read, by kind: header_too_large, body_too_large, timeout, incomplete or malformed.", "kind"),
+		rejected: reg.Counter("http_rejected_connections_total",
+			"Connections answered 503 for being over a limit, by reason: max_conns, max_conns_per_ip or overload (no handler free in time).", "reason"),
+		queued: reg.Gauge("http_queued_requests",
+			"Requests waiting for a handler to be free."),
+	}
+}
+
+func (m *Metrics) connOpened() {
+	if m == nil {
+		return
+	}
+	m.connections.Inc()
+	m.active.Inc()
+}
+
+func (m *Metrics) connClosed() {
+	if m == nil {
+		return
+	}
+	m.active.Dec()
+}
+
+func (m *Metrics) parseError(err error) {
+	if m == nil {
+		return
+	}
+	m.parseErrors.Inc(parseErrorKind(err))
+}
+
+func (m *Metrics) connRejected(reason string) {
+	if m == nil {
+		return
+	}
+	m.rejected.Inc(reason)
+}
+
+func (m *Metrics) queueChanged(delta float64) {
+	if m == nil {
+		return
+	}
+	m.queued.Add(delta)
+}
+
+func (m *Metrics) requestDone(w *response.Writer, req *request.Request, start time.Time) {
+	if m == nil {
+		return
+	}
+	route := "all"
+	if m.Route != nil {
+		route = m.Route(req)
+	}
+	method := req.RequestLine.Method
+	m.requests.Inc(method, route, strconv.Itoa(int(w.Status())))
+	m.duration.Observe(time.Since(start).Seconds(), method, route)
+	m.requestBytes.Add(float64(len(req.Body)), method, route)
+	m.responseBytes.Add(float64(w.BytesWritten()), method, route)
+}
+
+func parseErrorKind(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, request.ErrHeaderTooLarge):
+		return parseErrorHeaderTooLarge
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return parseErrorBodyTooLarge
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return parseErrorTimeout
+	case errors.Is(err, request.ErrIncomplete):
+		return parseErrorIncomplete
+	}
+	return parseErrorMalformed
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/metrics"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics(metrics.NewRegistry())
+	m.Route = func(req *request.Request) string { return "pot" }
+	s, err := ServeConfig(Config{Addrs: []string{"127.0.0.1:0"}, MaxHeaderBytes: 256, Metrics: m}, writeTeapot)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	addr := s.Addrs()[0].String()
+
+	conn := dialAndSend(t, addr, "POST /pot HTTP/1.1\r\nHost: localhost\r\nContent-Length: 4\r\n\r\ntea!")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	io.ReadAll(resp.Body)
+
+	// Test: requests are counted by method, route and status, with their sizes
+	require.Eventually(t, func() bool { return m.requests.Value("POST", "pot", "418") == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, uint64(1), m.duration.Count("POST", "pot"))
+	assert.Equal(t, float64(4), m.requestBytes.Value("POST", "pot"))
+	assert.Equal(t, float64(11), m.responseBytes.Value("POST", "pot"))
+
+	// Test: connections are counted while open
+	assert.Equal(t, float64(1), m.connections.Value())
+	require.Eventually(t, func() bool { return m.active.Value() == 0 }, time.Second, 5*time.Millisecond)
+
+	// Test: requests that can't be read are counted by kind
+	for _, raw := range []string{
+		"NOT A REQUEST\r\n\r\n",
+		"GET / HTTP/1.1\r\nX-Big: " + strings.Repeat("a", 300) + "\r\n\r\n",
+	} {
+		conn := dialAndSend(t, addr, raw)
+		io.ReadAll(conn)
+	}
+	assert.Equal(t, float64(1), m.parseErrors.Value("malformed"))
+	assert.Equal(t, float64(1), m.parseErrors.Value("header_too_large"))
+	assert.Equal(t, float64(3), m.connections.Value())
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+)
+
+type Middleware func(Handler) Handler
+
+// Chain wraps handler with middleware, the first one being the outermost.
+func Chain(handler Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+func Compress(cfg response.CompressionConfig) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			w.EnableCompression(req, cfg)
+			next(w, req)
+		}
+	}
+}
@@ -1,23 +1,48 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
 	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
 )
 
 type Server struct {
-	listener net.Listener
-	Port     int
-	closed   atomic.Bool
-	handler  Handler
+	listeners []net.Listener
+	// the TCP port of the first listener, the chosen one when asked for 0
+	Port    int
+	closed  atomic.Bool
+	handler Handler
+	// custom error pages, nil uses the built-in ones
+	templates atomic.Pointer[response.Templates]
+
+	// connections the server is responsible for, hijacked ones are dropped
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+
+	// timeouts and limits, set by ServeConfig
+	config Config
+	// connection and handler caps from config, set up by start
+	limits *connLimits
+
+	// set by ServeTLS
+	redirect   *Server
+	stopReload chan struct{}
 }
 
+// how often Shutdown checks whether the remaining connections are done
+const shutdownPollInterval = 10 * time.Millisecond
+
 type HandlerError struct {
 	StatusCode response.StatusCode
 	Message    string
@@ -30,27 +55,113 @@ func Serve(port int, handler Handler) (*Server, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create listener: %v", err)
 	}
-	server := &Server{
-		listener: listener,
-		Port:     port,
-		handler:  handler,
+	return ServeListener(handler, listener)
+}
+
+// ServeListener serves on listeners the caller already opened, e.g. a Unix
+// socket, a specific interface or sockets passed in by systemd. The server
+// owns them from now on and closes them on Close.
+func ServeListener(handler Handler, listeners ...net.Listener) (*Server, error) {
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("no listeners to serve on")
 	}
-	go server.listen()
+	server := newServer(listeners, handler)
+	server.start()
 	return server, nil
 }
 
+func newServer(listeners []net.Listener, handler Handler) *Server {
+	server := &Server{
+		listeners: listeners,
+		handler:   handler,
+		conns:     map[net.Conn]struct{}{},
+	}
+	if addr, ok := listeners[0].Addr().(*net.TCPAddr); ok {
+		server.Port = addr.Port
+	}
+	return server
+}
+
+func (s *Server) start() {
+	s.limits = newConnLimits(s.config)
+	for _, l := range s.listeners {
+		go s.listen(l)
+	}
+}
+
+// Addrs returns the addresses the server listens on, in the order the
+// listeners were given.
+func (s *Server) Addrs() []net.Addr {
+	addrs := make([]net.Addr, len(s.listeners))
+	for i, l := range s.listeners {
+		addrs[i] = l.Addr()
+	}
+	return addrs
+}
+
+// Close stops accepting connections. Requests already in flight carry on.
 func (s *Server) Close() error {
-	err := s.listener.Close()
-	if err != nil {
-		return fmt.Errorf("failed to close server: %v", err)
+	if s.closed.Swap(true) {
+		return nil
+	}
+	if s.stopReload != nil {
+		close(s.stopReload)
+	}
+	if s.redirect != nil {
+		s.redirect.Close()
+	}
+	var errs []string
+	for _, l := range s.listeners {
+		if err := l.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to close server: %s", strings.Join(errs, "; "))
 	}
-	s.closed.Store(true)
 	return nil
 }
 
-func (s *Server) listen() {
+// Shutdown stops accepting connections and waits for in-flight requests to
+// finish. If ctx ends first, the remaining connections are closed and the
+// context's error returned. Hijacked connections aren't waited for.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
 	for {
-		conn, err := s.listener.Accept()
+		if s.ActiveConnections() == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			s.mu.Lock()
+			for conn := range s.conns {
+				conn.Close()
+			}
+			s.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+}
+
+// ActiveConnections counts the connections the server is currently serving.
+func (s *Server) ActiveConnections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+func (s *Server) SetErrorTemplates(t *response.Templates) {
+	s.templates.Store(t)
+}
+
+func (s *Server) listen(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
 		if err != nil {
 			if s.closed.Load() {
 				return
@@ -58,31 +169,115 @@ func (s *Server) listen() {
 			log.Printf("unable to accept connection: %v", err)
 			continue
 		}
-		go s.Handle(conn)
+		if reason := s.limits.admit(conn); reason != "" {
+			s.reject(conn, reason)
+			continue
+		}
+		go func() {
+			defer s.limits.release(conn)
+			s.Handle(conn)
+		}()
 	}
 }
 
 func (s *Server) Handle(conn net.Conn) {
-	defer conn.Close()
+	accepted := time.Now()
+	s.trackConn(conn)
+	m := s.config.Metrics
+	m.connOpened()
+	hijacked := false
+	defer func() {
+		if !hijacked {
+			conn.Close()
+			s.untrackConn(conn)
+			m.connClosed()
+		}
+	}()
 	respWriter := response.NewWriter(conn)
+	if t := s.templates.Load(); t != nil {
+		respWriter.SetTemplates(t)
+	}
 	// parse the request from the conn
-	req, err := request.RequestFromReader(conn)
+	if s.config.ReadTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(s.config.ReadTimeout))
+	}
+	req, err := request.ReadRequest(conn, request.Limits{
+		MaxHeaderBytes: s.config.MaxHeaderBytes,
+		MaxBodyBytes:   s.config.MaxBodyBytes,
+	})
 	if err != nil {
+		m.parseError(err)
 		hErr := &HandlerError{
 			Message:    err.Error(),
-			StatusCode: response.StatusCodeBadRequest,
+			StatusCode: parseErrorStatus(err),
 		}
-		hErr.Write(respWriter)
+		hErr.Write(respWriter, nil)
 		return
 	}
-	s.handler(respWriter, req)
+	conn.SetReadDeadline(time.Time{})
+	if s.config.WriteTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
+	}
+	req.RemoteAddr = conn.RemoteAddr().String()
+	_, req.TLS = conn.(*tls.Conn)
+	span := s.startSpan(req, accepted, time.Now())
+	respWriter.SetHijacker(func() (net.Conn, []byte, error) {
+		hijacked = true
+		// the handler owns the connection now, none of our deadlines apply
+		conn.SetDeadline(time.Time{})
+		s.untrackConn(conn)
+		m.connClosed()
+		return conn, req.Buffered(), nil
+	})
+	start := time.Now()
+	if s.limits.acquire(m) {
+		s.handler(respWriter, req)
+		s.limits.done()
+	} else {
+		m.connRejected(rejectOverload)
+		s.writeOverloaded(respWriter, req)
+	}
+	handlerEnd := time.Now()
+	if hijacked {
+		m.requestDone(respWriter, req, start)
+		finishSpan(span, respWriter, req, start, handlerEnd, time.Time{})
+		return
+	}
+	if err := respWriter.Close(); err != nil {
+		log.Printf("error finishing response: %v", err)
+	}
+	m.requestDone(respWriter, req, start)
+	finishSpan(span, respWriter, req, start, handlerEnd, time.Now())
+}
+
+// parseErrorStatus picks the status to answer a request that couldn't be read.
+func parseErrorStatus(err error) response.StatusCode {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, request.ErrHeaderTooLarge):
+		return response.StatusCodeHeaderFieldsTooLarge
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return response.StatusCodeContentTooLarge
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return response.StatusCodeRequestTimeout
+	}
+	return response.StatusCodeBadRequest
+}
+
+func (s *Server) trackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[conn] = struct{}{}
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
 }
 
-func (he *HandlerError) Write(w *response.Writer) {
-	w.WriteStatusLine(he.StatusCode)
-	headers := response.GetDefaultHeaders(len(he.Message))
-	headers.Set("Content-Type", "text/html")
-	w.WriteHeaders(headers)
-	body := response.BuildResponseBody(he.StatusCode, he.Message)
-	w.WriteBody(body)
+// Write renders the error in the representation req asks for. req may be nil
+// if the error happened before the request could be parsed.
+func (he *HandlerError) Write(w *response.Writer, req *request.Request) {
+	response.WriteError(w, req, he.StatusCode, he.Message)
 }
@@ -1 +1,133 @@
 package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startTestServer(t *testing.T, handler Handler) (*Server, string) {
+	t.Helper()
+	s, err := Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s, s.Addrs()[0].String()
+}
+
+func dialAndSend(t *testing.T, addr, raw string) net.Conn {
+	t.Helper()
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	_, err = client.Write([]byte(raw))
+	require.NoError(t, err)
+	return client
+}
+
+func writeOK(w *response.Writer, _ *request.Request) {
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	w.WriteHeaders(response.GetDefaultHeaders(0))
+	w.WriteBody(nil)
+}
+
+func TestHijack(t *testing.T) {
+	type hijackResult struct {
+		conn     net.Conn
+		buffered []byte
+	}
+	results := make(chan hijackResult, 1)
+	handlerReturned := make(chan struct{})
+	s, addr := startTestServer(t, func(w *response.Writer, req *request.Request) {
+		defer close(handlerReturned)
+		conn, buffered, err := w.Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		_, err = w.WriteBody([]byte("too late"))
+		assert.Error(t, err)
+		results <- hijackResult{conn: conn, buffered: buffered}
+	})
+
+	// the bytes after the request arrive in the same read as the request
+	client := dialAndSend(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\nHELLO")
+	res := <-results
+	<-handlerReturned
+	defer res.conn.Close()
+
+	// hijacked connections aren't the server's to wait for anymore
+	assert.Equal(t, 0, s.ActiveConnections())
+
+	rest := make([]byte, len("HELLO")-len(res.buffered))
+	_, err := io.ReadFull(res.conn, rest)
+	require.NoError(t, err)
+	assert.Equal(t, "HELLO", string(res.buffered)+string(rest))
+
+	// and the server didn't close it when the handler returned
+	time.Sleep(20 * time.Millisecond)
+	_, err = res.conn.Write([]byte("still here"))
+	require.NoError(t, err)
+	buf := make([]byte, len("still here"))
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = io.ReadFull(client, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "still here", string(buf))
+}
+
+func TestShutdownWaitsForRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s, addr := startTestServer(t, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+		writeOK(w, req)
+	})
+	client := dialAndSend(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	<-started
+
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(context.Background()) }()
+	select {
+	case <-done:
+		t.Fatal("shutdown returned while a request was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	require.NoError(t, <-done)
+	resp, err := io.ReadAll(client)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(resp), "HTTP/1.1 200 OK\r\n"))
+
+	// no new connections once shut down
+	_, err = net.Dial("tcp", addr)
+	assert.Error(t, err)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	block := make(chan struct{})
+	t.Cleanup(func() { close(block) })
+	started := make(chan struct{})
+	s, addr := startTestServer(t, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-block
+	})
+	client := dialAndSend(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+
+	// the stuck connection was closed on the client
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err := client.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
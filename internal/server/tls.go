@@ -0,0 +1,263 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+)
+
+type TLSConfig struct {
+	// certificate/key pairs in PEM files. With several pairs the one matching
+	// the client's SNI name is served, the first one if none match.
+	Certificates []CertFiles
+	// how often the files are checked for changes, 0 means
+	// DefaultReloadInterval and a negative value turns reloading off
+	ReloadInterval time.Duration
+	// if set, plain HTTP on this port is redirected to HTTPS
+	RedirectPort int
+}
+
+type CertFiles struct {
+	CertFile string
+	KeyFile  string
+}
+
+const DefaultReloadInterval = 10 * time.Second
+
+// ServeTLS is like Serve, but terminates TLS on the connections.
+func ServeTLS(port int, handler Handler, cfg TLSConfig) (*Server, error) {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create listener: %v", err)
+	}
+	server, err := ServeTLSListener(handler, cfg, listener)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return server, nil
+}
+
+// ServeTLSListener is like ServeListener, but terminates TLS on the
+// connections. RedirectPort redirects to the first listener's port.
+func ServeTLSListener(handler Handler, cfg TLSConfig, listeners ...net.Listener) (*Server, error) {
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("no listeners to serve on")
+	}
+	server, err := newTLSServer(handler, cfg, listeners)
+	if err != nil {
+		return nil, err
+	}
+	server.start()
+	return server, nil
+}
+
+// newTLSServer sets up a server that terminates TLS on listeners, without
+// accepting connections yet.
+func newTLSServer(handler Handler, cfg TLSConfig, listeners []net.Listener) (*Server, error) {
+	certs, err := NewCertStore(cfg.Certificates...)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		// the server only speaks HTTP/1.1, so clients mustn't pick h2
+		NextProtos: []string{"http/1.1"},
+		MinVersion: tls.VersionTLS12,
+	}
+	tlsListeners := make([]net.Listener, len(listeners))
+	for i, l := range listeners {
+		tlsListeners[i] = tls.NewListener(l, tlsConfig)
+	}
+	server := newServer(tlsListeners, handler)
+
+	if cfg.RedirectPort != 0 {
+		httpsPort := server.Port
+		if httpsPort == 0 {
+			return nil, fmt.Errorf("redirecting to HTTPS needs a TCP listener")
+		}
+		redirect, err := Serve(cfg.RedirectPort, RedirectToHTTPS(httpsPort))
+		if err != nil {
+			return nil, err
+		}
+		server.redirect = redirect
+	}
+	interval := cfg.ReloadInterval
+	if interval == 0 {
+		interval = DefaultReloadInterval
+	}
+	if interval > 0 {
+		server.stopReload = make(chan struct{})
+		go certs.watch(interval, server.stopReload)
+	}
+	return server, nil
+}
+
+// RedirectToHTTPS answers every request with a redirect to the same target
+// over HTTPS on httpsPort.
+func RedirectToHTTPS(httpsPort int) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		host, _ := req.Headers.Get("Host")
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		}
+		if host == "" {
+			response.WriteError(w, req, response.StatusCodeBadRequest, "The request has no Host to redirect to.")
+			return
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		if httpsPort != 443 {
+			host += ":" + strconv.Itoa(httpsPort)
+		}
+		target := req.RequestLine.RequestTarget
+		if !strings.HasPrefix(target, "/") {
+			target = "/"
+		}
+
+		// 308 keeps the method and body, browsers handle 301 better for GET
+		status := response.StatusCodePermanentRedirect
+		if req.RequestLine.Method == "GET" || req.RequestLine.Method == "HEAD" {
+			status = response.StatusCodeMovedPermanently
+		}
+		h := response.GetDefaultHeaders(0)
+		h.Set("Location", "https://"+host+target)
+		w.WriteStatusLine(status)
+		w.WriteHeaders(h)
+		w.WriteBody(nil)
+	}
+}
+
+// CertStore holds certificates loaded from files, picks one per connection by
+// SNI name and reloads them when the files change.
+type CertStore struct {
+	files []CertFiles
+
+	mu      sync.RWMutex
+	certs   []*tls.Certificate
+	modTime []time.Time
+}
+
+func NewCertStore(files ...CertFiles) (*CertStore, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no certificates configured")
+	}
+	s := &CertStore{
+		files:   files,
+		certs:   make([]*tls.Certificate, len(files)),
+		modTime: make([]time.Time, len(files)),
+	}
+	for i := range files {
+		if err := s.load(i); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// GetCertificate fits tls.Config.GetCertificate.
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if hello.ServerName != "" {
+		for _, cert := range s.certs {
+			if hello.SupportsCertificate(cert) == nil && cert.Leaf.VerifyHostname(hello.ServerName) == nil {
+				return cert, nil
+			}
+		}
+	}
+	return s.certs[0], nil
+}
+
+// Reload loads the pairs whose files changed since they were last loaded. A
+// pair that fails to load keeps its previous certificate.
+func (s *CertStore) Reload() error {
+	var errs []string
+	for i := range s.files {
+		modTime, err := s.latestModTime(i)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		s.mu.RLock()
+		changed := !modTime.Equal(s.modTime[i])
+		s.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := s.load(i); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to reload certificates: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (s *CertStore) watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := s.Reload(); err != nil {
+				log.Printf("tls: %v", err)
+			}
+		}
+	}
+}
+
+func (s *CertStore) load(i int) error {
+	f := s.files[i]
+	// read the times first, a change during loading gets picked up next time
+	modTime, err := s.latestModTime(i)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %s: %v", f.CertFile, err)
+	}
+	if cert.Leaf == nil {
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse certificate %s: %v", f.CertFile, err)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.certs[i] = &cert
+	s.modTime[i] = modTime
+	return nil
+}
+
+// latestModTime is the later modification time of a pair's two files.
+func (s *CertStore) latestModTime(i int) (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{s.files[i].CertFile, s.files[i].KeyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to read certificate file: %v", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSelfSigned writes a self-signed certificate for hosts and its key to
+// dir, returning the files.
+func writeSelfSigned(t *testing.T, dir, name string, hosts ...string) CertFiles {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		DNSNames:     hosts,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	files := CertFiles{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+	require.NoError(t, os.WriteFile(files.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(files.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return files
+}
+
+// dialTLS connects with SNI serverName and returns the certificate served.
+func dialTLS(t *testing.T, addr, serverName string) (*tls.Conn, *x509.Certificate) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"h2", "http/1.1"},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn, conn.ConnectionState().PeerCertificates[0]
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	a := writeSelfSigned(t, dir, "a", "a.test")
+	b := writeSelfSigned(t, dir, "b", "b.test", "*.b.test")
+	s, err := ServeTLS(0, writeOK, TLSConfig{Certificates: []CertFiles{a, b}})
+	require.NoError(t, err)
+	defer s.Close()
+	addr := s.Addrs()[0].String()
+
+	// Test: requests are served over TLS with http/1.1 negotiated
+	conn, cert := dialTLS(t, addr, "a.test")
+	assert.Equal(t, "a.test", cert.Subject.CommonName)
+	assert.Equal(t, "http/1.1", conn.ConnectionState().NegotiatedProtocol)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: a.test\r\n\r\n")
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	// Test: the certificate is picked by SNI name, wildcards included
+	_, cert = dialTLS(t, addr, "b.test")
+	assert.Equal(t, "b.test", cert.Subject.CommonName)
+	_, cert = dialTLS(t, addr, "www.b.test")
+	assert.Equal(t, "b.test", cert.Subject.CommonName)
+
+	// Test: unknown or missing names get the first certificate
+	_, cert = dialTLS(t, addr, "unknown.test")
+	assert.Equal(t, "a.test", cert.Subject.CommonName)
+	_, cert = dialTLS(t, addr, "")
+	assert.Equal(t, "a.test", cert.Subject.CommonName)
+}
+
+func TestCertReload(t *testing.T) {
+	dir := t.TempDir()
+	files := writeSelfSigned(t, dir, "site", "old.test")
+	s, err := ServeTLS(0, writeOK, TLSConfig{
+		Certificates:   []CertFiles{files},
+		ReloadInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer s.Close()
+	addr := s.Addrs()[0].String()
+
+	_, cert := dialTLS(t, addr, "old.test")
+	assert.Equal(t, "old.test", cert.Subject.CommonName)
+
+	// Test: replaced files are picked up without a restart
+	writeSelfSigned(t, dir, "site", "new.test")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(files.CertFile, future, future))
+	assert.Eventually(t, func() bool {
+		_, cert := dialTLS(t, addr, "new.test")
+		return cert.Subject.CommonName == "new.test"
+	}, 2*time.Second, 20*time.Millisecond)
+
+	// Test: a broken replacement keeps the last good certificate
+	require.NoError(t, os.WriteFile(files.CertFile, []byte("not a certificate"), 0o600))
+	later := future.Add(time.Minute)
+	require.NoError(t, os.Chtimes(files.CertFile, later, later))
+	// give the watcher a few rounds to try
+	time.Sleep(50 * time.Millisecond)
+	_, cert = dialTLS(t, addr, "new.test")
+	assert.Equal(t, "new.test", cert.Subject.CommonName)
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	_, addr := startTestServer(t, RedirectToHTTPS(8443))
+
+	for raw, want := range map[string]struct {
+		status   int
+		location string
+	}{
+		"GET /path?q=1 HTTP/1.1\r\nHost: example.com:8080\r\n\r\n": {301, "https://example.com:8443/path?q=1"},
+		"POST /form HTTP/1.1\r\nHost: example.com\r\n\r\n":         {308, "https://example.com:8443/form"},
+		"GET / HTTP/1.1\r\nHost: [::1]:8080\r\n\r\n":               {301, "https://[::1]:8443/"},
+		"GET / HTTP/1.1\r\n\r\n":                                   {400, ""},
+	} {
+		conn := dialAndSend(t, addr, raw)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(t, err)
+		// Test: plain HTTP is sent to the same target over HTTPS
+		assert.Equal(t, want.status, resp.StatusCode, raw)
+		assert.Equal(t, want.location, resp.Header.Get("Location"), raw)
+	}
+
+	// Test: the default HTTPS port is left out of the Location
+	_, addr = startTestServer(t, RedirectToHTTPS(443))
+	conn := dialAndSend(t, addr, "GET /x HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/x", resp.Header.Get("Location"))
+}
+
+func TestServeTLSRedirectListener(t *testing.T) {
+	dir := t.TempDir()
+	// grab a free port for the redirect listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	redirectPort := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	s, err := ServeTLS(0, writeOK, TLSConfig{
+		Certificates: []CertFiles{writeSelfSigned(t, dir, "a", "a.test")},
+		RedirectPort: redirectPort,
+	})
+	require.NoError(t, err)
+
+	// Test: the redirect listener runs next to the TLS one and closes with it
+	conn := dialAndSend(t, l.Addr().String(), "GET / HTTP/1.1\r\nHost: a.test\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 301, resp.StatusCode)
+	require.NoError(t, s.Close())
+	_, err = net.DialTimeout("tcp", l.Addr().String(), time.Second)
+	assert.Error(t, err)
+}
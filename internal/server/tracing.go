@@ -0,0 +1,47 @@
+package server
+
+import (
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/tracing"
+)
+
+// startSpan starts the span for serving req, nil without a tracer. The
+// span replaces the caller's in req's traceparent header, so whatever the
+// handler calls with those headers joins the trace under it.
+func (s *Server) startSpan(req *request.Request, parseStart, parseEnd time.Time) *tracing.Span {
+	t := s.config.Tracer
+	if t == nil {
+		return nil
+	}
+	parent, _ := tracing.Extract(req.Headers)
+	span := t.Start("HTTP "+req.RequestLine.Method, parent, parseStart)
+	span.AddPhase("parse", parseStart, parseEnd)
+	span.SetAttribute("http.method", req.RequestLine.Method)
+	span.SetAttribute("http.target", req.RequestLine.RequestTarget)
+	span.SetAttribute("net.peer.addr", req.RemoteAddr)
+	tracing.Inject(req.Headers, span.Context)
+	return span
+}
+
+// finishSpan records the handler and write phases and ends span. A zero
+// writeEnd means the connection was hijacked and there's no write phase.
+func finishSpan(span *tracing.Span, w *response.Writer, req *request.Request, handlerStart, handlerEnd, writeEnd time.Time) {
+	if span == nil {
+		return
+	}
+	span.AddPhase("handler", handlerStart, handlerEnd)
+	end := handlerEnd
+	if !writeEnd.IsZero() {
+		span.AddPhase("write", handlerEnd, writeEnd)
+		end = writeEnd
+	}
+	span.SetAttribute("http.status_code", int(w.Status()))
+	span.SetAttribute("http.response_body_bytes", w.BytesWritten())
+	if id, ok := req.Headers.Get(RequestIDHeader); ok {
+		span.SetAttribute("request_id", id)
+	}
+	span.Finish(end)
+}
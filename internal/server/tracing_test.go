@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*tracing.Span
+}
+
+func (r *spanRecorder) Export(span *tracing.Span) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, span)
+	return nil
+}
+
+func (r *spanRecorder) recorded() []*tracing.Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*tracing.Span(nil), r.spans...)
+}
+
+func TestTracing(t *testing.T) {
+	var recorder spanRecorder
+	var seen string
+	handler := func(w *response.Writer, req *request.Request) {
+		seen, _ = req.Headers.Get("traceparent")
+		writeTeapot(w, req)
+	}
+	s, err := ServeConfig(Config{Addrs: []string{"127.0.0.1:0"}, Tracer: tracing.NewTracer(&recorder)}, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	conn := dialAndSend(t, s.Addrs()[0].String(), "GET /pot HTTP/1.1\r\nHost: localhost\r\n"+
+		"traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	io.ReadAll(resp.Body)
+	require.Eventually(t, func() bool { return len(recorder.recorded()) == 1 }, time.Second, 5*time.Millisecond)
+	span := recorder.recorded()[0]
+
+	// Test: the span continues the caller's trace
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.Context.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", span.Parent.String())
+	assert.Equal(t, "HTTP GET", span.Name)
+	assert.Equal(t, 418, span.Attributes["http.status_code"])
+	assert.Equal(t, "/pot", span.Attributes["http.target"])
+
+	// Test: the handler sees the server's span as the parent to pass on
+	assert.Equal(t, span.Context.Traceparent(), seen)
+
+	// Test: the parse, handler and write phases are timed in order
+	require.Len(t, span.Phases, 3)
+	for i, name := range []string{"parse", "handler", "write"} {
+		assert.Equal(t, name, span.Phases[i].Name)
+		assert.False(t, span.Phases[i].End.Before(span.Phases[i].Start))
+	}
+	assert.Equal(t, span.Start, span.Phases[0].Start)
+	assert.Equal(t, span.End, span.Phases[2].End)
+}
@@ -0,0 +1,189 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+)
+
+// W3C Trace Context headers (https://www.w3.org/TR/trace-context/)
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+// FlagSampled is the trace flag telling that the caller records the trace.
+const FlagSampled byte = 0x01
+
+// most list members a tracestate may carry
+const maxTracestateMembers = 32
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+func (id TraceID) IsZero() bool { return id == TraceID{} }
+func (id SpanID) IsZero() bool  { return id == SpanID{} }
+
+func (id TraceID) MarshalText() ([]byte, error) { return []byte(id.String()), nil }
+
+// MarshalText leaves a zero ID empty, so root spans have no parent in JSON.
+func (id SpanID) MarshalText() ([]byte, error) {
+	if id.IsZero() {
+		return []byte{}, nil
+	}
+	return []byte(id.String()), nil
+}
+
+// SpanContext is what's propagated between services for a span.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   byte
+	// the vendor-specific tracestate, passed on unchanged
+	State string
+}
+
+func (sc SpanContext) IsValid() bool {
+	return !sc.TraceID.IsZero() && !sc.SpanID.IsZero()
+}
+
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&FlagSampled != 0
+}
+
+// Traceparent formats sc as a version 00 traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// ParseTraceparent parses a traceparent header value. Versions after 00 are
+// read the way 00 is, ignoring anything they add at the end, as the spec
+// asks.
+func ParseTraceparent(v string) (SpanContext, error) {
+	var sc SpanContext
+	v = strings.TrimSpace(v)
+	// version-traceid-spanid-flags is 2+1+32+1+16+1+2 characters
+	if len(v) < 55 || v[2] != '-' || v[35] != '-' || v[52] != '-' {
+		return sc, ErrInvalidTraceparent
+	}
+	version, ok := decodeLowerHex(v[:2])
+	if !ok || version[0] == 0xff {
+		return sc, ErrInvalidTraceparent
+	}
+	if version[0] == 0 && len(v) != 55 {
+		return sc, ErrInvalidTraceparent
+	}
+	if len(v) > 55 && v[55] != '-' {
+		return sc, ErrInvalidTraceparent
+	}
+	traceID, ok1 := decodeLowerHex(v[3:35])
+	spanID, ok2 := decodeLowerHex(v[36:52])
+	flags, ok3 := decodeLowerHex(v[53:55])
+	if !ok1 || !ok2 || !ok3 {
+		return sc, ErrInvalidTraceparent
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Flags = flags[0]
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	return sc, nil
+}
+
+// decodeLowerHex decodes s, which the spec requires to be lowercase.
+func decodeLowerHex(s string) ([]byte, bool) {
+	if strings.ToLower(s) != s {
+		return nil, false
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
+
+var ErrInvalidTracestate = errors.New("invalid tracestate")
+
+var (
+	tracestateKey   = regexp.MustCompile(`^([a-z][a-z0-9_\-*/]{0,255}|[a-z0-9][a-z0-9_\-*/]{0,240}@[a-z][a-z0-9_\-*/]{0,13})$`)
+	tracestateValue = regexp.MustCompile(`^[\x20-\x2b\x2d-\x3c\x3e-\x7e]{0,255}[\x21-\x2b\x2d-\x3c\x3e-\x7e]$`)
+)
+
+// ParseTracestate validates a tracestate header value and returns it with
+// empty list members and surrounding whitespace removed. Keys may only
+// appear once and there may be at most 32 members.
+func ParseTracestate(v string) (string, error) {
+	var members []string
+	seen := map[string]bool{}
+	for _, member := range strings.Split(v, ",") {
+		member = strings.Trim(member, " \t")
+		if member == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(member, "=")
+		if !ok || !tracestateKey.MatchString(key) || !tracestateValue.MatchString(value) {
+			return "", fmt.Errorf("%w: bad list member %q", ErrInvalidTracestate, member)
+		}
+		if seen[key] {
+			return "", fmt.Errorf("%w: duplicate key %q", ErrInvalidTracestate, key)
+		}
+		seen[key] = true
+		members = append(members, member)
+	}
+	if len(members) > maxTracestateMembers {
+		return "", fmt.Errorf("%w: %d list members, at most %d are allowed", ErrInvalidTracestate, len(members), maxTracestateMembers)
+	}
+	return strings.Join(members, ","), nil
+}
+
+// Extract reads the span context a caller sent in h. An invalid traceparent
+// means there's none, an invalid tracestate is dropped on its own.
+func Extract(h headers.Headers) (SpanContext, bool) {
+	parent, ok := h.Get(TraceparentHeader)
+	if !ok {
+		return SpanContext{}, false
+	}
+	sc, err := ParseTraceparent(parent)
+	if err != nil {
+		return SpanContext{}, false
+	}
+	if state, ok := h.Get(TracestateHeader); ok {
+		sc.State, _ = ParseTracestate(state)
+	}
+	return sc, true
+}
+
+// Inject sets the traceparent and tracestate headers for sc on h.
+func Inject(h headers.Headers, sc SpanContext) {
+	h.Set(TraceparentHeader, sc.Traceparent())
+	if sc.State != "" {
+		h.Set(TracestateHeader, sc.State)
+	} else {
+		h.Delete(TracestateHeader)
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for id.IsZero() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for id.IsZero() {
+		rand.Read(id[:])
+	}
+	return id
+}
@@ -0,0 +1,98 @@
+package tracing
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const validTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceparent(t *testing.T) {
+	// Test: a valid header is parsed and formats back the same
+	sc, err := ParseTraceparent(validTraceparent)
+	require.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled())
+	assert.Equal(t, validTraceparent, sc.Traceparent())
+
+	// Test: later versions are read like 00, ignoring what they add
+	sc, err = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	require.NoError(t, err)
+	assert.False(t, sc.Sampled())
+
+	// Test: anything malformed is rejected
+	for _, v := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01extra",
+		"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+		validTraceparent + ", " + validTraceparent,
+	} {
+		_, err := ParseTraceparent(v)
+		assert.ErrorIs(t, err, ErrInvalidTraceparent, v)
+	}
+}
+
+func TestParseTracestate(t *testing.T) {
+	// Test: valid members are kept in order, empty ones and whitespace dropped
+	state, err := ParseTracestate("rojo=00f067aa0ba902b7, ,congo=t61rcWkgMzE,\tvendor@sys=a b")
+	require.NoError(t, err)
+	assert.Equal(t, "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE,vendor@sys=a b", state)
+
+	// Test: bad keys, values, duplicates and too many members are rejected
+	tooMany := make([]string, 33)
+	for i := range tooMany {
+		tooMany[i] = "k" + strings.Repeat("x", i) + "=v"
+	}
+	for _, v := range []string{
+		"Rojo=1",
+		"rojo",
+		"rojo=a=b",
+		"rojo=",
+		"rojo=1,rojo=2",
+		strings.Join(tooMany, ","),
+	} {
+		_, err := ParseTracestate(v)
+		assert.ErrorIs(t, err, ErrInvalidTracestate, v)
+	}
+}
+
+func TestExtractInject(t *testing.T) {
+	h := headers.NewHeaders()
+	h.Set("traceparent", validTraceparent)
+	h.Set("tracestate", "rojo=1")
+
+	// Test: the caller's context is read from the headers
+	sc, ok := Extract(h)
+	require.True(t, ok)
+	assert.Equal(t, "rojo=1", sc.State)
+
+	// Test: an invalid tracestate is dropped on its own
+	h.Set("tracestate", "Bad=1")
+	sc, ok = Extract(h)
+	require.True(t, ok)
+	assert.Empty(t, sc.State)
+
+	// Test: an invalid traceparent means there's no context
+	h.Set("traceparent", "garbage")
+	_, ok = Extract(h)
+	assert.False(t, ok)
+
+	// Test: injecting replaces both headers
+	sc.State = ""
+	Inject(h, sc)
+	got, _ := h.Get("traceparent")
+	assert.Equal(t, validTraceparent, got)
+	_, ok = h.Get("tracestate")
+	assert.False(t, ok)
+}
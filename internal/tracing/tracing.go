@@ -0,0 +1,148 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// Exporter sends finished spans somewhere. Export is called from the
+// goroutine that ended the span, so it should be quick or hand off.
+type Exporter interface {
+	Export(span *Span) error
+}
+
+// Tracer starts spans and exports them when they end. Only sampled spans
+// are exported.
+type Tracer struct {
+	exporter Exporter
+}
+
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// Span is one timed operation, e.g. serving a request or calling an
+// upstream. Its fields are only safe to read once it has ended.
+type Span struct {
+	Name    string      `json:"name"`
+	Context SpanContext `json:"-"`
+	// zero for the root span of a trace
+	Parent     SpanID         `json:"parent_span_id"`
+	Start      time.Time      `json:"start"`
+	End        time.Time      `json:"end"`
+	Phases     []Phase        `json:"phases,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+
+	tracer *Tracer
+	mu     sync.Mutex
+	ended  bool
+}
+
+// Phase is a named part of a span, e.g. parsing the request.
+type Phase struct {
+	Name  string    `json:"name"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+func (p Phase) Duration() time.Duration { return p.End.Sub(p.Start) }
+
+// Start begins a span at start, as a child of parent or as the root of a new
+// trace if parent isn't valid. A new trace is sampled, a child keeps its
+// parent's flags and tracestate.
+func (t *Tracer) Start(name string, parent SpanContext, start time.Time) *Span {
+	s := &Span{
+		Name:   name,
+		Start:  start,
+		tracer: t,
+	}
+	if parent.IsValid() {
+		s.Context = SpanContext{TraceID: parent.TraceID, Flags: parent.Flags, State: parent.State}
+		s.Parent = parent.SpanID
+	} else {
+		s.Context = SpanContext{TraceID: newTraceID(), Flags: FlagSampled}
+	}
+	s.Context.SpanID = newSpanID()
+	return s
+}
+
+// SetAttribute records a value describing the span, e.g. a status code.
+func (s *Span) SetAttribute(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Attributes == nil {
+		s.Attributes = map[string]any{}
+	}
+	s.Attributes[key] = value
+}
+
+func (s *Span) AddPhase(name string, start, end time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Phases = append(s.Phases, Phase{Name: name, Start: start, End: end})
+}
+
+// Finish ends the span at end and exports it. Only the first call counts.
+func (s *Span) Finish(end time.Time) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = end
+	s.mu.Unlock()
+	if s.tracer.exporter == nil || !s.Context.Sampled() {
+		return
+	}
+	if err := s.tracer.exporter.Export(s); err != nil {
+		log.Printf("tracing: error exporting span %s: %v", s.Context.SpanID, err)
+	}
+}
+
+func (s *Span) Duration() time.Duration { return s.End.Sub(s.Start) }
+
+// MarshalJSON adds the IDs and durations in milliseconds to the fields.
+func (s *Span) MarshalJSON() ([]byte, error) {
+	type phase struct {
+		Phase
+		DurationMS float64 `json:"duration_ms"`
+	}
+	type span Span
+	phases := make([]phase, len(s.Phases))
+	for i, p := range s.Phases {
+		phases[i] = phase{p, milliseconds(p.Duration())}
+	}
+	return json.Marshal(struct {
+		TraceID    TraceID `json:"trace_id"`
+		SpanID     SpanID  `json:"span_id"`
+		Sampled    bool    `json:"sampled"`
+		DurationMS float64 `json:"duration_ms"`
+		*span
+		Phases []phase `json:"phases,omitempty"`
+	}{s.Context.TraceID, s.Context.SpanID, s.Context.Sampled(), milliseconds(s.Duration()), (*span)(s), phases})
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// JSONExporter writes each span as a line of JSON, e.g. to os.Stdout for
+// local debugging.
+type JSONExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{enc: json.NewEncoder(w)}
+}
+
+func (e *JSONExporter) Export(span *Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.enc.Encode(span)
+}
@@ -0,0 +1,56 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracer(t *testing.T) {
+	var out bytes.Buffer
+	tracer := NewTracer(NewJSONExporter(&out))
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// Test: without a parent a new sampled trace is started
+	root := tracer.Start("HTTP GET", SpanContext{}, start)
+	assert.True(t, root.Context.IsValid())
+	assert.True(t, root.Context.Sampled())
+	assert.True(t, root.Parent.IsZero())
+
+	// Test: a child joins its parent's trace with its own span ID
+	parent, err := ParseTraceparent(validTraceparent)
+	require.NoError(t, err)
+	parent.State = "rojo=1"
+	child := tracer.Start("HTTP GET", parent, start)
+	assert.Equal(t, parent.TraceID, child.Context.TraceID)
+	assert.Equal(t, parent.SpanID, child.Parent)
+	assert.NotEqual(t, parent.SpanID, child.Context.SpanID)
+	assert.Equal(t, "rojo=1", child.Context.State)
+
+	// Test: finished spans are exported as JSON lines, once
+	child.AddPhase("parse", start, start.Add(2*time.Millisecond))
+	child.SetAttribute("http.status_code", 200)
+	child.Finish(start.Add(5 * time.Millisecond))
+	child.Finish(start.Add(time.Second))
+	var exported map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &exported))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", exported["trace_id"])
+	assert.Equal(t, child.Context.SpanID.String(), exported["span_id"])
+	assert.Equal(t, "00f067aa0ba902b7", exported["parent_span_id"])
+	assert.Equal(t, float64(5), exported["duration_ms"])
+	assert.Equal(t, map[string]any{"http.status_code": float64(200)}, exported["attributes"])
+	phases := exported["phases"].([]any)
+	require.Len(t, phases, 1)
+	assert.Equal(t, "parse", phases[0].(map[string]any)["name"])
+	assert.Equal(t, float64(2), phases[0].(map[string]any)["duration_ms"])
+
+	// Test: spans the caller didn't sample aren't exported
+	out.Reset()
+	parent.Flags = 0
+	tracer.Start("HTTP GET", parent, start).Finish(start)
+	assert.Empty(t, out.String())
+}
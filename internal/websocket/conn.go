@@ -0,0 +1,400 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+// frame opcodes from RFC 6455 5.2
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// close codes from RFC 6455 7.4.1
+const (
+	CloseNormalClosure    = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseUnsupportedData  = 1003
+	CloseNoStatusReceived = 1005
+	CloseInvalidPayload   = 1007
+	ClosePolicyViolation  = 1008
+	CloseMessageTooBig    = 1009
+	CloseInternalError    = 1011
+)
+
+const (
+	maxControlPayload = 125
+	closeTimeout      = 5 * time.Second
+)
+
+var ErrClosed = errors.New("websocket: connection closed")
+
+// CloseError is returned by ReadMessage once the peer has closed the connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with code %d: %s", e.Code, e.Reason)
+}
+
+type frame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+type Conn struct {
+	conn           net.Conn
+	br             *bufio.Reader
+	server         bool
+	maxMessageSize int64
+	subprotocol    string
+
+	writeMu   sync.Mutex
+	closeSent bool
+}
+
+func newConn(conn net.Conn, server bool, maxMessageSize int64) *Conn {
+	return &Conn{
+		conn:           conn,
+		br:             bufio.NewReader(conn),
+		server:         server,
+		maxMessageSize: maxMessageSize,
+	}
+}
+
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ReadMessage returns the next complete data message, reassembling fragments.
+// Pings are answered and pongs skipped along the way. When the peer closes,
+// the close is echoed and a *CloseError returned.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var (
+		messageType MessageType
+		message     []byte
+		fragmented  bool
+	)
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		switch f.opcode {
+		case opPing:
+			if err := c.writeFrame(true, opPong, f.payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(f.payload)
+		case opContinuation:
+			if !fragmented {
+				return 0, nil, c.fail(protocolError(CloseProtocolError, "continuation frame without a message to continue"))
+			}
+		case opText, opBinary:
+			if fragmented {
+				return 0, nil, c.fail(protocolError(CloseProtocolError, "new message before the previous one finished"))
+			}
+			fragmented = true
+			messageType = MessageType(f.opcode)
+		}
+
+		if int64(len(message)+len(f.payload)) > c.maxMessageSize {
+			return 0, nil, c.fail(protocolError(CloseMessageTooBig, "message too big"))
+		}
+		message = append(message, f.payload...)
+		if !f.fin {
+			continue
+		}
+		if messageType == TextMessage && !utf8.Valid(message) {
+			return 0, nil, c.fail(protocolError(CloseInvalidPayload, "text message isn't valid UTF-8"))
+		}
+		return messageType, message, nil
+	}
+}
+
+func (c *Conn) WriteMessage(messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", messageType)
+	}
+	return c.writeFrame(true, byte(messageType), data)
+}
+
+// WriteFragmented sends one message split across several frames.
+func (c *Conn) WriteFragmented(messageType MessageType, fragments ...[]byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", messageType)
+	}
+	if len(fragments) == 0 {
+		return c.WriteMessage(messageType, nil)
+	}
+	// control frames may be interleaved by other writers, but data frames of
+	// another message can't, so hold the lock for the whole message
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	for i, fragment := range fragments {
+		opcode := byte(opContinuation)
+		if i == 0 {
+			opcode = byte(messageType)
+		}
+		if err := c.writeFrameLocked(i == len(fragments)-1, opcode, fragment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return fmt.Errorf("websocket: ping payload too large")
+	}
+	return c.writeFrame(true, opPing, data)
+}
+
+// Close starts the closing handshake and waits briefly for the peer's reply
+// before closing the connection.
+func (c *Conn) Close(code int, reason string) error {
+	if err := c.writeClose(code, reason); err != nil {
+		c.conn.Close()
+		return err
+	}
+	c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+	for {
+		f, err := c.readFrame()
+		if err != nil || f.opcode == opClose {
+			break
+		}
+	}
+	return c.conn.Close()
+}
+
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+	switch {
+	case len(payload) == 1:
+		return c.fail(protocolError(CloseProtocolError, "close frame payload too short"))
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(protocolError(CloseProtocolError, "invalid close code"))
+		}
+		if !utf8.ValidString(closeErr.Reason) {
+			return c.fail(protocolError(CloseInvalidPayload, "close reason isn't valid UTF-8"))
+		}
+	}
+	// echo the close back, unless we started the handshake ourselves
+	code := closeErr.Code
+	if code == CloseNoStatusReceived {
+		code = CloseNormalClosure
+	}
+	c.writeClose(code, "")
+	c.conn.Close()
+	return closeErr
+}
+
+// fail sends a close frame for protocol errors and tears the connection down.
+func (c *Conn) fail(err error) error {
+	var pErr *protocolErr
+	if errors.As(err, &pErr) {
+		c.writeClose(pErr.code, pErr.msg)
+	}
+	c.conn.Close()
+	return err
+}
+
+func (c *Conn) writeClose(code int, reason string) error {
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+	payload := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	copy(payload[2:], reason)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return nil
+	}
+	c.closeSent = true
+	return c.writeFrameLocked(true, opClose, payload)
+}
+
+func (c *Conn) readFrame() (frame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{
+		fin:    header[0]&0x80 != 0,
+		opcode: header[0] & 0x0f,
+	}
+	if header[0]&0x70 != 0 {
+		return f, protocolError(CloseProtocolError, "reserved bits set without a negotiated extension")
+	}
+	switch f.opcode {
+	case opContinuation, opText, opBinary:
+	case opClose, opPing, opPong:
+		if !f.fin {
+			return f, protocolError(CloseProtocolError, "fragmented control frame")
+		}
+	default:
+		return f, protocolError(CloseProtocolError, fmt.Sprintf("unknown opcode %#x", f.opcode))
+	}
+
+	masked := header[1]&0x80 != 0
+	// clients must mask every frame and servers must never mask (RFC 6455 5.1)
+	if masked != c.server {
+		return f, protocolError(CloseProtocolError, "frame masking doesn't match the sender")
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return f, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return f, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+		if length&(1<<63) != 0 {
+			return f, protocolError(CloseProtocolError, "frame length has the most significant bit set")
+		}
+	}
+	if f.opcode >= opClose && length > maxControlPayload {
+		return f, protocolError(CloseProtocolError, "control frame payload too large")
+	}
+	if length > uint64(c.maxMessageSize) {
+		return f, protocolError(CloseMessageTooBig, "frame too big")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return f, err
+		}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, f.payload); err != nil {
+		return f, err
+	}
+	if masked {
+		maskBytes(mask, f.payload)
+	}
+	return f, nil
+}
+
+func (c *Conn) writeFrame(fin bool, opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeFrameLocked(fin, opcode, payload)
+}
+
+func (c *Conn) writeFrameLocked(fin bool, opcode byte, payload []byte) error {
+	if c.closeSent && opcode != opClose {
+		return ErrClosed
+	}
+	buf := make([]byte, 0, 14+len(payload))
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	buf = append(buf, first)
+
+	var maskBit byte
+	if !c.server {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) <= 125:
+		buf = append(buf, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		buf = append(buf, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(payload)))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(len(payload)))
+	}
+
+	if c.server {
+		buf = append(buf, payload...)
+	} else {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		buf = append(buf, mask[:]...)
+		start := len(buf)
+		buf = append(buf, payload...)
+		maskBytes(mask, buf[start:])
+	}
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+func maskBytes(mask [4]byte, data []byte) {
+	for i := range data {
+		data[i] ^= mask[i%4]
+	}
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code >= 1000 && code <= 1011:
+		// reserved codes that must never appear on the wire
+		return code != 1004 && code != CloseNoStatusReceived && code != 1006
+	default:
+		return false
+	}
+}
+
+type protocolErr struct {
+	code int
+	msg  string
+}
+
+func (e *protocolErr) Error() string {
+	return "websocket: " + e.msg
+}
+
+func protocolError(code int, msg string) error {
+	return &protocolErr{code: code, msg: msg}
+}
@@ -0,0 +1,140 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/headers"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+)
+
+// appended to the client's key to prove the server speaks WebSocket (RFC 6455 1.3)
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const defaultMaxMessageSize = 1 << 20
+
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+type Upgrader struct {
+	// Subprotocols the server supports, in order of preference
+	Subprotocols []string
+	// CheckOrigin rejects cross-origin upgrades when it returns false, nil allows every origin
+	CheckOrigin func(req *request.Request) bool
+	// MaxMessageSize caps reassembled messages, 0 means 1 MiB
+	MaxMessageSize int64
+}
+
+// Upgrade performs the opening handshake with the default Upgrader.
+func Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	return (&Upgrader{}).Upgrade(w, req)
+}
+
+// Upgrade validates the client's handshake, answers 101 Switching Protocols
+// and takes over the connection. If the handshake is invalid it writes an
+// error response itself and returns ErrBadHandshake.
+func (u *Upgrader) Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	if err := checkHandshake(req); err != nil {
+		response.WriteError(w, req, response.StatusCodeBadRequest, err.Error())
+		return nil, fmt.Errorf("%w: %v", ErrBadHandshake, err)
+	}
+	if version, _ := req.Headers.Get("Sec-WebSocket-Version"); version != "13" {
+		h := headers.NewHeaders()
+		h.Set("Sec-WebSocket-Version", "13")
+		response.WriteErrorWithHeaders(w, req, response.StatusCodeUpgradeRequired, "Only WebSocket version 13 is supported.", h)
+		return nil, fmt.Errorf("%w: unsupported version %q", ErrBadHandshake, version)
+	}
+	if u.CheckOrigin != nil && !u.CheckOrigin(req) {
+		response.WriteError(w, req, response.StatusCodeForbidden, "Cross-origin WebSocket connections aren't allowed.")
+		return nil, fmt.Errorf("%w: origin not allowed", ErrBadHandshake)
+	}
+
+	key, _ := req.Headers.Get("Sec-WebSocket-Key")
+	h := headers.NewHeaders()
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Accept", AcceptKey(key))
+	subprotocol := u.selectSubprotocol(req)
+	if subprotocol != "" {
+		h.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	if err := w.WriteStatusLine(response.StatusCodeSwitchingProtocols); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	maxMessageSize := u.MaxMessageSize
+	if maxMessageSize == 0 {
+		maxMessageSize = defaultMaxMessageSize
+	}
+	c := newConn(conn, true, maxMessageSize)
+	if len(buffered) > 0 {
+		// the client may have sent frames right behind the handshake
+		c.br = bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), conn))
+	}
+	c.subprotocol = subprotocol
+	return c, nil
+}
+
+// AcceptKey computes Sec-WebSocket-Accept for a client's Sec-WebSocket-Key.
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// IsUpgrade reports whether req asks to switch to WebSocket.
+func IsUpgrade(req *request.Request) bool {
+	return headerHasToken(req.Headers, "Connection", "upgrade") && headerHasToken(req.Headers, "Upgrade", "websocket")
+}
+
+func checkHandshake(req *request.Request) error {
+	if req.RequestLine.Method != "GET" {
+		return fmt.Errorf("WebSocket upgrades must use GET")
+	}
+	if !IsUpgrade(req) {
+		return fmt.Errorf("missing Connection: Upgrade and Upgrade: websocket")
+	}
+	key, ok := req.Headers.Get("Sec-WebSocket-Key")
+	if !ok {
+		return fmt.Errorf("missing Sec-WebSocket-Key")
+	}
+	// the key is a base64-encoded 16 byte nonce
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != 16 {
+		return fmt.Errorf("invalid Sec-WebSocket-Key")
+	}
+	return nil
+}
+
+func (u *Upgrader) selectSubprotocol(req *request.Request) string {
+	requested := req.Headers.Values("Sec-WebSocket-Protocol")
+	for _, supported := range u.Subprotocols {
+		for _, r := range requested {
+			if r == supported {
+				return supported
+			}
+		}
+	}
+	return ""
+}
+
+func headerHasToken(h headers.Headers, name, token string) bool {
+	for _, v := range h.Values(name) {
+		if strings.EqualFold(v, token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,183 @@
+package websocket
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joeljosephwebdev/httpfromtcp/internal/request"
+	"github.com/joeljosephwebdev/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const handshake = "GET /ws HTTP/1.1\r\nHost: localhost\r\nConnection: keep-alive, Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Protocol: chat, superchat\r\n\r\n"
+
+// upgradePair runs the handshake over loopback TCP and returns the server
+// side of the websocket plus a client-side Conn and the raw handshake response
+func upgradePair(t *testing.T, u *Upgrader, raw string) (*Conn, *Conn, string, error) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		req, err := request.RequestFromReader(conn)
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		ws, err := u.Upgrade(response.NewWriter(conn), req)
+		if err != nil {
+			conn.Close()
+		}
+		results <- result{conn: ws, err: err}
+	}()
+
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { clientConn.Close() })
+	_, err = clientConn.Write([]byte(raw))
+	require.NoError(t, err)
+
+	br := bufio.NewReader(clientConn)
+	var resp strings.Builder
+	for {
+		line, err := br.ReadString('\n')
+		resp.WriteString(line)
+		if err != nil || line == "\r\n" {
+			break
+		}
+	}
+	res := <-results
+	client := newConn(clientConn, false, defaultMaxMessageSize)
+	client.br = br
+	return res.conn, client, resp.String(), res.err
+}
+
+func TestAcceptKey(t *testing.T) {
+	// the example from RFC 6455 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestUpgrade(t *testing.T) {
+	server, client, resp, err := upgradePair(t, &Upgrader{Subprotocols: []string{"superchat"}}, handshake)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 101 Switching Protocols\r\n"))
+	assert.Contains(t, resp, "sec-websocket-accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n")
+	assert.Contains(t, resp, "sec-websocket-protocol: superchat\r\n")
+	assert.Equal(t, "superchat", server.Subprotocol())
+
+	// Test: messages both ways
+	require.NoError(t, client.WriteMessage(TextMessage, []byte("hello")))
+	mt, data, err := server.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, mt)
+	assert.Equal(t, "hello", string(data))
+
+	big := []byte(strings.Repeat("x", 70000))
+	require.NoError(t, server.WriteMessage(BinaryMessage, big))
+	mt, data, err = client.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, BinaryMessage, mt)
+	assert.Equal(t, big, data)
+
+	// Test: fragmented message with a ping in the middle
+	require.NoError(t, client.writeFrame(false, opText, []byte("frag")))
+	require.NoError(t, client.Ping([]byte("are you there")))
+	require.NoError(t, client.writeFrame(true, opContinuation, []byte("mented")))
+	mt, data, err = server.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, mt)
+	assert.Equal(t, "fragmented", string(data))
+	f, err := client.readFrame()
+	require.NoError(t, err)
+	assert.Equal(t, byte(opPong), f.opcode)
+	assert.Equal(t, "are you there", string(f.payload))
+
+	// Test: close handshake initiated by the client
+	done := make(chan error, 1)
+	go func() { done <- client.Close(CloseGoingAway, "bye") }()
+	_, _, err = server.ReadMessage()
+	var closeErr *CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseGoingAway, closeErr.Code)
+	assert.Equal(t, "bye", closeErr.Reason)
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("client close handshake didn't complete")
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	// Test: unmasked frames from a client are rejected
+	server, client, _, err := upgradePair(t, &Upgrader{}, handshake)
+	require.NoError(t, err)
+	client.server = true // send unmasked
+	require.NoError(t, client.WriteMessage(TextMessage, []byte("hi")))
+	_, _, err = server.ReadMessage()
+	require.Error(t, err)
+	client.server = false
+	f, err := client.readFrameWithTimeout()
+	require.NoError(t, err)
+	assert.Equal(t, byte(opClose), f.opcode)
+	assert.Equal(t, []byte{0x03, 0xea}, f.payload[:2]) // 1002
+
+	// Test: invalid UTF-8 in a text message
+	server, client, _, err = upgradePair(t, &Upgrader{}, handshake)
+	require.NoError(t, err)
+	require.NoError(t, client.WriteMessage(TextMessage, []byte{0xff, 0xfe}))
+	_, _, err = server.ReadMessage()
+	require.Error(t, err)
+	f, err = client.readFrameWithTimeout()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x03, 0xef}, f.payload[:2]) // 1007
+
+	// Test: messages over the size limit
+	server, client, _, err = upgradePair(t, &Upgrader{MaxMessageSize: 8}, handshake)
+	require.NoError(t, err)
+	require.NoError(t, client.WriteFragmented(BinaryMessage, []byte("12345"), []byte("67890")))
+	_, _, err = server.ReadMessage()
+	require.Error(t, err)
+	f, err = client.readFrameWithTimeout()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x03, 0xf1}, f.payload[:2]) // 1009
+}
+
+func TestBadHandshake(t *testing.T) {
+	// Test: not an upgrade
+	_, _, resp, err := upgradePair(t, &Upgrader{}, "GET /ws HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.ErrorIs(t, err, ErrBadHandshake)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"))
+
+	// Test: unsupported version
+	_, _, resp, err = upgradePair(t, &Upgrader{}, strings.Replace(handshake, "Version: 13", "Version: 8", 1))
+	assert.ErrorIs(t, err, ErrBadHandshake)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 426 Upgrade Required\r\n"))
+	assert.Contains(t, resp, "sec-websocket-version: 13\r\n")
+
+	// Test: origin check
+	_, _, resp, err = upgradePair(t, &Upgrader{CheckOrigin: func(*request.Request) bool { return false }}, handshake)
+	assert.ErrorIs(t, err, ErrBadHandshake)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 403 Forbidden\r\n"))
+}
+
+// readFrameWithTimeout keeps a missing frame from hanging the test
+func (c *Conn) readFrameWithTimeout() (frame, error) {
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	return c.readFrame()
+}